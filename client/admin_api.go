@@ -26,6 +26,7 @@ import (
 	"os"
 	"slices"
 	"strconv"
+	"time"
 )
 
 type GeneralResponse struct {
@@ -43,6 +44,9 @@ type ProxyStatusResp struct {
 	LocalAddr  string `json:"local_addr"`
 	Plugin     string `json:"plugin"`
 	RemoteAddr string `json:"remote_addr"`
+	// Server is the frps the proxy is registered to.
+	Server        string `json:"server,omitempty"`
+	ServerProfile string `json:"server_profile,omitempty"`
 }
 
 func NewProxyStatusResp(status *proxy.WorkingStatus, serverAddr string) ProxyStatusResp {
@@ -81,16 +85,22 @@ func (svr *Service) apiStatus(w http.ResponseWriter, _ *http.Request) {
 		_, _ = w.Write(buf)
 	}()
 
-	svr.ctlMu.RLock()
-	ctl := svr.ctl
-	svr.ctlMu.RUnlock()
-	if ctl == nil {
-		return
-	}
+	for _, s := range svr.services() {
+		s.ctlMu.RLock()
+		ctl := s.ctl
+		server := s.activeServer
+		s.ctlMu.RUnlock()
+		if ctl == nil {
+			continue
+		}
 
-	ps := ctl.pm.GetAllProxyStatus()
-	for _, status := range ps {
-		res[status.Type] = append(res[status.Type], NewProxyStatusResp(status, svr.common.ServerAddr))
+		ps := ctl.pm.GetAllProxyStatus()
+		for _, status := range ps {
+			psr := NewProxyStatusResp(status, server.Addr)
+			psr.Server = server.String()
+			psr.ServerProfile = s.profileName
+			res[status.Type] = append(res[status.Type], psr)
+		}
 	}
 
 	for _, arrs := range res {
@@ -103,6 +113,57 @@ func (svr *Service) apiStatus(w http.ResponseWriter, _ *http.Request) {
 	}
 }
 
+// POST /api/proxy/drain?name=<proxy>&timeout=<seconds>
+func (svr *Service) apiDrainProxy(w http.ResponseWriter, r *http.Request) {
+	res := GeneralResponse{Code: 200}
+
+	log.Infof("Http request [/api/proxy/drain]")
+	defer func() {
+		log.Infof("Http response [/api/proxy/drain], code [%d]", res.Code)
+		w.WriteHeader(res.Code)
+		if len(res.Msg) > 0 {
+			_, _ = w.Write([]byte(res.Msg))
+		}
+	}()
+
+	var timeout time.Duration
+	if s := r.URL.Query().Get("timeout"); s != "" {
+		seconds, err := strconv.Atoi(s)
+		if err != nil || seconds < 0 {
+			res.Code = 400
+			res.Msg = fmt.Sprintf("invalid timeout %q", s)
+			log.Warnf("%s", res.Msg)
+			return
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+	if err := svr.DrainProxy(r.URL.Query().Get("name"), timeout); err != nil {
+		res.Code = 400
+		res.Msg = err.Error()
+		log.Warnf("drain proxy error: %s", res.Msg)
+	}
+}
+
+// POST /api/proxy/resume?name=<proxy>
+func (svr *Service) apiResumeProxy(w http.ResponseWriter, r *http.Request) {
+	res := GeneralResponse{Code: 200}
+
+	log.Infof("Http request [/api/proxy/resume]")
+	defer func() {
+		log.Infof("Http response [/api/proxy/resume], code [%d]", res.Code)
+		w.WriteHeader(res.Code)
+		if len(res.Msg) > 0 {
+			_, _ = w.Write([]byte(res.Msg))
+		}
+	}()
+
+	if err := svr.ResumeProxy(r.URL.Query().Get("name")); err != nil {
+		res.Code = 400
+		res.Msg = err.Error()
+		log.Warnf("resume proxy error: %s", res.Msg)
+	}
+}
+
 // GET /api/config
 func (svr *Service) apiGetConfig(w http.ResponseWriter, _ *http.Request) {
 	res := GeneralResponse{Code: 200}
@@ -50,6 +50,7 @@ type defaultConnectorImpl struct {
 
 	muxSession *fmux.Session
 	quicConn   quic.Connection
+	datagrams  *netpkg.DatagramSession
 	closeOnce  sync.Once
 }
 
@@ -94,11 +95,13 @@ func (c *defaultConnectorImpl) Open() error {
 				MaxIdleTimeout:     time.Duration(c.cfg.Transport.QUIC.MaxIdleTimeout) * time.Second,
 				MaxIncomingStreams: int64(c.cfg.Transport.QUIC.MaxIncomingStreams),
 				KeepAlivePeriod:    time.Duration(c.cfg.Transport.QUIC.KeepalivePeriod) * time.Second,
+				EnableDatagrams:    true,
 			})
 		if err != nil {
 			return err
 		}
 		c.quicConn = conn
+		c.datagrams = netpkg.NewDatagramSession(conn)
 		return nil
 	}
 
@@ -130,7 +133,7 @@ func (c *defaultConnectorImpl) Connect() (net.Conn, error) {
 		if err != nil {
 			return nil, err
 		}
-		return netpkg.QuicStreamToNetConn(stream, c.quicConn), nil
+		return netpkg.QuicStreamToNetConn(stream, c.quicConn, c.datagrams), nil
 	} else if c.muxSession != nil {
 		stream, err := c.muxSession.OpenStream()
 		if err != nil {
@@ -167,6 +170,10 @@ func (c *defaultConnectorImpl) realConnect() (net.Conn, error) {
 		}
 	}
 
+	if c.cfg.Transport.Protocol == "kcp" {
+		return c.connectKCP(tlsConfig)
+	}
+
 	proxyType, addr, auth, err := libnet.ParseProxyURL(c.cfg.Transport.ProxyURL)
 	if err != nil {
 		xl.Errorf("fail to parse proxy url")
@@ -212,6 +219,26 @@ func (c *defaultConnectorImpl) realConnect() (net.Conn, error) {
 	return conn, err
 }
 
+// connectKCP dials frps with the KCP options of the config, the KCP dialer of
+// libnet doesn't support them. Proxies and the local address don't apply to
+// KCP.
+func (c *defaultConnectorImpl) connectKCP(tlsConfig *tls.Config) (net.Conn, error) {
+	addr := net.JoinHostPort(c.cfg.ServerAddr, strconv.Itoa(c.cfg.ServerPort))
+	conn, err := netpkg.DialKCP(addr, &c.cfg.Transport.KCP)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig == nil {
+		return conn, nil
+	}
+	writeHeadByte := netpkg.DialHookCustomTLSHeadByte(true, lo.FromPtr(c.cfg.Transport.TLS.DisableCustomTLSFirstByte))
+	if _, _, err = writeHeadByte(c.ctx, conn, addr); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tls.Client(conn, tlsConfig), nil
+}
+
 func (c *defaultConnectorImpl) Close() error {
 	c.closeOnce.Do(func() {
 		if c.quicConn != nil {
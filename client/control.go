@@ -46,6 +46,8 @@ type SessionContext struct {
 	AuthSetter auth.Setter
 	// Connector is used to create new connections, which could be real TCP connections or virtual streams.
 	Connector Connector
+	// Features negotiated with frps at login.
+	Features msg.Features
 }
 
 type Control struct {
@@ -80,7 +82,7 @@ type Control struct {
 func NewControl(ctx context.Context, sessionCtx *SessionContext) (*Control, error) {
 	// new xlog instance
 	ctl := &Control{
-		ctx:        ctx,
+		ctx:        msg.NewFeaturesContext(ctx, sessionCtx.Features),
 		xl:         xlog.FromContextSafe(ctx),
 		sessionCtx: sessionCtx,
 		doneCh:     make(chan struct{}),
@@ -96,6 +98,7 @@ func NewControl(ctx context.Context, sessionCtx *SessionContext) (*Control, erro
 	} else {
 		ctl.msgDispatcher = msg.NewDispatcher(sessionCtx.Conn)
 	}
+	ctl.msgDispatcher.SetCodec(sessionCtx.Features.MsgCodec())
 	ctl.registerMsgHandlers()
 	ctl.msgTransporter = transport.NewMessageTransporter(ctl.msgDispatcher.SendChannel())
 
@@ -134,7 +137,7 @@ func (ctl *Control) handleReqWorkConn(_ msg.Message) {
 		workConn.Close()
 		return
 	}
-	if err = msg.WriteMsg(workConn, m); err != nil {
+	if err = msg.WriteMsgWithCodec(workConn, m, ctl.sessionCtx.Features.MsgCodec()); err != nil {
 		xl.Warnf("work connection write to server error: %v", err)
 		workConn.Close()
 		return
@@ -0,0 +1,150 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+
+	"golang.org/x/net/http2"
+)
+
+const grpcHealthCheckPath = "/grpc.health.v1.Health/Check"
+
+// grpc.health.v1.HealthCheckResponse.ServingStatus
+var grpcServingStatus = map[uint64]string{
+	0: "UNKNOWN",
+	1: "SERVING",
+	2: "NOT_SERVING",
+	3: "SERVICE_UNKNOWN",
+}
+
+// newGRPCClient returns a client speaking HTTP/2 over cleartext TCP, which is
+// how gRPC servers listen without TLS.
+func newGRPCClient() *http.Client {
+	return &http.Client{
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		},
+	}
+}
+
+func (monitor *Monitor) doGRPCCheck(ctx context.Context) error {
+	// HealthCheckRequest{service = 1}
+	var msg []byte
+	if monitor.grpcService != "" {
+		msg = protoTag(msg, 1, 2)
+		msg = binary.AppendUvarint(msg, uint64(len(monitor.grpcService)))
+		msg = append(msg, monitor.grpcService...)
+	}
+	// uncompressed length-prefixed message
+	frame := binary.BigEndian.AppendUint32([]byte{0}, uint32(len(msg)))
+	frame = append(frame, msg...)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+monitor.addr+grpcHealthCheckPath, bytes.NewReader(frame))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	resp, err := monitor.grpcClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxExpectSize))
+	if err != nil {
+		return fmt.Errorf("do grpc health check, read response error: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("do grpc health check, StatusCode is [%d]", resp.StatusCode)
+	}
+	// a response without message carries the status in the headers
+	status := resp.Trailer.Get("Grpc-Status")
+	if status == "" {
+		status = resp.Header.Get("Grpc-Status")
+	}
+	if status != "0" {
+		return fmt.Errorf("do grpc health check, grpc status is [%s] %s", status, resp.Trailer.Get("Grpc-Message"))
+	}
+
+	servingStatus, err := parseHealthCheckResponse(body)
+	if err != nil {
+		return fmt.Errorf("do grpc health check, %v", err)
+	}
+	if servingStatus != 1 {
+		return fmt.Errorf("do grpc health check, serving status is %s", grpcServingStatus[servingStatus])
+	}
+	return nil
+}
+
+// parseHealthCheckResponse returns the status field of the
+// HealthCheckResponse in a length-prefixed gRPC message.
+func parseHealthCheckResponse(b []byte) (uint64, error) {
+	if len(b) < 5 {
+		return 0, errors.New("short response")
+	}
+	if b[0] != 0 {
+		return 0, errors.New("compressed response is not supported")
+	}
+	size := binary.BigEndian.Uint32(b[1:5])
+	b = b[5:]
+	if uint32(len(b)) < size {
+		return 0, errors.New("short response")
+	}
+	b = b[:size]
+
+	var status uint64
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return 0, errors.New("invalid response")
+		}
+		b = b[n:]
+
+		var skip int
+		switch tag & 7 {
+		case 0: // varint
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return 0, errors.New("invalid response")
+			}
+			if tag>>3 == 1 {
+				status = v
+			}
+			skip = n
+		case 1: // 64-bit
+			skip = 8
+		case 2: // length-delimited
+			l, n := binary.Uvarint(b)
+			if n <= 0 || l > uint64(len(b)) {
+				return 0, errors.New("invalid response")
+			}
+			skip = n + int(l)
+		case 5: // 32-bit
+			skip = 4
+		default:
+			return 0, errors.New("invalid response")
+		}
+		if len(b) < skip {
+			return 0, errors.New("invalid response")
+		}
+		b = b[skip:]
+	}
+	return status, nil
+}
+
+// protoTag appends the tag of a protobuf field.
+func protoTag(b []byte, field, wireType uint64) []byte {
+	return binary.AppendUvarint(b, field<<3|wireType)
+}
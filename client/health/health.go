@@ -21,6 +21,8 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"regexp"
+	"slices"
 	"strings"
 	"time"
 
@@ -30,19 +32,39 @@ import (
 
 var ErrHealthCheckType = errors.New("error health check type")
 
+// maximum number of bytes read from a response to match the expect pattern
+const maxExpectSize = 64 * 1024
+
 type Monitor struct {
-	checkType      string
-	interval       time.Duration
-	timeout        time.Duration
-	maxFailedTimes int
+	checkType        string
+	interval         time.Duration
+	timeout          time.Duration
+	maxFailedTimes   int
+	healthyThreshold int
 
-	// For tcp
+	// For tcp, udp and grpc
 	addr string
+	// For tcp, udp and http
+	expect *regexp.Regexp
+
+	// For tcp and udp
+	send []byte
+
+	// For udp
+	dnsQuery string
 
 	// For http
 	url            string
+	method         string
 	header         http.Header
+	expectedStatus []int
+
+	// For grpc
+	grpcService string
+	grpcClient  *http.Client
+
 	failedTimes    uint64
+	succeededTimes uint64
 	statusOK       bool
 	statusNormalFn func()
 	statusFailedFn func()
@@ -63,6 +85,9 @@ func NewMonitor(ctx context.Context, cfg v1.HealthCheckConfig, addr string,
 	if cfg.MaxFailed <= 0 {
 		cfg.MaxFailed = 1
 	}
+	if cfg.HealthyThreshold <= 0 {
+		cfg.HealthyThreshold = 1
+	}
 	newctx, cancel := context.WithCancel(ctx)
 
 	var url string
@@ -77,21 +102,40 @@ func NewMonitor(ctx context.Context, cfg v1.HealthCheckConfig, addr string,
 	for _, h := range cfg.HTTPHeaders {
 		header.Set(h.Name, h.Value)
 	}
+	// the pattern is checked by config validation
+	var expect *regexp.Regexp
+	if cfg.Expect != "" {
+		expect, _ = regexp.Compile(cfg.Expect)
+	}
 
-	return &Monitor{
-		checkType:      cfg.Type,
-		interval:       time.Duration(cfg.IntervalSeconds) * time.Second,
-		timeout:        time.Duration(cfg.TimeoutSeconds) * time.Second,
-		maxFailedTimes: cfg.MaxFailed,
-		addr:           addr,
-		url:            url,
-		header:         header,
-		statusOK:       false,
-		statusNormalFn: statusNormalFn,
-		statusFailedFn: statusFailedFn,
-		ctx:            newctx,
-		cancel:         cancel,
+	monitor := &Monitor{
+		checkType:        cfg.Type,
+		interval:         time.Duration(cfg.IntervalSeconds) * time.Second,
+		timeout:          time.Duration(cfg.TimeoutSeconds) * time.Second,
+		maxFailedTimes:   cfg.MaxFailed,
+		healthyThreshold: cfg.HealthyThreshold,
+		addr:             addr,
+		expect:           expect,
+		send:             []byte(cfg.Send),
+		dnsQuery:         cfg.DNSQuery,
+		url:              url,
+		method:           strings.ToUpper(cfg.Method),
+		header:           header,
+		expectedStatus:   cfg.ExpectedStatus,
+		grpcService:      cfg.GRPCService,
+		statusOK:         false,
+		statusNormalFn:   statusNormalFn,
+		statusFailedFn:   statusFailedFn,
+		ctx:              newctx,
+		cancel:           cancel,
+	}
+	if monitor.method == "" {
+		monitor.method = http.MethodGet
 	}
+	if cfg.Type == "grpc" {
+		monitor.grpcClient = newGRPCClient()
+	}
+	return monitor
 }
 
 func (monitor *Monitor) Start() {
@@ -100,6 +144,9 @@ func (monitor *Monitor) Start() {
 
 func (monitor *Monitor) Stop() {
 	monitor.cancel()
+	if monitor.grpcClient != nil {
+		monitor.grpcClient.CloseIdleConnections()
+	}
 }
 
 func (monitor *Monitor) checkWorker() {
@@ -119,13 +166,16 @@ func (monitor *Monitor) checkWorker() {
 
 		if err == nil {
 			xl.Tracef("do one health check success")
-			if !monitor.statusOK && monitor.statusNormalFn != nil {
+			monitor.failedTimes = 0
+			monitor.succeededTimes++
+			if !monitor.statusOK && int(monitor.succeededTimes) >= monitor.healthyThreshold && monitor.statusNormalFn != nil {
 				xl.Infof("health check status change to success")
 				monitor.statusOK = true
 				monitor.statusNormalFn()
 			}
 		} else {
 			xl.Warnf("do one health check failed: %v", err)
+			monitor.succeededTimes = 0
 			monitor.failedTimes++
 			if monitor.statusOK && int(monitor.failedTimes) >= monitor.maxFailedTimes && monitor.statusFailedFn != nil {
 				xl.Warnf("health check status change to failed")
@@ -144,6 +194,10 @@ func (monitor *Monitor) doCheck(ctx context.Context) error {
 		return monitor.doTCPCheck(ctx)
 	case "http":
 		return monitor.doHTTPCheck(ctx)
+	case "grpc":
+		return monitor.doGRPCCheck(ctx)
+	case "udp":
+		return monitor.doUDPCheck(ctx)
 	default:
 		return ErrHealthCheckType
 	}
@@ -160,12 +214,44 @@ func (monitor *Monitor) doTCPCheck(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	conn.Close()
-	return nil
+	defer conn.Close()
+	if len(monitor.send) == 0 && monitor.expect == nil {
+		return nil
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if len(monitor.send) > 0 {
+		if _, err := conn.Write(monitor.send); err != nil {
+			return err
+		}
+	}
+	if monitor.expect == nil {
+		return nil
+	}
+
+	// read until the response matches, the server closes the connection or
+	// the check times out
+	var (
+		resp []byte
+		buf  = make([]byte, 4096)
+	)
+	for len(resp) < maxExpectSize {
+		n, err := conn.Read(buf)
+		resp = append(resp, buf[:n]...)
+		if monitor.expect.Match(resp) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("do tcp health check, response %q does not match expect: %v", truncate(resp), err)
+		}
+	}
+	return fmt.Errorf("do tcp health check, response %q does not match expect", truncate(resp))
 }
 
 func (monitor *Monitor) doHTTPCheck(ctx context.Context) error {
-	req, err := http.NewRequestWithContext(ctx, "GET", monitor.url, nil)
+	req, err := http.NewRequestWithContext(ctx, monitor.method, monitor.url, nil)
 	if err != nil {
 		return err
 	}
@@ -176,10 +262,33 @@ func (monitor *Monitor) doHTTPCheck(ctx context.Context) error {
 		return err
 	}
 	defer resp.Body.Close()
+
+	var body []byte
+	if monitor.expect != nil {
+		body, err = io.ReadAll(io.LimitReader(resp.Body, maxExpectSize))
+		if err != nil {
+			return fmt.Errorf("do http health check, read body error: %v", err)
+		}
+	}
 	_, _ = io.Copy(io.Discard, resp.Body)
 
-	if resp.StatusCode/100 != 2 {
+	if len(monitor.expectedStatus) > 0 {
+		if !slices.Contains(monitor.expectedStatus, resp.StatusCode) {
+			return fmt.Errorf("do http health check, StatusCode is [%d] not in %v", resp.StatusCode, monitor.expectedStatus)
+		}
+	} else if resp.StatusCode/100 != 2 {
 		return fmt.Errorf("do http health check, StatusCode is [%d] not 2xx", resp.StatusCode)
 	}
+	if monitor.expect != nil && !monitor.expect.Match(body) {
+		return fmt.Errorf("do http health check, body %q does not match expect", truncate(body))
+	}
 	return nil
 }
+
+// truncate shortens a response for logging.
+func truncate(b []byte) []byte {
+	if len(b) > 64 {
+		b = b[:64]
+	}
+	return b
+}
@@ -0,0 +1,177 @@
+package health
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	v1 "github.com/fatedier/frp/pkg/config/v1"
+)
+
+func newTestMonitor(cfg v1.HealthCheckConfig, addr string) *Monitor {
+	return NewMonitor(context.Background(), cfg, addr, nil, nil)
+}
+
+func doTestCheck(m *Monitor) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return m.doCheck(ctx)
+}
+
+func TestTCPCheckSendExpect(t *testing.T) {
+	require := require.New(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				line, _ := bufio.NewReader(c).ReadString('\n')
+				if line == "PING\r\n" {
+					_, _ = io.WriteString(c, "+PONG\r\n")
+				} else {
+					_, _ = io.WriteString(c, "-ERR\r\n")
+				}
+			}()
+		}
+	}()
+	addr := ln.Addr().String()
+
+	m := newTestMonitor(v1.HealthCheckConfig{Type: "tcp", Send: "PING\r\n", Expect: `^\+PONG`}, addr)
+	require.NoError(doTestCheck(m))
+
+	m = newTestMonitor(v1.HealthCheckConfig{Type: "tcp", Send: "QUIT\r\n", Expect: `^\+PONG`}, addr)
+	require.Error(doTestCheck(m))
+}
+
+func TestHTTPCheckExpect(t *testing.T) {
+	require := require.New(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead && r.URL.Path == "/down" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_, _ = io.WriteString(w, `{"status":"`+strings.TrimPrefix(r.URL.Path, "/")+`"}`)
+	}))
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	m := newTestMonitor(v1.HealthCheckConfig{Type: "http", Path: "/up", Expect: `"status":"up"`}, addr)
+	require.NoError(doTestCheck(m))
+
+	m = newTestMonitor(v1.HealthCheckConfig{Type: "http", Path: "/degraded", Expect: `"status":"up"`}, addr)
+	require.Error(doTestCheck(m))
+
+	m = newTestMonitor(v1.HealthCheckConfig{Type: "http", Path: "/down"}, addr)
+	require.Error(doTestCheck(m))
+
+	m = newTestMonitor(v1.HealthCheckConfig{Type: "http", Path: "/down", ExpectedStatus: []int{200, 503}}, addr)
+	require.NoError(doTestCheck(m))
+
+	m = newTestMonitor(v1.HealthCheckConfig{Type: "http", Path: "/down", Method: "head"}, addr)
+	require.NoError(doTestCheck(m))
+}
+
+func TestGRPCCheck(t *testing.T) {
+	require := require.New(t)
+	status := map[string]uint64{"": 1, "db": 2}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != grpcHealthCheckPath || r.Header.Get("Content-Type") != "application/grpc" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		service := ""
+		if len(body) > 7 {
+			service = string(body[7:])
+		}
+		s, ok := status[service]
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		if !ok {
+			// NOT_FOUND
+			w.Header().Set("Grpc-Status", "5")
+			return
+		}
+		msg := binary.AppendUvarint([]byte{0x08}, s)
+		_, _ = w.Write(binary.BigEndian.AppendUint32([]byte{0}, uint32(len(msg))))
+		_, _ = w.Write(msg)
+		w.Header().Set("Grpc-Status", "0")
+	})
+	srv := httptest.NewServer(h2c.NewHandler(handler, &http2.Server{}))
+	defer srv.Close()
+	addr := strings.TrimPrefix(srv.URL, "http://")
+
+	m := newTestMonitor(v1.HealthCheckConfig{Type: "grpc"}, addr)
+	require.NoError(doTestCheck(m))
+
+	m = newTestMonitor(v1.HealthCheckConfig{Type: "grpc", GRPCService: "db"}, addr)
+	require.ErrorContains(doTestCheck(m), "NOT_SERVING")
+
+	m = newTestMonitor(v1.HealthCheckConfig{Type: "grpc", GRPCService: "cache"}, addr)
+	require.ErrorContains(doTestCheck(m), "grpc status is [5]")
+}
+
+func TestUDPCheck(t *testing.T) {
+	require := require.New(t)
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(err)
+	defer pc.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var p dnsmessage.Parser
+			h, err := p.Start(buf[:n])
+			if err != nil {
+				// echo
+				_, _ = pc.WriteTo(buf[:n], addr)
+				continue
+			}
+			q, _ := p.Question()
+			// a stray reply first, which should be ignored
+			_, _ = pc.WriteTo([]byte("stray"), addr)
+			h.Response = true
+			if q.Name.String() != "example.com." {
+				h.RCode = dnsmessage.RCodeServerFailure
+			}
+			b := dnsmessage.NewBuilder(nil, h)
+			_ = b.StartQuestions()
+			_ = b.Question(q)
+			resp, _ := b.Finish()
+			_, _ = pc.WriteTo(resp, addr)
+		}
+	}()
+	addr := pc.LocalAddr().String()
+
+	m := newTestMonitor(v1.HealthCheckConfig{Type: "udp", Send: "ping", Expect: "^ping$"}, addr)
+	require.NoError(doTestCheck(m))
+
+	m = newTestMonitor(v1.HealthCheckConfig{Type: "udp", DNSQuery: "example.com"}, addr)
+	require.NoError(doTestCheck(m))
+
+	m = newTestMonitor(v1.HealthCheckConfig{Type: "udp", DNSQuery: "broken.example.com"}, addr)
+	require.ErrorContains(doTestCheck(m), "RCodeServerFailure")
+
+	m = newTestMonitor(v1.HealthCheckConfig{Type: "udp", Send: "ping", Expect: "^pong$", TimeoutSeconds: 1}, addr)
+	require.Error(doTestCheck(m))
+}
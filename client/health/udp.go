@@ -0,0 +1,80 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func (monitor *Monitor) doUDPCheck(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", monitor.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	payload := monitor.send
+	var dnsID uint16
+	if monitor.dnsQuery != "" {
+		dnsID = uint16(rand.Uint32())
+		if payload, err = newDNSQuery(dnsID, monitor.dnsQuery); err != nil {
+			return err
+		}
+	}
+	if _, err := conn.Write(payload); err != nil {
+		return err
+	}
+
+	// ignore stray datagrams until a matching reply arrives or the check
+	// times out
+	buf := make([]byte, maxExpectSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return fmt.Errorf("do udp health check, no reply: %v", err)
+		}
+		reply := buf[:n]
+
+		if monitor.dnsQuery != "" {
+			var p dnsmessage.Parser
+			h, err := p.Start(reply)
+			if err != nil || h.ID != dnsID || !h.Response {
+				continue
+			}
+			if h.RCode != dnsmessage.RCodeSuccess && h.RCode != dnsmessage.RCodeNameError {
+				return fmt.Errorf("do udp health check, dns response code is %v", h.RCode)
+			}
+			return nil
+		}
+		if monitor.expect == nil || monitor.expect.Match(reply) {
+			return nil
+		}
+	}
+}
+
+func newDNSQuery(id uint16, domain string) ([]byte, error) {
+	name, err := dnsmessage.NewName(strings.TrimSuffix(domain, ".") + ".")
+	if err != nil {
+		return nil, err
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(dnsmessage.Question{
+		Name:  name,
+		Type:  dnsmessage.TypeA,
+		Class: dnsmessage.ClassINET,
+	}); err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"net"
+	"strconv"
+	"sync/atomic"
+
+	v1 "github.com/fatedier/frp/pkg/config/v1"
+)
+
+// backend is a local service of a proxy.
+type backend struct {
+	addr string
+	down atomic.Bool
+}
+
+// backendPool holds the local services of a proxy, the first one is
+// LocalIP:LocalPort and the rest are from Backends.
+type backendPool struct {
+	roundRobin bool
+	backends   []*backend
+	index      atomic.Uint64
+}
+
+func newBackendPool(cfg *v1.ProxyBaseConfig) *backendPool {
+	p := &backendPool{
+		roundRobin: cfg.BackendsMode == "round_robin",
+	}
+	if cfg.LocalPort > 0 {
+		p.add(cfg.LocalIP, cfg.LocalPort)
+	}
+	for _, b := range cfg.Backends {
+		p.add(b.LocalIP, b.LocalPort)
+	}
+	return p
+}
+
+func (p *backendPool) add(ip string, port int) {
+	p.backends = append(p.backends, &backend{addr: net.JoinHostPort(ip, strconv.Itoa(port))})
+}
+
+// available reports whether any backend is up.
+func (p *backendPool) available() bool {
+	for _, b := range p.backends {
+		if !b.down.Load() {
+			return true
+		}
+	}
+	return false
+}
+
+// candidates returns the addresses to dial for a new connection in order.
+// Backends that are down come last, they are only tried when all the others
+// fail since their health may have changed since the last check.
+func (p *backendPool) candidates() []string {
+	n := len(p.backends)
+	start := 0
+	if p.roundRobin && n > 0 {
+		start = int((p.index.Add(1) - 1) % uint64(n))
+	}
+	up := make([]string, 0, n)
+	var down []string
+	for i := range n {
+		b := p.backends[(start+i)%n]
+		if b.down.Load() {
+			down = append(down, b.addr)
+		} else {
+			up = append(up, b.addr)
+		}
+	}
+	return append(up, down...)
+}
@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/fatedier/frp/pkg/config/v1"
+	"github.com/fatedier/frp/pkg/msg"
+	"github.com/fatedier/frp/pkg/util/xlog"
+)
+
+func TestBackendPoolCandidates(t *testing.T) {
+	require := require.New(t)
+	cfg := &v1.ProxyBaseConfig{
+		ProxyBackend: v1.ProxyBackend{
+			LocalIP:   "127.0.0.1",
+			LocalPort: 1,
+			Backends:  []v1.LocalBackend{{LocalIP: "127.0.0.1", LocalPort: 2}, {LocalIP: "127.0.0.1", LocalPort: 3}},
+		},
+	}
+	p := newBackendPool(cfg)
+	require.Equal([]string{"127.0.0.1:1", "127.0.0.1:2", "127.0.0.1:3"}, p.candidates())
+
+	p.backends[0].down.Store(true)
+	require.Equal([]string{"127.0.0.1:2", "127.0.0.1:3", "127.0.0.1:1"}, p.candidates())
+	require.True(p.available())
+
+	cfg.BackendsMode = "round_robin"
+	p = newBackendPool(cfg)
+	require.Equal("127.0.0.1:1", p.candidates()[0])
+	require.Equal("127.0.0.1:2", p.candidates()[0])
+	require.Equal("127.0.0.1:3", p.candidates()[0])
+	require.Equal("127.0.0.1:1", p.candidates()[0])
+}
+
+func TestDialLocalFailover(t *testing.T) {
+	require := require.New(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+	defer ln.Close()
+	// a port nothing listens on
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	cfg := &v1.ProxyBaseConfig{
+		ProxyBackend: v1.ProxyBackend{
+			LocalIP:   "127.0.0.1",
+			LocalPort: closedPort,
+			Backends:  []v1.LocalBackend{{LocalIP: "127.0.0.1", LocalPort: ln.Addr().(*net.TCPAddr).Port}},
+		},
+	}
+	pxy := &BaseProxy{
+		baseCfg:  cfg,
+		backends: newBackendPool(cfg),
+		xl:       xlog.New(),
+		ctx:      context.Background(),
+	}
+	conn, err := pxy.dialLocal(&msg.StartWorkConn{})
+	require.NoError(err)
+	defer conn.Close()
+	require.Equal(ln.Addr().String(), conn.RemoteAddr().String())
+}
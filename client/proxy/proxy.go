@@ -15,8 +15,9 @@
 package proxy
 
 import (
-	"cmp"
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"net"
 	"reflect"
@@ -36,6 +37,7 @@ import (
 	plugin "github.com/fatedier/frp/pkg/plugin/client"
 	"github.com/fatedier/frp/pkg/transport"
 	"github.com/fatedier/frp/pkg/util/limit"
+	netpkg "github.com/fatedier/frp/pkg/util/net"
 	"github.com/fatedier/frp/pkg/util/xlog"
 )
 
@@ -60,16 +62,29 @@ func NewProxy(
 	clientCfg *v1.ClientCommonConfig,
 	msgTransporter transport.MessageTransporter,
 ) (pxy Proxy) {
-	var limiter *rate.Limiter
-	limitBytes := pxyConf.GetBaseConfig().Transport.BandwidthLimit.Bytes()
-	if limitBytes > 0 && pxyConf.GetBaseConfig().Transport.BandwidthLimitMode == types.BandwidthLimitModeClient {
-		limiter = rate.NewLimiter(rate.Limit(float64(limitBytes)), int(limitBytes))
+	return newProxy(ctx, pxyConf, clientCfg, msgTransporter, newBackendPool(pxyConf.GetBaseConfig()))
+}
+
+func newProxy(
+	ctx context.Context,
+	pxyConf v1.ProxyConfigurer,
+	clientCfg *v1.ClientCommonConfig,
+	msgTransporter transport.MessageTransporter,
+	backends *backendPool,
+) Proxy {
+	var upLimiter, downLimiter *rate.Limiter
+	if transport := pxyConf.GetBaseConfig().Transport; transport.BandwidthLimitMode == types.BandwidthLimitModeClient {
+		up, down := transport.BandwidthLimits()
+		upLimiter = limit.NewLimiter(up, transport.BandwidthLimitBurst.Bytes())
+		downLimiter = limit.NewLimiter(down, transport.BandwidthLimitBurst.Bytes())
 	}
 
 	baseProxy := BaseProxy{
 		baseCfg:        pxyConf.GetBaseConfig(),
 		clientCfg:      clientCfg,
-		limiter:        limiter,
+		upLimiter:      upLimiter,
+		downLimiter:    downLimiter,
+		backends:       backends,
 		msgTransporter: msgTransporter,
 		xl:             xlog.FromContextSafe(ctx),
 		ctx:            ctx,
@@ -86,7 +101,9 @@ type BaseProxy struct {
 	baseCfg        *v1.ProxyBaseConfig
 	clientCfg      *v1.ClientCommonConfig
 	msgTransporter transport.MessageTransporter
-	limiter        *rate.Limiter
+	upLimiter      *rate.Limiter
+	downLimiter    *rate.Limiter
+	backends       *backendPool
 	// proxyPlugin is used to handle connections instead of dialing to local service.
 	// It's only validate for TCP protocol now.
 	proxyPlugin        plugin.Plugin
@@ -127,6 +144,12 @@ func (pxy *BaseProxy) InWorkConn(conn net.Conn, m *msg.StartWorkConn) {
 	pxy.HandleTCPWorkConnection(conn, m, []byte(pxy.clientCfg.Auth.Token))
 }
 
+// limitWorkConn applies the bandwidth limits to a work connection, reading
+// from it is download and writing to it is upload.
+func (pxy *BaseProxy) limitWorkConn(conn net.Conn) io.ReadWriteCloser {
+	return limit.NewReadWriteCloser(conn, pxy.downLimiter, pxy.upLimiter)
+}
+
 // Common handler for tcp work connections.
 func (pxy *BaseProxy) HandleTCPWorkConnection(workConn net.Conn, m *msg.StartWorkConn, encKey []byte) {
 	xl := pxy.xl
@@ -135,12 +158,7 @@ func (pxy *BaseProxy) HandleTCPWorkConnection(workConn net.Conn, m *msg.StartWor
 		remote io.ReadWriteCloser
 		err    error
 	)
-	remote = workConn
-	if pxy.limiter != nil {
-		remote = libio.WrapReadWriteCloser(limit.NewReader(workConn, pxy.limiter), limit.NewWriter(workConn, pxy.limiter), func() error {
-			return workConn.Close()
-		})
-	}
+	remote = pxy.limitWorkConn(workConn)
 
 	xl.Tracef("handle tcp work connection, useEncryption: %t, useCompression: %t",
 		baseCfg.Transport.UseEncryption, baseCfg.Transport.UseCompression)
@@ -186,6 +204,9 @@ func (pxy *BaseProxy) HandleTCPWorkConnection(workConn net.Conn, m *msg.StartWor
 			h.Version = 1
 		} else if baseCfg.Transport.ProxyProtocolVersion == "v2" {
 			h.Version = 2
+			if err := h.SetTLVs(pxy.proxyProtocolTLVs(m.VisitorUser)); err != nil {
+				xl.Warnf("set proxy protocol tlvs error: %v", err)
+			}
 		}
 		extraInfo.ProxyProtocolHeader = h
 	}
@@ -193,18 +214,29 @@ func (pxy *BaseProxy) HandleTCPWorkConnection(workConn net.Conn, m *msg.StartWor
 	if pxy.proxyPlugin != nil {
 		// if plugin is set, let plugin handle connection first
 		xl.Debugf("handle by plugin: %s", pxy.proxyPlugin.Name())
+		if m.TargetDialReply {
+			// the plugin takes the connection, there is no dial to report
+			if err := sendTargetDialResp(remote, nil); err != nil {
+				workConn.Close()
+				xl.Warnf("send target dial result error: %v", err)
+				return
+			}
+		}
 		pxy.proxyPlugin.Handle(pxy.ctx, remote, workConn, &extraInfo)
 		xl.Debugf("handle by plugin finished")
 		return
 	}
 
-	localConn, err := libnet.Dial(
-		cmp.Or(m.TargetAddr, net.JoinHostPort(baseCfg.LocalIP, strconv.Itoa(baseCfg.LocalPort))),
-		libnet.WithTimeout(10*time.Second),
-	)
+	localConn, err := pxy.dialLocal(m)
+	if m.TargetDialReply {
+		if werr := sendTargetDialResp(remote, err); werr != nil && err == nil {
+			localConn.Close()
+			err = werr
+		}
+	}
 	if err != nil {
 		workConn.Close()
-		xl.Errorf("connect to local service [%s:%d] error: %v", baseCfg.LocalIP, baseCfg.LocalPort, err)
+		xl.Errorf("connect to local service error: %v", err)
 		return
 	}
 
@@ -228,3 +260,63 @@ func (pxy *BaseProxy) HandleTCPWorkConnection(workConn net.Conn, m *msg.StartWor
 		compressionResourceRecycleFn()
 	}
 }
+
+// sendTargetDialResp sends the result of dialing the target of a visitor
+// connection.
+func sendTargetDialResp(remote io.Writer, dialErr error) error {
+	resp := &msg.TargetDialResp{Status: netpkg.TargetDialStatus(dialErr)}
+	if dialErr != nil {
+		resp.Error = dialErr.Error()
+	}
+	return msg.WriteMsg(remote, resp)
+}
+
+// proxyProtocolTLVs returns the frp information added to PROXY protocol v2
+// headers.
+func (pxy *BaseProxy) proxyProtocolTLVs(visitorUser string) []pp.TLV {
+	tlvs := []pp.TLV{{Type: netpkg.PP2TypeFRPProxyName, Value: []byte(pxy.baseCfg.Name)}}
+	if pxy.clientCfg.User != "" {
+		tlvs = append(tlvs, pp.TLV{Type: netpkg.PP2TypeFRPUser, Value: []byte(pxy.clientCfg.User)})
+	}
+	if visitorUser != "" {
+		tlvs = append(tlvs, pp.TLV{Type: netpkg.PP2TypeFRPVisitorUser, Value: []byte(visitorUser)})
+	}
+	return tlvs
+}
+
+// dialLocal connects to the target requested by the visitor if there is one,
+// otherwise to the first local service that accepts the connection.
+func (pxy *BaseProxy) dialLocal(m *msg.StartWorkConn) (net.Conn, error) {
+	timeout := 10 * time.Second
+	if m.TargetDialTimeout > 0 {
+		timeout = time.Duration(m.TargetDialTimeout) * time.Millisecond
+	}
+	if m.TargetAddr != "" {
+		return pxy.dialAddr(m.TargetNetwork, m.TargetAddr, timeout)
+	}
+
+	var errs []error
+	for _, addr := range pxy.backends.candidates() {
+		conn, err := pxy.dialAddr(m.TargetNetwork, addr, timeout)
+		if err == nil {
+			return conn, nil
+		}
+		pxy.xl.Debugf("connect to local service [%s] error: %v", addr, err)
+		errs = append(errs, fmt.Errorf("[%s] %v", addr, err))
+	}
+	if len(errs) == 0 {
+		return nil, errors.New("no local service")
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (pxy *BaseProxy) dialAddr(network, addr string, timeout time.Duration) (net.Conn, error) {
+	if network == "udp" {
+		conn, err := (&net.Dialer{Timeout: timeout}).DialContext(pxy.ctx, "udp", addr)
+		if err != nil {
+			return nil, err
+		}
+		return netpkg.NewPacketStreamConn(conn), nil
+	}
+	return libnet.Dial(addr, libnet.WithTimeout(timeout))
+}
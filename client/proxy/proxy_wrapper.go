@@ -18,17 +18,20 @@ import (
 	"context"
 	"fmt"
 	"net"
-	"strconv"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/fatedier/golib/errors"
+	"github.com/samber/lo"
 
 	"github.com/fatedier/frp/client/event"
 	"github.com/fatedier/frp/client/health"
+	"github.com/fatedier/frp/pkg/config/types"
 	v1 "github.com/fatedier/frp/pkg/config/v1"
 	"github.com/fatedier/frp/pkg/msg"
+	"github.com/fatedier/frp/pkg/proto/udp"
 	"github.com/fatedier/frp/pkg/transport"
 	"github.com/fatedier/frp/pkg/util/xlog"
 )
@@ -39,6 +42,7 @@ const (
 	ProxyPhaseStartErr    = "start error"
 	ProxyPhaseRunning     = "running"
 	ProxyPhaseCheckFailed = "check failed"
+	ProxyPhaseDraining    = "draining"
 	ProxyPhaseClosed      = "closed"
 )
 
@@ -65,9 +69,12 @@ type Wrapper struct {
 	// underlying proxy
 	pxy Proxy
 
+	// local services of the proxy
+	backends *backendPool
+
 	// if ProxyConf has healcheck config
-	// monitor will watch if it is alive
-	monitor *health.Monitor
+	// monitors will watch if the local services are alive
+	monitors []*health.Monitor
 
 	// event handler
 	handler event.Handler
@@ -109,15 +116,19 @@ func NewWrapper(
 		ctx:            xlog.NewContext(ctx, xl),
 	}
 
+	pw.backends = newBackendPool(baseInfo)
 	if baseInfo.HealthCheck.Type != "" && baseInfo.LocalPort > 0 {
 		pw.health = 1 // means failed
-		addr := net.JoinHostPort(baseInfo.LocalIP, strconv.Itoa(baseInfo.LocalPort))
-		pw.monitor = health.NewMonitor(pw.ctx, baseInfo.HealthCheck, addr,
-			pw.statusNormalCallback, pw.statusFailedCallback)
+		for _, b := range pw.backends.backends {
+			b.down.Store(true)
+			pw.monitors = append(pw.monitors, health.NewMonitor(pw.ctx, baseInfo.HealthCheck, b.addr,
+				func() { pw.backendStatusCallback(b, true) },
+				func() { pw.backendStatusCallback(b, false) }))
+		}
 		xl.Tracef("enable health check monitor")
 	}
 
-	pw.pxy = NewProxy(pw.ctx, pw.Cfg, clientCfg, pw.msgTransporter)
+	pw.pxy = newProxy(pw.ctx, pw.Cfg, clientCfg, pw.msgTransporter, pw.backends)
 	return pw
 }
 
@@ -141,7 +152,7 @@ func (pw *Wrapper) SetRunningStatus(remoteAddr string, respErr string) error {
 	}
 
 	if err := pw.pxy.Run(); err != nil {
-		pw.close()
+		pw.close(0)
 		pw.Phase = ProxyPhaseStartErr
 		pw.Err = err.Error()
 		pw.lastStartErr = time.Now()
@@ -155,8 +166,8 @@ func (pw *Wrapper) SetRunningStatus(remoteAddr string, respErr string) error {
 
 func (pw *Wrapper) Start() {
 	go pw.checkWorker()
-	if pw.monitor != nil {
-		go pw.monitor.Start()
+	for _, monitor := range pw.monitors {
+		go monitor.Start()
 	}
 }
 
@@ -166,24 +177,71 @@ func (pw *Wrapper) Stop() {
 	close(pw.closeCh)
 	close(pw.healthNotifyCh)
 	pw.pxy.Close()
-	if pw.monitor != nil {
-		pw.monitor.Stop()
+	for _, monitor := range pw.monitors {
+		monitor.Stop()
 	}
 	pw.Phase = ProxyPhaseClosed
-	pw.close()
+	pw.close(0)
+}
+
+// Drain takes the proxy out of service, the connections in use keep running
+// for up to timeout, or the configured drain timeout if it is 0. The proxy
+// stays out of service until Resume is called.
+func (pw *Wrapper) Drain(timeout time.Duration) error {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	if pw.Phase != ProxyPhaseRunning && pw.Phase != ProxyPhaseWaitStart {
+		return fmt.Errorf("proxy is %s, not running", pw.Phase)
+	}
+	if timeout <= 0 {
+		timeout = pw.drainTimeout()
+	}
+	pw.close(timeout)
+	pw.xl.Infof("change status from [%s] to [%s]", pw.Phase, ProxyPhaseDraining)
+	pw.Phase = ProxyPhaseDraining
+	return nil
+}
+
+// Resume puts a drained proxy back into service.
+func (pw *Wrapper) Resume() error {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	if pw.Phase != ProxyPhaseDraining {
+		return fmt.Errorf("proxy is %s, not draining", pw.Phase)
+	}
+	pw.xl.Infof("change status from [%s] to [%s]", pw.Phase, ProxyPhaseNew)
+	pw.Phase = ProxyPhaseNew
+	_ = errors.PanicToError(func() {
+		select {
+		case pw.healthNotifyCh <- struct{}{}:
+		default:
+		}
+	})
+	return nil
+}
+
+func (pw *Wrapper) drainTimeout() time.Duration {
+	return time.Duration(pw.Cfg.GetBaseConfig().Transport.DrainTimeoutSeconds) * time.Second
 }
 
-func (pw *Wrapper) close() {
+// close asks the server to close the proxy, the connections in use are closed
+// after drainTimeout.
+func (pw *Wrapper) close(drainTimeout time.Duration) {
+	if drainTimeout > 0 && !msg.FeaturesFromContext(pw.ctx).Has(msg.FeatureProxyDrain) {
+		pw.xl.Warnf("server doesn't support drain timeouts, connections in use run until they finish")
+		drainTimeout = 0
+	}
 	_ = pw.handler(&event.CloseProxyPayload{
 		CloseProxyMsg: &msg.CloseProxy{
-			ProxyName: pw.Name,
+			ProxyName:    pw.Name,
+			DrainTimeout: int64(drainTimeout / time.Second),
 		},
 	})
 }
 
 func (pw *Wrapper) checkWorker() {
 	xl := pw.xl
-	if pw.monitor != nil {
+	if len(pw.monitors) > 0 {
 		// let monitor do check request first
 		time.Sleep(500 * time.Millisecond)
 	}
@@ -202,6 +260,17 @@ func (pw *Wrapper) checkWorker() {
 
 				var newProxyMsg msg.NewProxy
 				pw.Cfg.MarshalToMsg(&newProxyMsg)
+				if slices.Contains([]string{string(v1.ProxyTypeUDP), string(v1.ProxyTypeSUDP)}, newProxyMsg.ProxyType) {
+					features := msg.FeaturesFromContext(pw.ctx)
+					if features.Has(msg.FeatureUDPBinaryFraming) {
+						newProxyMsg.UDPFraming = udp.FramingBinary
+					}
+					// the bandwidth limits of frpc only apply to work connections
+					transportCfg := pw.Cfg.GetBaseConfig().Transport
+					up, down := transportCfg.BandwidthLimits()
+					limited := transportCfg.BandwidthLimitMode == types.BandwidthLimitModeClient && (up > 0 || down > 0)
+					newProxyMsg.UDPDatagram = features.Has(msg.FeatureUDPDatagram) && !limited
+				}
 				pw.lastSendStartMsg = now
 				_ = pw.handler(&event.StartProxyPayload{
 					NewProxyMsg: &newProxyMsg,
@@ -211,7 +280,7 @@ func (pw *Wrapper) checkWorker() {
 		} else {
 			pw.mu.Lock()
 			if pw.Phase == ProxyPhaseRunning || pw.Phase == ProxyPhaseWaitStart {
-				pw.close()
+				pw.close(pw.drainTimeout())
 				xl.Tracef("change status from [%s] to [%s]", pw.Phase, ProxyPhaseCheckFailed)
 				pw.Phase = ProxyPhaseCheckFailed
 			}
@@ -227,6 +296,22 @@ func (pw *Wrapper) checkWorker() {
 	}
 }
 
+// backendStatusCallback records the health of a local service, the proxy is
+// healthy as long as one of its local services is.
+func (pw *Wrapper) backendStatusCallback(b *backend, ok bool) {
+	b.down.Store(!ok)
+	if len(pw.backends.backends) > 1 {
+		pw.xl.Infof("local service [%s] health check %s", b.addr, lo.Ternary(ok, "success", "failed"))
+	}
+	if pw.backends.available() {
+		if atomic.LoadUint32(&pw.health) != 0 {
+			pw.statusNormalCallback()
+		}
+	} else {
+		pw.statusFailedCallback()
+	}
+}
+
 func (pw *Wrapper) statusNormalCallback() {
 	xl := pw.xl
 	atomic.StoreUint32(&pw.health, 0)
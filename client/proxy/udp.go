@@ -15,7 +15,6 @@
 package proxy
 
 import (
-	"io"
 	"net"
 	"reflect"
 	"strconv"
@@ -23,11 +22,11 @@ import (
 
 	"github.com/fatedier/golib/errors"
 	libio "github.com/fatedier/golib/io"
+	pp "github.com/pires/go-proxyproto"
 
 	v1 "github.com/fatedier/frp/pkg/config/v1"
 	"github.com/fatedier/frp/pkg/msg"
 	"github.com/fatedier/frp/pkg/proto/udp"
-	"github.com/fatedier/frp/pkg/util/limit"
 	netpkg "github.com/fatedier/frp/pkg/util/net"
 )
 
@@ -46,6 +45,7 @@ type UDPProxy struct {
 	// include msg.UDPPacket and msg.Ping
 	sendCh   chan msg.Message
 	workConn net.Conn
+	msgConn  *udp.MsgConn
 	closed   bool
 }
 
@@ -74,8 +74,8 @@ func (pxy *UDPProxy) Close() {
 
 	if !pxy.closed {
 		pxy.closed = true
-		if pxy.workConn != nil {
-			pxy.workConn.Close()
+		if pxy.msgConn != nil {
+			pxy.msgConn.Close()
 		}
 		if pxy.readCh != nil {
 			close(pxy.readCh)
@@ -86,19 +86,15 @@ func (pxy *UDPProxy) Close() {
 	}
 }
 
-func (pxy *UDPProxy) InWorkConn(conn net.Conn, _ *msg.StartWorkConn) {
+func (pxy *UDPProxy) InWorkConn(conn net.Conn, m *msg.StartWorkConn) {
 	xl := pxy.xl
 	xl.Infof("incoming a new work connection for udp proxy, %s", conn.RemoteAddr().String())
 	// close resources related with old workConn
 	pxy.Close()
 
-	var rwc io.ReadWriteCloser = conn
+	datagrams := netpkg.GetDatagramSession(conn)
+	rwc := pxy.limitWorkConn(conn)
 	var err error
-	if pxy.limiter != nil {
-		rwc = libio.WrapReadWriteCloser(limit.NewReader(conn, pxy.limiter), limit.NewWriter(conn, pxy.limiter), func() error {
-			return conn.Close()
-		})
-	}
 	if pxy.cfg.Transport.UseEncryption {
 		rwc, err = libio.WithEncryption(rwc, []byte(pxy.clientCfg.Auth.Token))
 		if err != nil {
@@ -112,30 +108,37 @@ func (pxy *UDPProxy) InWorkConn(conn net.Conn, _ *msg.StartWorkConn) {
 	}
 	conn = netpkg.WrapReadWriteCloserToConn(rwc, conn)
 
+	msgConn := newUDPMsgConn(conn, datagrams, m)
+
 	pxy.mu.Lock()
 	pxy.workConn = conn
+	pxy.msgConn = msgConn
 	pxy.readCh = make(chan *msg.UDPPacket, 1024)
 	pxy.sendCh = make(chan msg.Message, 1024)
 	pxy.closed = false
 	pxy.mu.Unlock()
 
-	workConnReaderFn := func(conn net.Conn, readCh chan *msg.UDPPacket) {
+	workConnReaderFn := func(conn *udp.MsgConn, readCh chan *msg.UDPPacket) {
 		for {
-			var udpMsg msg.UDPPacket
-			if errRet := msg.ReadMsgInto(conn, &udpMsg); errRet != nil {
+			rawMsg, errRet := conn.ReadMsg()
+			if errRet != nil {
 				xl.Warnf("read from workConn for udp error: %v", errRet)
 				return
 			}
+			udpMsg, ok := rawMsg.(*msg.UDPPacket)
+			if !ok {
+				continue
+			}
 			if errRet := errors.PanicToError(func() {
-				xl.Tracef("get udp package from workConn: %s", udpMsg.Content)
-				readCh <- &udpMsg
+				xl.Tracef("get udp package from workConn, %d bytes", udp.PayloadSize(udpMsg))
+				readCh <- udpMsg
 			}); errRet != nil {
 				xl.Infof("reader goroutine for udp work connection closed: %v", errRet)
 				return
 			}
 		}
 	}
-	workConnSenderFn := func(conn net.Conn, sendCh chan msg.Message) {
+	workConnSenderFn := func(conn *udp.MsgConn, sendCh chan msg.Message) {
 		defer func() {
 			xl.Infof("writer goroutine for udp work connection closed")
 		}()
@@ -143,11 +146,11 @@ func (pxy *UDPProxy) InWorkConn(conn net.Conn, _ *msg.StartWorkConn) {
 		for rawMsg := range sendCh {
 			switch m := rawMsg.(type) {
 			case *msg.UDPPacket:
-				xl.Tracef("send udp package to workConn: %s", m.Content)
+				xl.Tracef("send udp package to workConn, %d bytes", udp.PayloadSize(m))
 			case *msg.Ping:
 				xl.Tracef("send ping message to udp workConn")
 			}
-			if errRet = msg.WriteMsg(conn, rawMsg); errRet != nil {
+			if errRet = conn.WriteMsg(rawMsg); errRet != nil {
 				xl.Errorf("udp work write error: %v", errRet)
 				return
 			}
@@ -166,8 +169,70 @@ func (pxy *UDPProxy) InWorkConn(conn net.Conn, _ *msg.StartWorkConn) {
 		}
 	}
 
-	go workConnSenderFn(pxy.workConn, pxy.sendCh)
-	go workConnReaderFn(pxy.workConn, pxy.readCh)
+	go workConnSenderFn(msgConn, pxy.sendCh)
+	go workConnReaderFn(msgConn, pxy.readCh)
 	go heartbeatFn(pxy.sendCh)
-	udp.Forwarder(pxy.localAddr, pxy.readCh, pxy.sendCh, int(pxy.clientCfg.UDPPacketSize))
+	var headerFn func(*msg.UDPPacket) []byte
+	if pxy.cfg.Transport.ProxyProtocolVersion == "v2" {
+		headerFn = pxy.proxyProtocolHeader
+	}
+	udp.Forwarder(pxy.localAddr, pxy.readCh, pxy.sendCh, int(pxy.clientCfg.UDPPacketSize), headerFn)
+}
+
+// newUDPMsgConn returns the connection of the UDP packets on the work conn. If
+// frps assigned a flow of QUIC datagrams to it, the packets are sent there.
+func newUDPMsgConn(conn net.Conn, datagrams *netpkg.DatagramSession, m *msg.StartWorkConn) *udp.MsgConn {
+	msgConn := udp.NewMsgConn(conn, m.UDPFraming)
+	if m.UDPDatagramID != 0 {
+		if flow := datagrams.OpenFlow(m.UDPDatagramID); flow != nil {
+			msgConn.UseDatagrams(flow)
+		}
+	}
+	return msgConn
+}
+
+// proxyProtocolHeader returns the PROXY protocol v2 header prepended to a
+// datagram from the user.
+func (pxy *UDPProxy) proxyProtocolHeader(m *msg.UDPPacket) []byte {
+	if m.RemoteAddr == nil {
+		return nil
+	}
+	src := m.RemoteAddr
+	// frps before this change doesn't send the address it received the packet on
+	dst := &net.UDPAddr{}
+	if m.LocalAddr != nil {
+		dst = m.LocalAddr
+	}
+
+	h := &pp.Header{
+		Version: 2,
+		Command: pp.PROXY,
+	}
+	// both addresses of the header must be of the same family
+	if ip4 := src.IP.To4(); ip4 != nil {
+		dstIP := dst.IP.To4()
+		if dstIP == nil {
+			dstIP = net.IPv4zero.To4()
+		}
+		h.TransportProtocol = pp.UDPv4
+		h.SourceAddr = &net.UDPAddr{IP: ip4, Port: src.Port}
+		h.DestinationAddr = &net.UDPAddr{IP: dstIP, Port: dst.Port}
+	} else {
+		dstIP := dst.IP.To16()
+		if dstIP == nil {
+			dstIP = net.IPv6unspecified
+		}
+		h.TransportProtocol = pp.UDPv6
+		h.SourceAddr = src
+		h.DestinationAddr = &net.UDPAddr{IP: dstIP, Port: dst.Port}
+	}
+	if err := h.SetTLVs(pxy.proxyProtocolTLVs("")); err != nil {
+		pxy.xl.Warnf("set proxy protocol tlvs error: %v", err)
+	}
+	b, err := h.Format()
+	if err != nil {
+		pxy.xl.Warnf("format proxy protocol header error: %v", err)
+		return nil
+	}
+	return b
 }
@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+
+	pp "github.com/pires/go-proxyproto"
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/fatedier/frp/pkg/config/v1"
+	"github.com/fatedier/frp/pkg/msg"
+	netpkg "github.com/fatedier/frp/pkg/util/net"
+	"github.com/fatedier/frp/pkg/util/xlog"
+)
+
+func TestUDPProxyProtocolHeader(t *testing.T) {
+	require := require.New(t)
+	pxy := &UDPProxy{
+		BaseProxy: &BaseProxy{
+			baseCfg:   &v1.ProxyBaseConfig{Name: "dns"},
+			clientCfg: &v1.ClientCommonConfig{User: "alice"},
+			xl:        xlog.New(),
+		},
+	}
+
+	b := pxy.proxyProtocolHeader(&msg.UDPPacket{
+		RemoteAddr: &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 5353},
+		LocalAddr:  &net.UDPAddr{IP: net.IPv6unspecified, Port: 53},
+	})
+	h, err := pp.Read(bufio.NewReader(bytes.NewReader(append(b, "query"...))))
+	require.NoError(err)
+	require.Equal(pp.UDPv4, h.TransportProtocol)
+	require.Equal("1.2.3.4:5353", h.SourceAddr.String())
+	require.Equal("0.0.0.0:53", h.DestinationAddr.String())
+
+	tlvs, err := h.TLVs()
+	require.NoError(err)
+	values := make(map[pp.PP2Type]string)
+	for _, tlv := range tlvs {
+		values[tlv.Type] = string(tlv.Value)
+	}
+	require.Equal(map[pp.PP2Type]string{
+		netpkg.PP2TypeFRPProxyName: "dns",
+		netpkg.PP2TypeFRPUser:      "alice",
+	}, values)
+}
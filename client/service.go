@@ -123,6 +123,16 @@ type Service struct {
 
 	connectorCreator func(context.Context, *v1.ClientCommonConfig) Connector
 	handleWorkConnCb func(*v1.ProxyBaseConfig, net.Conn, *msg.StartWorkConn) bool
+
+	// servers to connect to, the one at serverIndex is tried first
+	servers     []v1.ServerEndpoint
+	serverIndex int
+	// the server of ctl, protected by ctlMu
+	activeServer v1.ServerEndpoint
+
+	// services of the server profiles, run along with this one
+	profiles    []*Service
+	profileName string
 }
 
 func NewService(options ServiceOptions) (*Service, error) {
@@ -138,11 +148,44 @@ func NewService(options ServiceOptions) (*Service, error) {
 		clientSpec:       options.ClientSpec,
 		connectorCreator: options.ConnectorCreator,
 		handleWorkConnCb: options.HandleWorkConnCb,
+		servers:          options.Common.ServerEndpoints(),
+	}
+	s.proxyCfgs, s.visitorCfgs = filterByProfile("", options.ProxyCfgs, options.VisitorCfgs)
+
+	for _, p := range options.Common.ServerProfiles {
+		proxyCfgs, visitorCfgs := filterByProfile(p.Name, options.ProxyCfgs, options.VisitorCfgs)
+		profile, err := NewService(ServiceOptions{
+			Common:           options.Common.ProfileConfig(p.Name),
+			ProxyCfgs:        proxyCfgs,
+			VisitorCfgs:      visitorCfgs,
+			ClientSpec:       options.ClientSpec,
+			ConnectorCreator: options.ConnectorCreator,
+			HandleWorkConnCb: options.HandleWorkConnCb,
+		})
+		if err != nil {
+			return nil, err
+		}
+		profile.profileName = p.Name
+		s.profiles = append(s.profiles, profile)
 	}
-
 	return s, nil
 }
 
+// filterByProfile returns the proxies and visitors of the server profile name.
+func filterByProfile(
+	name string,
+	proxyCfgs []v1.ProxyConfigurer,
+	visitorCfgs []v1.VisitorConfigurer,
+) ([]v1.ProxyConfigurer, []v1.VisitorConfigurer) {
+	profileProxyCfgs := lo.Filter(proxyCfgs, func(c v1.ProxyConfigurer, _ int) bool {
+		return c.GetBaseConfig().ServerProfile == name
+	})
+	profileVisitorCfgs := lo.Filter(visitorCfgs, func(c v1.VisitorConfigurer, _ int) bool {
+		return c.GetBaseConfig().ServerProfile == name
+	})
+	return profileProxyCfgs, profileVisitorCfgs
+}
+
 func (svr *Service) Run(ctx context.Context) error {
 	ctx, cancel := context.WithCancelCause(ctx)
 	svr.ctx = xlog.NewContext(ctx, xlog.FromContextSafe(ctx))
@@ -153,6 +196,14 @@ func (svr *Service) Run(ctx context.Context) error {
 		netpkg.SetDefaultDNSAddress(svr.common.DNSServer)
 	}
 
+	for _, p := range svr.profiles {
+		go svr.runProfile(p)
+	}
+
+	if svr.common.ServerSelection == "latency" && len(svr.servers) > 1 {
+		svr.serverIndex = svr.lowestLatencyServer()
+	}
+
 	// first login to frps
 	svr.loopLoginUntilSuccess(10*time.Second, lo.FromPtr(svr.common.LoginFailExit))
 	if svr.ctl == nil {
@@ -165,9 +216,28 @@ func (svr *Service) Run(ctx context.Context) error {
 
 	<-svr.ctx.Done()
 	svr.stop()
+	// a server profile failed to login
+	cancelCause := cancelErr{}
+	if errors.As(context.Cause(svr.ctx), &cancelCause) {
+		return cancelCause.Err
+	}
 	return nil
 }
 
+// runProfile runs the service of a server profile until the service stops. If
+// it fails, the service is stopped too.
+func (svr *Service) runProfile(p *Service) {
+	xl := xlog.FromContextSafe(svr.ctx).Spawn().AppendPrefix(p.profileName)
+	if err := p.Run(xlog.NewContext(svr.ctx, xl)); err != nil {
+		svr.cancel(cancelErr{Err: fmt.Errorf("server profile [%s]: %v", p.profileName, err)})
+	}
+}
+
+// services returns this service and the ones of its server profiles.
+func (svr *Service) services() []*Service {
+	return append([]*Service{svr}, svr.profiles...)
+}
+
 func (svr *Service) keepControllerWorking() {
 	<-svr.ctl.Done()
 
@@ -202,11 +272,12 @@ func (svr *Service) keepControllerWorking() {
 // login creates a connection to frps and registers it self as a client
 // conn: control connection
 // session: if it's not nil, using tcp mux
-func (svr *Service) login() (conn net.Conn, connector Connector, err error) {
+// features: features negotiated with frps
+func (svr *Service) login(common *v1.ClientCommonConfig) (conn net.Conn, connector Connector, features msg.Features, err error) {
 	xl := xlog.FromContextSafe(svr.ctx)
-	connector = svr.connectorCreator(svr.ctx, svr.common)
+	connector = svr.connectorCreator(svr.ctx, common)
 	if err = connector.Open(); err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 
 	defer func() {
@@ -230,6 +301,7 @@ func (svr *Service) login() (conn net.Conn, connector Connector, err error) {
 		Timestamp: time.Now().Unix(),
 		RunID:     svr.runID,
 		Metas:     svr.common.Metadatas,
+		Features:  msg.SupportedFeatures(),
 	}
 	if svr.clientSpec != nil {
 		loginMsg.ClientSpec = *svr.clientSpec
@@ -256,6 +328,7 @@ func (svr *Service) login() (conn net.Conn, connector Connector, err error) {
 	}
 
 	svr.runID = loginRespMsg.RunID
+	features = msg.NewFeatures(loginRespMsg.Features...)
 	xl.AddPrefix(xlog.LogPrefix{Name: "runID", Value: svr.runID})
 
 	xl.Infof("login to server success, got run id [%s]", loginRespMsg.RunID)
@@ -266,10 +339,28 @@ func (svr *Service) loopLoginUntilSuccess(maxInterval time.Duration, firstLoginE
 	xl := xlog.FromContextSafe(svr.ctx)
 
 	loginFunc := func() (bool, error) {
-		xl.Infof("try to connect to server...")
-		conn, connector, err := svr.login()
+		var (
+			server    v1.ServerEndpoint
+			common    *v1.ClientCommonConfig
+			conn      net.Conn
+			connector Connector
+			features  msg.Features
+			err       error
+		)
+		// try the servers in order, starting from the last one in use
+		for i := range svr.servers {
+			index := (svr.serverIndex + i) % len(svr.servers)
+			server = svr.servers[index]
+			common = svr.common.ForServer(server)
+			xl.Infof("try to connect to server [%s]...", server)
+			conn, connector, features, err = svr.login(common)
+			if err == nil {
+				svr.serverIndex = index
+				break
+			}
+			xl.Warnf("connect to server [%s] error: %v", server, err)
+		}
 		if err != nil {
-			xl.Warnf("connect to server error: %v", err)
 			if firstLoginExit {
 				svr.cancel(cancelErr{Err: err})
 			}
@@ -285,12 +376,13 @@ func (svr *Service) loopLoginUntilSuccess(maxInterval time.Duration, firstLoginE
 			connEncrypted = false
 		}
 		sessionCtx := &SessionContext{
-			Common:        svr.common,
+			Common:        common,
 			RunID:         svr.runID,
 			Conn:          conn,
 			ConnEncrypted: connEncrypted,
 			AuthSetter:    svr.authSetter,
 			Connector:     connector,
+			Features:      features,
 		}
 		ctl, err := NewControl(svr.ctx, sessionCtx)
 		if err != nil {
@@ -307,6 +399,7 @@ func (svr *Service) loopLoginUntilSuccess(maxInterval time.Duration, firstLoginE
 			svr.ctl.Close()
 		}
 		svr.ctl = ctl
+		svr.activeServer = server
 		svr.ctlMu.Unlock()
 		return true, nil
 	}
@@ -321,7 +414,66 @@ func (svr *Service) loopLoginUntilSuccess(maxInterval time.Duration, firstLoginE
 		}), true, svr.ctx.Done())
 }
 
+// lowestLatencyServer returns the index of the server with the lowest
+// connection latency, or the first one if none is reachable.
+func (svr *Service) lowestLatencyServer() int {
+	xl := xlog.FromContextSafe(svr.ctx)
+	latencies := make([]time.Duration, len(svr.servers))
+	var wg sync.WaitGroup
+	for i, server := range svr.servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			latencies[i] = -1
+			connector := svr.connectorCreator(svr.ctx, svr.common.ForServer(server))
+			defer connector.Close()
+			start := time.Now()
+			if err := connector.Open(); err != nil {
+				xl.Debugf("measure latency of server [%s] error: %v", server, err)
+				return
+			}
+			conn, err := connector.Connect()
+			if err != nil {
+				xl.Debugf("measure latency of server [%s] error: %v", server, err)
+				return
+			}
+			latencies[i] = time.Since(start)
+			conn.Close()
+		}()
+	}
+	wg.Wait()
+
+	best := 0
+	for i, latency := range latencies {
+		if latency >= 0 && (latencies[best] < 0 || latency < latencies[best]) {
+			best = i
+		}
+	}
+	xl.Infof("server [%s] has the lowest latency %v", svr.servers[best], latencies[best])
+	return best
+}
+
+// ActiveServer returns the server in use, or false if it's not connected.
+func (svr *Service) ActiveServer() (v1.ServerEndpoint, bool) {
+	svr.ctlMu.RLock()
+	defer svr.ctlMu.RUnlock()
+	return svr.activeServer, svr.ctl != nil
+}
+
 func (svr *Service) UpdateAllConfigurer(proxyCfgs []v1.ProxyConfigurer, visitorCfgs []v1.VisitorConfigurer) error {
+	if len(svr.profiles) > 0 {
+		for _, p := range svr.profiles {
+			profileProxyCfgs, profileVisitorCfgs := filterByProfile(p.profileName, proxyCfgs, visitorCfgs)
+			if err := p.UpdateAllConfigurer(profileProxyCfgs, profileVisitorCfgs); err != nil {
+				return err
+			}
+		}
+		proxyCfgs, visitorCfgs = filterByProfile("", proxyCfgs, visitorCfgs)
+	}
+	return svr.updateAllConfigurer(proxyCfgs, visitorCfgs)
+}
+
+func (svr *Service) updateAllConfigurer(proxyCfgs []v1.ProxyConfigurer, visitorCfgs []v1.VisitorConfigurer) error {
 	svr.cfgMu.Lock()
 	svr.proxyCfgs = proxyCfgs
 	svr.visitorCfgs = visitorCfgs
@@ -342,6 +494,9 @@ func (svr *Service) Close() {
 }
 
 func (svr *Service) GracefulClose(d time.Duration) {
+	for _, p := range svr.profiles {
+		p.gracefulShutdownDuration = d
+	}
 	svr.gracefulShutdownDuration = d
 	svr.cancel(nil)
 }
@@ -355,17 +510,50 @@ func (svr *Service) stop() {
 	}
 }
 
-func (svr *Service) getProxyStatus(name string) (*proxy.WorkingStatus, bool) {
-	svr.ctlMu.RLock()
-	ctl := svr.ctl
-	svr.ctlMu.RUnlock()
+// proxyControl returns the control the proxy name is running on, of this
+// service or a server profile.
+func (svr *Service) proxyControl(name string) *Control {
+	for _, s := range svr.services() {
+		s.ctlMu.RLock()
+		ctl := s.ctl
+		s.ctlMu.RUnlock()
+		if ctl == nil {
+			continue
+		}
+		if _, ok := ctl.pm.GetProxyStatus(name); ok {
+			return ctl
+		}
+	}
+	return nil
+}
 
+func (svr *Service) getProxyStatus(name string) (*proxy.WorkingStatus, bool) {
+	ctl := svr.proxyControl(name)
 	if ctl == nil {
 		return nil, false
 	}
 	return ctl.pm.GetProxyStatus(name)
 }
 
+// DrainProxy takes a running proxy out of service and lets its connections in
+// use finish for up to timeout, or the configured drain timeout if it is 0.
+func (svr *Service) DrainProxy(name string, timeout time.Duration) error {
+	ctl := svr.proxyControl(name)
+	if ctl == nil {
+		return fmt.Errorf("proxy [%s] not found", name)
+	}
+	return ctl.pm.DrainProxy(name, timeout)
+}
+
+// ResumeProxy puts a drained proxy back into service.
+func (svr *Service) ResumeProxy(name string) error {
+	ctl := svr.proxyControl(name)
+	if ctl == nil {
+		return fmt.Errorf("proxy [%s] not found", name)
+	}
+	return ctl.pm.ResumeProxy(name)
+}
+
 func (svr *Service) StatusExporter() StatusExporter {
 	return &statusExporterImpl{
 		getProxyStatusFunc: svr.getProxyStatus,
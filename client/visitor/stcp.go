@@ -15,9 +15,12 @@
 package visitor
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net"
 	"strconv"
 	"time"
@@ -25,12 +28,14 @@ import (
 	"github.com/fatedier/frp/pkg/cmux"
 	"github.com/fatedier/frp/pkg/cmux/pattern"
 	v1 "github.com/fatedier/frp/pkg/config/v1"
+	"github.com/fatedier/frp/pkg/dnsproxy"
 	"github.com/fatedier/frp/pkg/httpproxy"
 	"github.com/fatedier/frp/pkg/msg"
 	"github.com/fatedier/frp/pkg/socks4"
 	"github.com/fatedier/frp/pkg/socks5"
 	"github.com/fatedier/frp/pkg/trie"
 	netpkg "github.com/fatedier/frp/pkg/util/net"
+	"github.com/fatedier/frp/pkg/util/passwd"
 	"github.com/fatedier/frp/pkg/util/util"
 	"github.com/fatedier/frp/pkg/util/xlog"
 	libio "github.com/fatedier/golib/io"
@@ -39,10 +44,21 @@ import (
 type STCPVisitor struct {
 	*BaseVisitor
 
-	cfg *v1.STCPVisitorConfig
+	// dnsConn and dnsLn serve the local DNS server if it is enabled.
+	dnsConn net.PacketConn
+	dnsLn   net.Listener
+	// transparentLn accepts connections redirected by iptables or nftables.
+	transparentLn net.Listener
+
+	users *passwd.Users
+	cfg   *v1.STCPVisitorConfig
 }
 
 func (sv *STCPVisitor) Run() (err error) {
+	if sv.users, err = sv.loadUsers(); err != nil {
+		return
+	}
+
 	if sv.cfg.BindPort > 0 {
 		sv.l, err = net.Listen("tcp", net.JoinHostPort(sv.cfg.BindAddr, strconv.Itoa(sv.cfg.BindPort)))
 		if err != nil {
@@ -51,12 +67,83 @@ func (sv *STCPVisitor) Run() (err error) {
 		go sv.worker()
 	}
 
+	if sv.cfg.DNS.BindPort > 0 {
+		if err = sv.runDNS(); err != nil {
+			sv.Close()
+			return
+		}
+	}
+
+	if sv.cfg.Transparent.BindPort > 0 {
+		addr := net.JoinHostPort(sv.cfg.BindAddr, strconv.Itoa(sv.cfg.Transparent.BindPort))
+		sv.transparentLn, err = netpkg.ListenTransparent("tcp", addr, sv.cfg.Transparent.Mode == "tproxy")
+		if err != nil {
+			sv.Close()
+			return fmt.Errorf("listen transparent %s error: %v", addr, err)
+		}
+		go sv.transparentWorker()
+	}
+
 	go sv.internalConnWorker()
 	return
 }
 
 func (sv *STCPVisitor) Close() {
 	sv.BaseVisitor.Close()
+	if sv.dnsConn != nil {
+		sv.dnsConn.Close()
+	}
+	if sv.dnsLn != nil {
+		sv.dnsLn.Close()
+	}
+	if sv.transparentLn != nil {
+		sv.transparentLn.Close()
+	}
+}
+
+// loadUsers merges the users of the config and of the htpasswd file.
+func (sv *STCPVisitor) loadUsers() (*passwd.Users, error) {
+	users := make(map[string]string, len(sv.cfg.Users))
+	if sv.cfg.UsersFile != "" {
+		fileUsers, err := passwd.LoadHtpasswd(sv.cfg.UsersFile)
+		if err != nil {
+			return nil, fmt.Errorf("load users file error: %v", err)
+		}
+		maps.Copy(users, fileUsers)
+	}
+	maps.Copy(users, sv.cfg.Users)
+	return passwd.NewUsers(users), nil
+}
+
+// runDNS starts a DNS server on both UDP and TCP, which forwards queries to
+// the resolver on the frpc side through the stcp tunnel.
+func (sv *STCPVisitor) runDNS() (err error) {
+	xl := xlog.FromContextSafe(sv.ctx)
+	addr := net.JoinHostPort(sv.cfg.BindAddr, strconv.Itoa(sv.cfg.DNS.BindPort))
+	if sv.dnsConn, err = net.ListenPacket("udp", addr); err != nil {
+		return fmt.Errorf("listen dns udp %s error: %v", addr, err)
+	}
+	if sv.dnsLn, err = net.Listen("tcp", addr); err != nil {
+		return fmt.Errorf("listen dns tcp %s error: %v", addr, err)
+	}
+
+	s := &dnsproxy.Server{
+		Upstream:  sv.cfg.DNS.Resolver,
+		ProxyDial: sv.DialContext,
+		Cache:     dnsproxy.NewCache(sv.cfg.DNS.CacheSize),
+		Logger:    xl,
+		Context:   sv.ctx,
+	}
+	xl.Infof("stcp dns server listen on %s, resolver %s", addr, sv.cfg.DNS.Resolver)
+	go func() {
+		_ = s.ServeUDP(sv.dnsConn)
+		xl.Warnf("stcp dns udp listener closed")
+	}()
+	go func() {
+		_ = s.Serve(sv.dnsLn)
+		xl.Warnf("stcp dns tcp listener closed")
+	}()
+	return nil
 }
 
 func (sv *STCPVisitor) worker() {
@@ -71,6 +158,45 @@ func (sv *STCPVisitor) worker() {
 	}
 }
 
+func (sv *STCPVisitor) transparentWorker() {
+	xl := xlog.FromContextSafe(sv.ctx)
+	for {
+		conn, err := sv.transparentLn.Accept()
+		if err != nil {
+			xl.Warnf("stcp transparent listener closed")
+			return
+		}
+		go sv.handleTransparentConn(conn)
+	}
+}
+
+// handleTransparentConn sends a redirected connection to its original
+// destination, which is dialed by the frpc behind the stcp proxy.
+func (sv *STCPVisitor) handleTransparentConn(userConn net.Conn) {
+	xl := xlog.FromContextSafe(sv.ctx)
+	defer userConn.Close()
+
+	target, err := netpkg.OriginalDst(userConn, sv.cfg.Transparent.Mode == "tproxy")
+	if err != nil {
+		xl.Warnf("get original destination error: %v", err)
+		return
+	}
+	if target == sv.transparentLn.Addr().String() {
+		xl.Warnf("connection to %s was not redirected, drop it", target)
+		return
+	}
+	xl.Debugf("get a new stcp transparent connection to %s", target)
+
+	remote, err := sv.DialContext(sv.ctx, "tcp", target)
+	if err != nil {
+		xl.Warnf("dial context error: %v", err)
+		return
+	}
+	defer remote.Close()
+
+	libio.Join(userConn, remote)
+}
+
 func (sv *STCPVisitor) internalConnWorker() {
 	xl := xlog.FromContextSafe(sv.ctx)
 	for {
@@ -96,7 +222,7 @@ var muxer = func() func(conn net.Conn) (string, net.Conn, error) {
 	HandlePrefix(handlerTrie, "http", append(pattern.Pattern[pattern.HTTP], pattern.Pattern[pattern.HTTP2]...)...)
 	HandlePrefix(handlerTrie, "socks5", pattern.Pattern[pattern.SOCKS5]...)
 	HandlePrefix(handlerTrie, "socks4", pattern.Pattern[pattern.SOCKS4]...)
-	HandlePrefix(handlerTrie, "target", "TARGET ")
+	HandlePrefix(handlerTrie, "target", netpkg.TargetHeadPrefixes...)
 
 	return func(conn net.Conn) (string, net.Conn, error) {
 		handler, buf, err := handlerTrie.MatchWithReader(conn)
@@ -113,10 +239,9 @@ func (sv *STCPVisitor) newSocks4ServeConn() (ServeConn, error) {
 	if err != nil {
 		return nil, err
 	}
-	if len(sv.cfg.Users) > 0 {
+	if sv.users.Len() > 0 {
 		s.Authentication = socks4.AuthenticationFunc(func(cmd socks4.Command, username string) bool {
-			_, ok := sv.cfg.Users[username]
-			return ok
+			return sv.users.Exists(username)
 		})
 	}
 	s.Context = sv.ctx
@@ -130,9 +255,9 @@ func (sv *STCPVisitor) newSocks5ServeConn() (ServeConn, error) {
 	if err != nil {
 		return nil, err
 	}
-	if len(sv.cfg.Users) > 0 {
+	if sv.users.Len() > 0 {
 		s.Authentication = socks5.AuthenticationFunc(func(cmd socks5.Command, username, password string) bool {
-			return sv.cfg.Users[username] == password
+			return sv.users.Verify(username, password)
 		})
 	}
 	s.Context = sv.ctx
@@ -148,10 +273,16 @@ func (sv *STCPVisitor) newHttpServeConn() (ServeConn, error) {
 	s.Server.BaseContext = func(listener net.Listener) context.Context {
 		return sv.ctx
 	}
-	if len(sv.cfg.Users) > 0 {
-		s.Authentication = httpproxy.BasicAuthFunc(func(username, password string) bool {
-			return sv.cfg.Users[username] == password
-		})
+	if sv.users.Len() > 0 {
+		s.Authentication = httpproxy.BasicAuthFunc(sv.users.Verify)
+	}
+
+	if sv.cfg.PAC.Enabled() {
+		pac, err := httpproxy.NewPAC(sv.cfg.PAC.Domains, sv.cfg.PAC.CIDRs)
+		if err != nil {
+			return nil, err
+		}
+		s.NotFound = pac
 	}
 
 	s.ProxyDial = sv.DialContext
@@ -159,6 +290,21 @@ func (sv *STCPVisitor) newHttpServeConn() (ServeConn, error) {
 }
 
 func (sv *STCPVisitor) DialContext(ctx context.Context, network, target string) (net.Conn, error) {
+	return sv.dialTarget(ctx, netpkg.Target{Addr: target})
+}
+
+// targetDialError is the failure of frpc dialing the target, reported when
+// the target is dialed with DialReply.
+type targetDialError struct {
+	status int
+	msg    string
+}
+
+func (e *targetDialError) Error() string {
+	return fmt.Sprintf("dial target error: %s", e.msg)
+}
+
+func (sv *STCPVisitor) dialTarget(ctx context.Context, target netpkg.Target) (net.Conn, error) {
 	xl := xlog.FromContextSafe(sv.ctx)
 	visitorConn, err := sv.helper.ConnectServer()
 	if err != nil {
@@ -174,7 +320,10 @@ func (sv *STCPVisitor) DialContext(ctx context.Context, network, target string)
 		UseEncryption:  sv.cfg.Transport.UseEncryption,
 		UseCompression: sv.cfg.Transport.UseCompression,
 
-		TargetAddr: target,
+		TargetAddr:        target.Addr,
+		TargetNetwork:     target.Network,
+		TargetDialTimeout: target.DialTimeout.Milliseconds(),
+		TargetDialReply:   target.DialReply,
 	}
 	if err := msg.WriteMsg(visitorConn, newVisitorConnMsg); err != nil {
 		xl.Warnf("send newVisitorConnMsg to server error: %v", err)
@@ -206,8 +355,23 @@ func (sv *STCPVisitor) DialContext(ctx context.Context, network, target string)
 	if sv.cfg.Transport.UseCompression {
 		remote, recycleFn = libio.WithCompressionFromPool(remote)
 	}
-
-	return &readWriteCloserConn{ReadWriteCloser: remote, Original: visitorConn, recycleFn: recycleFn}, nil
+	conn := &readWriteCloserConn{ReadWriteCloser: remote, Original: visitorConn, recycleFn: recycleFn}
+
+	// frps only asks for the reply if the frpc of the proxy supports it
+	if target.DialReply && newVisitorConnRespMsg.TargetDialReply {
+		// wait for the frpc dial, which is bounded by its own timeout
+		var resp msg.TargetDialResp
+		if err := msg.ReadMsgIntoTimeout(conn, &resp, cmp.Or(target.DialTimeout, 10*time.Second)+5*time.Second); err != nil {
+			conn.Close()
+			xl.Warnf("get target dial result error: %v", err)
+			return nil, err
+		}
+		if resp.Status != netpkg.TargetStatusOK {
+			conn.Close()
+			return nil, &targetDialError{status: resp.Status, msg: resp.Error}
+		}
+	}
+	return conn, nil
 }
 
 type readWriteCloserConn struct {
@@ -246,7 +410,6 @@ func (sv *STCPVisitor) handleConn(userConn net.Conn) {
 		return
 	}
 
-	var target string
 	switch proxyType {
 	case "http":
 		sc, err := sv.newHttpServeConn()
@@ -274,12 +437,20 @@ func (sv *STCPVisitor) handleConn(userConn net.Conn) {
 		return
 
 	case "target":
-		var err error
-		target, err = netpkg.ParseTargetHead(userConn)
+		target, version, userConn, err := netpkg.ParseTargetHead(userConn)
 		if err != nil {
+			xl.Warnf("parse target head error: %v", err)
+			if version == 2 {
+				_ = netpkg.WriteTargetStatus(userConn, netpkg.TargetStatusBadRequest)
+			}
 			return
 		}
-		remote, err := sv.DialContext(sv.ctx, "", target)
+		if version == 2 {
+			sv.handleTargetV2(userConn, target)
+			return
+		}
+
+		remote, err := sv.DialContext(sv.ctx, "", target.Addr)
 		if err != nil {
 			xl.Warnf("dial context error: %v", err)
 			return
@@ -289,3 +460,27 @@ func (sv *STCPVisitor) handleConn(userConn net.Conn) {
 		libio.Join(userConn, remote)
 	}
 }
+
+// handleTargetV2 dials the target of a TARGET2 header and answers with a
+// status line before relaying data.
+func (sv *STCPVisitor) handleTargetV2(userConn net.Conn, target netpkg.Target) {
+	xl := xlog.FromContextSafe(sv.ctx)
+	target.DialReply = true
+	remote, err := sv.dialTarget(sv.ctx, target)
+	if err != nil {
+		xl.Warnf("dial target [%s] error: %v", target.Addr, err)
+		status := netpkg.TargetStatusTunnelFailed
+		var dialErr *targetDialError
+		if errors.As(err, &dialErr) {
+			status = dialErr.status
+		}
+		_ = netpkg.WriteTargetStatus(userConn, status)
+		return
+	}
+	defer remote.Close()
+
+	if err := netpkg.WriteTargetStatus(userConn, netpkg.TargetStatusOK); err != nil {
+		return
+	}
+	libio.Join(userConn, remote)
+}
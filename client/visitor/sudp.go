@@ -74,7 +74,7 @@ func (sv *SUDPVisitor) dispatcher() {
 	xl := xlog.FromContextSafe(sv.ctx)
 
 	var (
-		visitorConn net.Conn
+		visitorConn *udp.MsgConn
 		err         error
 
 		firstPacket *msg.UDPPacket
@@ -109,7 +109,7 @@ func (sv *SUDPVisitor) dispatcher() {
 	}
 }
 
-func (sv *SUDPVisitor) worker(workConn net.Conn, firstPacket *msg.UDPPacket) {
+func (sv *SUDPVisitor) worker(workConn *udp.MsgConn, firstPacket *msg.UDPPacket) {
 	xl := xlog.FromContextSafe(sv.ctx)
 	xl.Debugf("starting sudp proxy worker")
 
@@ -118,7 +118,7 @@ func (sv *SUDPVisitor) worker(workConn net.Conn, firstPacket *msg.UDPPacket) {
 	closeCh := make(chan struct{})
 
 	// udp service -> frpc -> frps -> frpc visitor -> user
-	workConnReaderFn := func(conn net.Conn) {
+	workConnReaderFn := func(conn *udp.MsgConn) {
 		defer func() {
 			conn.Close()
 			close(closeCh)
@@ -132,7 +132,7 @@ func (sv *SUDPVisitor) worker(workConn net.Conn, firstPacket *msg.UDPPacket) {
 			)
 
 			// frpc will send heartbeat in workConn to frpc visitor for keeping alive
-			if rawMsg, errRet = msg.ReadMsgTimeout(conn, 60*time.Second); errRet != nil {
+			if rawMsg, errRet = conn.ReadMsgTimeout(60 * time.Second); errRet != nil {
 				xl.Warnf("read from workconn for user udp conn error: %v", errRet)
 				return
 			}
@@ -144,7 +144,7 @@ func (sv *SUDPVisitor) worker(workConn net.Conn, firstPacket *msg.UDPPacket) {
 			case *msg.UDPPacket:
 				if errRet := errors.PanicToError(func() {
 					sv.readCh <- m
-					xl.Tracef("frpc visitor get udp packet from workConn: %s", m.Content)
+					xl.Tracef("frpc visitor get udp packet from workConn, %d bytes", udp.PayloadSize(m))
 				}); errRet != nil {
 					xl.Infof("reader goroutine for udp work connection closed")
 					return
@@ -154,7 +154,7 @@ func (sv *SUDPVisitor) worker(workConn net.Conn, firstPacket *msg.UDPPacket) {
 	}
 
 	// udp service <- frpc <- frps <- frpc visitor <- user
-	workConnSenderFn := func(conn net.Conn) {
+	workConnSenderFn := func(conn *udp.MsgConn) {
 		defer func() {
 			conn.Close()
 			wg.Done()
@@ -162,11 +162,11 @@ func (sv *SUDPVisitor) worker(workConn net.Conn, firstPacket *msg.UDPPacket) {
 
 		var errRet error
 		if firstPacket != nil {
-			if errRet = msg.WriteMsg(conn, firstPacket); errRet != nil {
+			if errRet = conn.WriteMsg(firstPacket); errRet != nil {
 				xl.Warnf("sender goroutine for udp work connection closed: %v", errRet)
 				return
 			}
-			xl.Tracef("send udp package to workConn: %s", firstPacket.Content)
+			xl.Tracef("send udp package to workConn, %d bytes", udp.PayloadSize(firstPacket))
 		}
 
 		for {
@@ -177,11 +177,11 @@ func (sv *SUDPVisitor) worker(workConn net.Conn, firstPacket *msg.UDPPacket) {
 					return
 				}
 
-				if errRet = msg.WriteMsg(conn, udpMsg); errRet != nil {
+				if errRet = conn.WriteMsg(udpMsg); errRet != nil {
 					xl.Warnf("sender goroutine for udp work connection closed: %v", errRet)
 					return
 				}
-				xl.Tracef("send udp package to workConn: %s", udpMsg.Content)
+				xl.Tracef("send udp package to workConn, %d bytes", udp.PayloadSize(udpMsg))
 			case <-closeCh:
 				return
 			}
@@ -195,13 +195,17 @@ func (sv *SUDPVisitor) worker(workConn net.Conn, firstPacket *msg.UDPPacket) {
 	xl.Infof("sudp worker is closed")
 }
 
-func (sv *SUDPVisitor) getNewVisitorConn() (net.Conn, error) {
+func (sv *SUDPVisitor) getNewVisitorConn() (*udp.MsgConn, error) {
 	xl := xlog.FromContextSafe(sv.ctx)
 	visitorConn, err := sv.helper.ConnectServer()
 	if err != nil {
 		return nil, fmt.Errorf("frpc connect frps error: %v", err)
 	}
 
+	// the datagrams are relayed by frps as they are, so they can't be
+	// encrypted or compressed
+	datagrams := netpkg.GetDatagramSession(visitorConn)
+	features := msg.FeaturesFromContext(sv.ctx)
 	now := time.Now().Unix()
 	newVisitorConnMsg := &msg.NewVisitorConn{
 		RunID:          sv.helper.RunID(),
@@ -210,6 +214,11 @@ func (sv *SUDPVisitor) getNewVisitorConn() (net.Conn, error) {
 		Timestamp:      now,
 		UseEncryption:  sv.cfg.Transport.UseEncryption,
 		UseCompression: sv.cfg.Transport.UseCompression,
+		UDPDatagram: features.Has(msg.FeatureUDPDatagram) && datagrams != nil &&
+			!sv.cfg.Transport.UseEncryption && !sv.cfg.Transport.UseCompression,
+	}
+	if features.Has(msg.FeatureUDPBinaryFraming) {
+		newVisitorConnMsg.UDPFraming = udp.FramingBinary
 	}
 	err = msg.WriteMsg(visitorConn, newVisitorConnMsg)
 	if err != nil {
@@ -238,7 +247,14 @@ func (sv *SUDPVisitor) getNewVisitorConn() (net.Conn, error) {
 	if sv.cfg.Transport.UseCompression {
 		remote = libio.WithCompression(remote)
 	}
-	return netpkg.WrapReadWriteCloserToConn(remote, visitorConn), nil
+	// frps replies without a framing if it or frpc only supports JSON messages
+	msgConn := udp.NewMsgConn(netpkg.WrapReadWriteCloserToConn(remote, visitorConn), newVisitorConnRespMsg.UDPFraming)
+	if id := newVisitorConnRespMsg.UDPDatagramID; id != 0 {
+		if flow := datagrams.OpenFlow(id); flow != nil {
+			msgConn.UseDatagrams(flow)
+		}
+	}
+	return msgConn, nil
 }
 
 func (sv *SUDPVisitor) Close() {
@@ -26,9 +26,10 @@ func Run(cfgFile string) error {
 
 	var cc struct {
 		ServerAddr string `json:"serverAddr,omitempty"`
+		Servers    []any  `json:"servers,omitempty"`
 	}
 	_ = yaml.UnmarshalStrict(ss.Pick1(os.ReadFile(util.ExpandFile(cfgFile))), &cc)
-	if cc.ServerAddr == "" {
+	if cc.ServerAddr == "" && len(cc.Servers) == 0 {
 		svrCfg, err := config.LoadServerConfig(cfgFile)
 		if err != nil {
 			fmt.Println(err)
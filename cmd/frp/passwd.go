@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fatedier/frp/pkg/util/passwd"
+)
+
+var (
+	passwdAlgo string
+	passwdUser string
+)
+
+func init() {
+	passwdCmd.Flags().StringVarP(&passwdAlgo, "algo", "a", passwd.AlgoBcrypt, "hash algorithm, bcrypt or argon2id")
+	passwdCmd.Flags().StringVarP(&passwdUser, "user", "u", "", "print an htpasswd line for the user")
+	rootCmd.AddCommand(passwdCmd)
+}
+
+var passwdCmd = &cobra.Command{
+	Use:   "passwd [password]",
+	Short: "Hash a password for visitor users, read from stdin if not given",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var password string
+		if len(args) > 0 {
+			password = args[0]
+		} else {
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && line == "" {
+				return fmt.Errorf("read password from stdin: %v", err)
+			}
+			password = strings.TrimRight(line, "\r\n")
+		}
+		if password == "" {
+			return errors.New("password is empty")
+		}
+
+		hash, err := passwd.Hash(password, passwdAlgo)
+		if err != nil {
+			return err
+		}
+		if passwdUser != "" {
+			fmt.Printf("%s:%s\n", passwdUser, hash)
+		} else {
+			fmt.Println(hash)
+		}
+		return nil
+	},
+}
@@ -171,10 +171,10 @@ func LoadClientConfig(path string) (
 	cliCfg.Complete()
 
 	for _, c := range proxyCfgs {
-		c.Complete(cliCfg.User)
+		c.Complete(cliCfg.ProfileConfig(c.GetBaseConfig().ServerProfile).User)
 	}
 	for _, c := range visitorCfgs {
-		c.Complete(cliCfg)
+		c.Complete(cliCfg.ProfileConfig(c.GetBaseConfig().ServerProfile))
 	}
 	return cliCfg, proxyCfgs, visitorCfgs, nil
 }
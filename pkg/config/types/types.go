@@ -23,6 +23,8 @@ import (
 )
 
 const (
+	TB = 1024 * GB
+	GB = 1024 * MB
 	MB = 1024 * 1024
 	KB = 1024
 
@@ -116,6 +118,76 @@ func (q *BandwidthQuantity) Bytes() int64 {
 	return q.i
 }
 
+// ByteQuantity is an amount of data like "500MB", with the units KB, MB, GB
+// and TB.
+type ByteQuantity struct {
+	s string
+
+	i int64 // bytes
+}
+
+func NewByteQuantity(s string) (ByteQuantity, error) {
+	q := ByteQuantity{}
+	err := q.UnmarshalString(s)
+	if err != nil {
+		return q, err
+	}
+	return q, nil
+}
+
+func (q *ByteQuantity) String() string {
+	return q.s
+}
+
+func (q *ByteQuantity) UnmarshalString(s string) error {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+
+	for _, unit := range []struct {
+		suffix string
+		base   int64
+	}{{"TB", TB}, {"GB", GB}, {"MB", MB}, {"KB", KB}} {
+		if !strings.HasSuffix(s, unit.suffix) {
+			continue
+		}
+		f, err := strconv.ParseFloat(strings.TrimSuffix(s, unit.suffix), 64)
+		if err != nil {
+			return err
+		}
+		if f < 0 {
+			return errors.New("negative quantity")
+		}
+		q.s = s
+		q.i = int64(f * float64(unit.base))
+		return nil
+	}
+	return errors.New("unit not support")
+}
+
+func (q *ByteQuantity) UnmarshalJSON(b []byte) error {
+	if len(b) == 4 && string(b) == "null" {
+		return nil
+	}
+
+	var str string
+	err := json.Unmarshal(b, &str)
+	if err != nil {
+		return err
+	}
+
+	return q.UnmarshalString(str)
+}
+
+func (q *ByteQuantity) MarshalJSON() ([]byte, error) {
+	return []byte("\"" + q.s + "\""), nil
+}
+
+func (q *ByteQuantity) Bytes() int64 {
+	return q.i
+}
+
 type PortsRange struct {
 	Start  int `json:"start,omitempty"`
 	End    int `json:"end,omitempty"`
@@ -39,6 +39,27 @@ func TestBandwidthQuantity(t *testing.T) {
 	require.Equal(`{"b":"1KB","int":5}`, string(buf))
 }
 
+func TestByteQuantity(t *testing.T) {
+	require := require.New(t)
+
+	var w struct {
+		Q ByteQuantity `json:"q"`
+	}
+	err := json.Unmarshal([]byte(`{"q":"1.5GB"}`), &w)
+	require.NoError(err)
+	require.EqualValues(1.5*GB, w.Q.Bytes())
+
+	buf, err := json.Marshal(&w)
+	require.NoError(err)
+	require.Equal(`{"q":"1.5GB"}`, string(buf))
+
+	q, err := NewByteQuantity("2TB")
+	require.NoError(err)
+	require.EqualValues(2*TB, q.Bytes())
+	_, err = NewByteQuantity("2PB")
+	require.Error(err)
+}
+
 func TestPortsRangeSlice2String(t *testing.T) {
 	require := require.New(t)
 
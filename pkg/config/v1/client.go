@@ -16,7 +16,10 @@ package v1
 
 import (
 	"cmp"
+	"net"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/samber/lo"
@@ -44,6 +47,18 @@ type ClientCommonConfig struct {
 	// ServerPort specifies the port to connect to the server on. By default,
 	// this value is 7000.
 	ServerPort int `json:"serverPort,omitempty"`
+	// Servers specifies an ordered list of servers to connect to instead of
+	// ServerAddr and ServerPort. If the server in use is unreachable, the
+	// next one is tried.
+	Servers []ServerEndpoint `json:"servers,omitempty"`
+	// ServerSelection specifies how the first server is picked from Servers.
+	// Valid values are "order" and "latency". With "latency", the server
+	// with the lowest connection latency at startup is used first. By
+	// default, this value is "order".
+	ServerSelection string `json:"serverSelection,omitempty"`
+	// ServerProfiles specifies other servers the client connects to at the
+	// same time, proxies and visitors choose one by its name.
+	ServerProfiles []ServerProfile `json:"serverProfiles,omitempty"`
 	// DNSServer specifies a DNS server address for FRPC to use. If this value
 	// is "", the default DNS will be used.
 	DNSServer string `json:"dnsServer,omitempty"`
@@ -72,15 +87,86 @@ type ClientCommonConfig struct {
 func (c *ClientCommonConfig) Complete() {
 	c.ServerAddr = cmp.Or(c.ServerAddr, "0.0.0.0")
 	c.ServerPort = cmp.Or(c.ServerPort, 7000)
+	c.ServerSelection = cmp.Or(c.ServerSelection, "order")
 	c.LoginFailExit = cmp.Or(c.LoginFailExit, lo.ToPtr(true))
 
 	c.Auth.Complete()
 	c.Log.Complete()
 	c.Transport.Complete()
+	for i := range c.Servers {
+		c.Servers[i].Port = cmp.Or(c.Servers[i].Port, 7000)
+		c.Servers[i].Protocol = cmp.Or(c.Servers[i].Protocol, c.Transport.Protocol)
+	}
 
 	c.UDPPacketSize = cmp.Or(c.UDPPacketSize, 1500)
 }
 
+// ServerEndpoints returns Servers, or the server of ServerAddr and ServerPort
+// if it's empty.
+func (c *ClientCommonConfig) ServerEndpoints() []ServerEndpoint {
+	if len(c.Servers) > 0 {
+		return c.Servers
+	}
+	return []ServerEndpoint{{Addr: c.ServerAddr, Port: c.ServerPort, Protocol: c.Transport.Protocol}}
+}
+
+// ProfileConfig returns the config of the server profile name, or c itself if
+// name is "" or not found.
+func (c *ClientCommonConfig) ProfileConfig(name string) *ClientCommonConfig {
+	for _, p := range c.ServerProfiles {
+		if name == "" || p.Name != name {
+			continue
+		}
+		cfg := *c
+		cfg.ServerProfiles = nil
+		cfg.User = cmp.Or(p.User, c.User)
+		cfg.ServerAddr = p.ServerAddr
+		cfg.ServerPort = p.ServerPort
+		cfg.Servers = slices.Clone(p.Servers)
+		cfg.ServerSelection = p.ServerSelection
+		cfg.Auth = p.Auth
+		cfg.Transport = p.Transport
+		cfg.Complete()
+		return &cfg
+	}
+	return c
+}
+
+// ServerProfile is a server the client connects to in addition to the main
+// one, with its own auth and transport.
+type ServerProfile struct {
+	Name string `json:"name"`
+	// User defaults to the user of the client.
+	User            string                `json:"user,omitempty"`
+	ServerAddr      string                `json:"serverAddr,omitempty"`
+	ServerPort      int                   `json:"serverPort,omitempty"`
+	Servers         []ServerEndpoint      `json:"servers,omitempty"`
+	ServerSelection string                `json:"serverSelection,omitempty"`
+	Auth            AuthClientConfig      `json:"auth,omitempty"`
+	Transport       ClientTransportConfig `json:"transport,omitempty"`
+}
+
+// ForServer returns a copy of the config connecting to server.
+func (c *ClientCommonConfig) ForServer(server ServerEndpoint) *ClientCommonConfig {
+	cfg := *c
+	cfg.ServerAddr = server.Addr
+	cfg.ServerPort = server.Port
+	cfg.Transport.Protocol = server.Protocol
+	return &cfg
+}
+
+type ServerEndpoint struct {
+	Addr string `json:"addr"`
+	// Port defaults to 7000.
+	Port int `json:"port,omitempty"`
+	// Protocol defaults to transport.protocol.
+	Protocol string `json:"protocol,omitempty"`
+}
+
+func (s ServerEndpoint) String() string {
+	return s.Protocol + "://" + net.JoinHostPort(s.Addr, strconv.Itoa(s.Port))
+}
+
 type ClientTransportConfig struct {
 	// Protocol specifies the protocol to use when interacting with the server.
 	// Valid values are "tcp", "kcp", "quic", "websocket" and "wss". By default, this value
@@ -111,6 +197,8 @@ type ClientTransportConfig struct {
 	TCPMuxKeepaliveInterval int64 `json:"tcpMuxKeepaliveInterval,omitempty"`
 	// QUIC protocol options.
 	QUIC QUICOptions `json:"quic,omitempty"`
+	// KCP protocol options.
+	KCP KCPOptions `json:"kcp,omitempty"`
 	// HeartBeatInterval specifies at what interval heartbeats are sent to the
 	// server, in seconds. It is not recommended to change this value. By
 	// default, this value is 30. Set negative value to disable it.
@@ -141,6 +229,7 @@ func (c *ClientTransportConfig) Complete() {
 		c.HeartbeatTimeout = cmp.Or(c.HeartbeatTimeout, 90)
 	}
 	c.QUIC.Complete()
+	c.KCP.Complete(128, 512)
 	c.TLS.Complete()
 }
 
@@ -32,3 +32,51 @@ func TestClientConfigComplete(t *testing.T) {
 	require.Equal(true, lo.FromPtr(c.Transport.TLS.Enable))
 	require.Equal(true, lo.FromPtr(c.Transport.TLS.DisableCustomTLSFirstByte))
 }
+
+func TestClientConfigServerEndpoints(t *testing.T) {
+	require := require.New(t)
+	c := &ClientConfig{}
+	c.ServerAddr = "127.0.0.1"
+	c.Complete()
+	require.Equal([]ServerEndpoint{{Addr: "127.0.0.1", Port: 7000, Protocol: "tcp"}}, c.ServerEndpoints())
+
+	c.Servers = []ServerEndpoint{{Addr: "a.example.com"}, {Addr: "b.example.com", Port: 7001, Protocol: "quic"}}
+	c.Complete()
+	servers := c.ServerEndpoints()
+	require.Equal("tcp://a.example.com:7000", servers[0].String())
+	require.Equal("quic://b.example.com:7001", servers[1].String())
+
+	cfg := c.ForServer(servers[1])
+	require.Equal("b.example.com", cfg.ServerAddr)
+	require.Equal(7001, cfg.ServerPort)
+	require.Equal("quic", cfg.Transport.Protocol)
+	require.Equal("tcp", c.Transport.Protocol)
+}
+
+func TestClientConfigProfileConfig(t *testing.T) {
+	require := require.New(t)
+	c := &ClientConfig{}
+	c.User = "alice"
+	c.ServerAddr = "public.example.com"
+	c.Auth.Token = "public"
+	c.ServerProfiles = []ServerProfile{{
+		Name:       "internal",
+		ServerAddr: "internal.example.com",
+		Auth:       AuthClientConfig{Token: "internal"},
+		Transport:  ClientTransportConfig{Protocol: "quic"},
+	}}
+	c.Complete()
+
+	require.Same(&c.ClientCommonConfig, c.ProfileConfig(""))
+	require.Same(&c.ClientCommonConfig, c.ProfileConfig("unknown"))
+
+	cfg := c.ProfileConfig("internal")
+	require.Equal("alice", cfg.User)
+	require.Equal("internal.example.com", cfg.ServerAddr)
+	require.Equal(7000, cfg.ServerPort)
+	require.Equal("internal", cfg.Auth.Token)
+	require.EqualValues("token", cfg.Auth.Method)
+	require.Equal("quic", cfg.Transport.Protocol)
+	require.Empty(cfg.ServerProfiles)
+	require.Equal("public.example.com", c.ServerAddr)
+}
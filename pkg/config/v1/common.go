@@ -46,6 +46,73 @@ func (c *QUICOptions) Complete() {
 	c.MaxIncomingStreams = cmp.Or(c.MaxIncomingStreams, 100000)
 }
 
+const (
+	KCPModeNormal = "normal"
+	KCPModeFast   = "fast"
+	KCPModeTurbo  = "turbo"
+)
+
+type kcpPreset struct {
+	noDelay      bool
+	interval     int
+	resend       int
+	noCongestion bool
+}
+
+// kcpPresets are the no-delay settings of each mode, from the most
+// conservative to the most aggressive retransmission.
+var kcpPresets = map[string]kcpPreset{
+	KCPModeNormal: {noDelay: false, interval: 40, resend: 2, noCongestion: true},
+	KCPModeFast:   {noDelay: true, interval: 20, resend: 2, noCongestion: true},
+	KCPModeTurbo:  {noDelay: true, interval: 10, resend: 2, noCongestion: true},
+}
+
+// KCP protocol options
+type KCPOptions struct {
+	// Mode is a preset of NoDelay, Interval, Resend and NoCongestion, the
+	// fields set explicitly override it. Valid values are "normal", "fast" and
+	// "turbo". By default, this value is "fast".
+	Mode    string `json:"mode,omitempty"`
+	NoDelay *bool  `json:"noDelay,omitempty"`
+	// Interval is the internal update interval in milliseconds.
+	Interval int `json:"interval,omitempty"`
+	// Resend is the number of duplicate ACKs triggering a fast retransmission.
+	Resend       int   `json:"resend,omitempty"`
+	NoCongestion *bool `json:"noCongestion,omitempty"`
+	// MTU defaults to 1350.
+	MTU int `json:"mtu,omitempty"`
+	// SendWindow and ReceiveWindow are in packets.
+	SendWindow    int `json:"sendWindow,omitempty"`
+	ReceiveWindow int `json:"receiveWindow,omitempty"`
+	// DataShards and ParityShards of the forward error correction, 10 and 3 by
+	// default. Set ParityShards to a negative value to disable it.
+	DataShards   int `json:"dataShards,omitempty"`
+	ParityShards int `json:"parityShards,omitempty"`
+	// ReadBufferSize and WriteBufferSize of the UDP socket in bytes, 4 MiB by
+	// default.
+	ReadBufferSize  int `json:"readBufferSize,omitempty"`
+	WriteBufferSize int `json:"writeBufferSize,omitempty"`
+}
+
+// Complete fills the unset options, the default window sizes differ between
+// frpc and frps.
+func (c *KCPOptions) Complete(sendWindow, receiveWindow int) {
+	c.Mode = cmp.Or(c.Mode, KCPModeFast)
+	if preset, ok := kcpPresets[c.Mode]; ok {
+		c.NoDelay = cmp.Or(c.NoDelay, &preset.noDelay)
+		c.Interval = cmp.Or(c.Interval, preset.interval)
+		c.Resend = cmp.Or(c.Resend, preset.resend)
+		c.NoCongestion = cmp.Or(c.NoCongestion, &preset.noCongestion)
+	}
+	c.MTU = cmp.Or(c.MTU, 1350)
+	c.SendWindow = cmp.Or(c.SendWindow, sendWindow)
+	c.ReceiveWindow = cmp.Or(c.ReceiveWindow, receiveWindow)
+	c.DataShards = cmp.Or(c.DataShards, 10)
+	c.ParityShards = cmp.Or(c.ParityShards, 3)
+	c.ReadBufferSize = cmp.Or(c.ReadBufferSize, 4*1024*1024)
+	c.WriteBufferSize = cmp.Or(c.WriteBufferSize, 4*1024*1024)
+}
+
 type TLSConfig struct {
 	// CertPath specifies the path of the cert file that client will load.
 	CertFile string `json:"certFile,omitempty"`
@@ -0,0 +1,35 @@
+package v1
+
+import (
+	"testing"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKCPOptionsComplete(t *testing.T) {
+	require := require.New(t)
+
+	c := &KCPOptions{}
+	c.Complete(128, 512)
+	require.Equal(KCPModeFast, c.Mode)
+	require.True(lo.FromPtr(c.NoDelay))
+	require.Equal(20, c.Interval)
+	require.Equal(2, c.Resend)
+	require.True(lo.FromPtr(c.NoCongestion))
+	require.Equal(1350, c.MTU)
+	require.Equal(128, c.SendWindow)
+	require.Equal(512, c.ReceiveWindow)
+	require.Equal(10, c.DataShards)
+	require.Equal(3, c.ParityShards)
+	require.Equal(4*1024*1024, c.ReadBufferSize)
+
+	// explicit fields override the mode
+	c = &KCPOptions{Mode: KCPModeNormal, Interval: 100, NoCongestion: lo.ToPtr(false), ParityShards: -1}
+	c.Complete(1024, 1024)
+	require.False(lo.FromPtr(c.NoDelay))
+	require.Equal(100, c.Interval)
+	require.False(lo.FromPtr(c.NoCongestion))
+	require.Equal(-1, c.ParityShards)
+	require.Equal(1024, c.SendWindow)
+}
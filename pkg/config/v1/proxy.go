@@ -39,6 +39,13 @@ type ProxyTransport struct {
 	// BandwidthLimit limit the bandwidth
 	// 0 means no limit
 	BandwidthLimit types.BandwidthQuantity `json:"bandwidthLimit,omitempty"`
+	// BandwidthLimitUp and BandwidthLimitDown override BandwidthLimit for the
+	// traffic sent by frpc to users and the traffic sent by users to frpc.
+	BandwidthLimitUp   types.BandwidthQuantity `json:"bandwidthLimitUp,omitempty"`
+	BandwidthLimitDown types.BandwidthQuantity `json:"bandwidthLimitDown,omitempty"`
+	// BandwidthLimitBurst specifies how much traffic may be sent at once in
+	// each direction. By default, it's the traffic of one second.
+	BandwidthLimitBurst types.ByteQuantity `json:"bandwidthLimitBurst,omitempty"`
 	// BandwidthLimitMode specifies whether to limit the bandwidth on the
 	// client or server side. Valid values include "client" and "server".
 	// By default, this value is "client".
@@ -47,6 +54,50 @@ type ProxyTransport struct {
 	// values include "v1", "v2", and "". If the value is "", a protocol
 	// version will be automatically selected. By default, this value is "".
 	ProxyProtocolVersion string `json:"proxyProtocolVersion,omitempty"`
+	// DrainTimeoutSeconds specifies how long the connections in use may keep
+	// running after the proxy is taken out of service by a failed health
+	// check or a drain request, after which frps closes them. If the value is
+	// 0, they run until they finish.
+	DrainTimeoutSeconds int `json:"drainTimeoutSeconds,omitempty"`
+	// MaxConnections limits the user connections of the proxy in use at the
+	// same time. It is enforced by frps, 0 means no limit.
+	MaxConnections int `json:"maxConnections,omitempty"`
+	// NewConnectionRate limits the new user connections per second of the
+	// proxy, and NewConnectionRatePerIP those from each source IP. Bursts up
+	// to the rate are allowed. They are enforced by frps, 0 means no limit.
+	// For http proxies, these limits apply to HTTP requests instead.
+	NewConnectionRate      int `json:"newConnectionRate,omitempty"`
+	NewConnectionRatePerIP int `json:"newConnectionRatePerIP,omitempty"`
+	// TrafficQuota limits the traffic of the proxy, it is enforced by frps.
+	TrafficQuota TrafficQuota `json:"trafficQuota,omitempty"`
+}
+
+// BandwidthLimits returns the upload and download limits in bytes per second,
+// 0 means no limit.
+func (c *ProxyTransport) BandwidthLimits() (up int64, down int64) {
+	up, down = c.BandwidthLimit.Bytes(), c.BandwidthLimit.Bytes()
+	if c.BandwidthLimitUp.Bytes() > 0 {
+		up = c.BandwidthLimitUp.Bytes()
+	}
+	if c.BandwidthLimitDown.Bytes() > 0 {
+		down = c.BandwidthLimitDown.Bytes()
+	}
+	return
+}
+
+// TrafficQuota limits the traffic in both directions in a calendar day and
+// month of the server's local time.
+type TrafficQuota struct {
+	// Daily and Monthly are the quotas, 0 means no limit.
+	Daily   types.ByteQuantity `json:"daily,omitempty"`
+	Monthly types.ByteQuantity `json:"monthly,omitempty"`
+	// CloseConnections closes the connections in use once a quota is
+	// exhausted, otherwise only new connections are refused.
+	CloseConnections bool `json:"closeConnections,omitempty"`
+}
+
+func (q *TrafficQuota) IsSet() bool {
+	return q.Daily.Bytes() > 0 || q.Monthly.Bytes() > 0
 }
 
 type LoadBalancerConfig struct {
@@ -57,6 +108,42 @@ type LoadBalancerConfig struct {
 	// GroupKey specifies a group key, which should be the same among proxies
 	// of the same group.
 	GroupKey string `json:"groupKey,omitempty"`
+	// Strategy specifies how the server distributes connections among the
+	// proxies of the group. Valid values include "round_robin", "random",
+	// "least_conn", "weighted" and "source_hash". It should be the same among
+	// proxies of the same group. By default, this value is "round_robin".
+	Strategy LoadBalanceStrategy `json:"strategy,omitempty"`
+	// Weight specifies the relative share of connections this proxy receives
+	// with the "weighted" and "source_hash" strategies. It should be at most
+	// MaxLoadBalanceWeight. By default, this value is 1.
+	Weight int `json:"weight,omitempty"`
+	// AffinityCookie specifies the name of a cookie that the server sets to
+	// send later requests of a client to the same proxy of an http group, as
+	// long as that proxy is in the group. If the value is "", requests are
+	// distributed by Strategy only.
+	AffinityCookie string `json:"affinityCookie,omitempty"`
+}
+
+type LoadBalanceStrategy string
+
+const (
+	LoadBalanceRoundRobin LoadBalanceStrategy = "round_robin"
+	LoadBalanceRandom     LoadBalanceStrategy = "random"
+	LoadBalanceLeastConn  LoadBalanceStrategy = "least_conn"
+	LoadBalanceWeighted   LoadBalanceStrategy = "weighted"
+	LoadBalanceSourceHash LoadBalanceStrategy = "source_hash"
+)
+
+// MaxLoadBalanceWeight bounds the weight of a proxy, the "source_hash" ring
+// of a group grows with the weights of its proxies.
+const MaxLoadBalanceWeight = 100
+
+var LoadBalanceStrategies = []LoadBalanceStrategy{
+	LoadBalanceRoundRobin,
+	LoadBalanceRandom,
+	LoadBalanceLeastConn,
+	LoadBalanceWeighted,
+	LoadBalanceSourceHash,
 }
 
 type ProxyBackend struct {
@@ -65,32 +152,59 @@ type ProxyBackend struct {
 	// LocalPort specifies the port of the backend.
 	LocalPort int `json:"localPort,omitempty"`
 
+	// Backends specifies more local services besides LocalIP and LocalPort.
+	// They are not supported by udp and sudp proxies and plugins.
+	Backends []LocalBackend `json:"backends,omitempty"`
+	// BackendsMode specifies how the local services are used, "failover" tries
+	// them in order and "round_robin" spreads connections among them. The
+	// default is "failover".
+	BackendsMode string `json:"backendsMode,omitempty"`
+
 	// Plugin specifies what plugin should be used for handling connections. If this value
 	// is set, the LocalIP and LocalPort values will be ignored.
 	Plugin TypedClientPluginOptions `json:"plugin,omitempty"`
 }
 
+// LocalBackend is a local service of a proxy. If health check is enabled, it
+// is checked on its own and skipped while it is down.
+type LocalBackend struct {
+	LocalIP   string `json:"localIP,omitempty"`
+	LocalPort int    `json:"localPort,omitempty"`
+}
+
 // HealthCheckConfig configures health checking. This can be useful for load
 // balancing purposes to detect and remove proxies to failing services.
 type HealthCheckConfig struct {
 	// Type specifies what protocol to use for health checking.
-	// Valid values include "tcp", "http", and "". If this value is "", health
-	// checking will not be performed.
+	// Valid values include "tcp", "http", "grpc", "udp" and "". If this value
+	// is "", health checking will not be performed.
 	//
 	// If the type is "tcp", a connection will be attempted to the target
 	// server. If a connection cannot be established, the health check fails.
+	// If Send or Expect is set, Send is written to the connection and the
+	// response must match Expect.
+	//
+	// If the type is "http", a request will be made to the endpoint
+	// specified by Path. If the response status is not one of ExpectedStatus,
+	// or the body does not match Expect, the health check fails.
 	//
-	// If the type is "http", a GET request will be made to the endpoint
-	// specified by HealthCheckURL. If the response is not a 200, the health
-	// check fails.
-	Type string `json:"type"` // tcp | http
+	// If the type is "grpc", grpc.health.v1.Health/Check is called for
+	// GRPCService. If the service is not SERVING, the health check fails.
+	//
+	// If the type is "udp", Send or the DNSQuery query is sent to the target
+	// server. If no reply arrives, or it does not match Expect or is not a
+	// successful DNS response, the health check fails.
+	Type string `json:"type"` // tcp | http | grpc | udp
 	// TimeoutSeconds specifies the number of seconds to wait for a health
 	// check attempt to connect. If the timeout is reached, this counts as a
 	// health check failure. By default, this value is 3.
 	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
-	// MaxFailed specifies the number of allowed failures before the
-	// is stopped. By default, this value is 1.
+	// MaxFailed specifies the number of consecutive failures before the
+	// proxy is stopped. By default, this value is 1.
 	MaxFailed int `json:"maxFailed,omitempty"`
+	// HealthyThreshold specifies the number of consecutive successes before a
+	// failed proxy is started again. By default, this value is 1.
+	HealthyThreshold int `json:"healthyThreshold,omitempty"`
 	// IntervalSeconds specifies the time in seconds between health
 	// checks. By default, this value is 10.
 	IntervalSeconds int `json:"intervalSeconds"`
@@ -100,6 +214,28 @@ type HealthCheckConfig struct {
 	// HTTPHeaders specifies the headers to send with the health request, if
 	// the health check type is "http".
 	HTTPHeaders []HTTPHeader `json:"httpHeaders,omitempty"`
+	// Method specifies the method of the health request, if the health check
+	// type is "http". By default, this value is "GET".
+	Method string `json:"method,omitempty"`
+	// ExpectedStatus specifies the response status codes of a healthy
+	// service, if the health check type is "http". By default, any 2xx
+	// status is healthy.
+	ExpectedStatus []int `json:"expectedStatus,omitempty"`
+	// Expect specifies a regular expression that the response body, if the
+	// health check type is "http", or the data read from the connection, if
+	// the health check type is "tcp" or "udp", must match.
+	Expect string `json:"expect,omitempty"`
+	// Send specifies the data written to the connection, if the health check
+	// type is "tcp", or the datagram sent, if the health check type is "udp".
+	Send string `json:"send,omitempty"`
+	// DNSQuery specifies a domain name to query for A records instead of
+	// sending Send, if the health check type is "udp". The check succeeds if
+	// the server answers NOERROR or NXDOMAIN.
+	DNSQuery string `json:"dnsQuery,omitempty"`
+	// GRPCService specifies the service name to check, if the health check
+	// type is "grpc". If the value is "", the health of the whole server is
+	// checked.
+	GRPCService string `json:"grpcService,omitempty"`
 }
 
 type DomainConfig struct {
@@ -116,6 +252,15 @@ type ProxyBaseConfig struct {
 	Metadatas    map[string]string  `json:"metadatas,omitempty"`
 	LoadBalancer LoadBalancerConfig `json:"loadBalancer,omitempty"`
 	HealthCheck  HealthCheckConfig  `json:"healthCheck,omitempty"`
+	// AllowSourceIPs and DenySourceIPs are the CIDRs or IP addresses of the
+	// users that may or may not connect to the proxy, they are enforced by
+	// frps. If AllowSourceIPs is not empty, only the addresses in it may
+	// connect.
+	AllowSourceIPs []string `json:"allowSourceIPs,omitempty"`
+	DenySourceIPs  []string `json:"denySourceIPs,omitempty"`
+	// ServerProfile is the name of the server profile the proxy is
+	// registered to, "" means the main server.
+	ServerProfile string `json:"serverProfile,omitempty"`
 	ProxyBackend
 }
 
@@ -126,6 +271,10 @@ func (c *ProxyBaseConfig) GetBaseConfig() *ProxyBaseConfig {
 func (c *ProxyBaseConfig) Complete(namePrefix string) {
 	c.Name = lo.Ternary(namePrefix == "", "", namePrefix+".") + c.Name
 	c.LocalIP = cmp.Or(c.LocalIP, "127.0.0.1")
+	for i := range c.Backends {
+		c.Backends[i].LocalIP = cmp.Or(c.Backends[i].LocalIP, "127.0.0.1")
+	}
+	c.BackendsMode = cmp.Or(c.BackendsMode, "failover")
 	c.Transport.BandwidthLimitMode = cmp.Or(c.Transport.BandwidthLimitMode, types.BandwidthLimitModeClient)
 
 	if c.Plugin.ClientPluginOptions != nil {
@@ -139,14 +288,28 @@ func (c *ProxyBaseConfig) MarshalToMsg(m *msg.NewProxy) {
 	m.UseEncryption = c.Transport.UseEncryption
 	m.UseCompression = c.Transport.UseCompression
 	m.BandwidthLimit = c.Transport.BandwidthLimit.String()
+	m.BandwidthLimitUp = c.Transport.BandwidthLimitUp.String()
+	m.BandwidthLimitDown = c.Transport.BandwidthLimitDown.String()
+	m.BandwidthLimitBurst = c.Transport.BandwidthLimitBurst.String()
 	// leave it empty for default value to reduce traffic
 	if c.Transport.BandwidthLimitMode != "client" {
 		m.BandwidthLimitMode = c.Transport.BandwidthLimitMode
 	}
 	m.Group = c.LoadBalancer.Group
 	m.GroupKey = c.LoadBalancer.GroupKey
+	m.GroupStrategy = string(c.LoadBalancer.Strategy)
+	m.GroupWeight = c.LoadBalancer.Weight
+	m.GroupAffinityCookie = c.LoadBalancer.AffinityCookie
 	m.Metas = c.Metadatas
 	m.Annotations = c.Annotations
+	m.AllowSourceIPs = c.AllowSourceIPs
+	m.DenySourceIPs = c.DenySourceIPs
+	m.MaxConnections = c.Transport.MaxConnections
+	m.NewConnectionRate = c.Transport.NewConnectionRate
+	m.NewConnectionRatePerIP = c.Transport.NewConnectionRatePerIP
+	m.TrafficQuotaDaily = c.Transport.TrafficQuota.Daily.String()
+	m.TrafficQuotaMonthly = c.Transport.TrafficQuota.Monthly.String()
+	m.TrafficQuotaCloseConnections = c.Transport.TrafficQuota.CloseConnections
 }
 
 func (c *ProxyBaseConfig) UnmarshalFromMsg(m *msg.NewProxy) {
@@ -157,13 +320,27 @@ func (c *ProxyBaseConfig) UnmarshalFromMsg(m *msg.NewProxy) {
 	if m.BandwidthLimit != "" {
 		c.Transport.BandwidthLimit, _ = types.NewBandwidthQuantity(m.BandwidthLimit)
 	}
+	c.Transport.BandwidthLimitUp, _ = types.NewBandwidthQuantity(m.BandwidthLimitUp)
+	c.Transport.BandwidthLimitDown, _ = types.NewBandwidthQuantity(m.BandwidthLimitDown)
+	c.Transport.BandwidthLimitBurst, _ = types.NewByteQuantity(m.BandwidthLimitBurst)
 	if m.BandwidthLimitMode != "" {
 		c.Transport.BandwidthLimitMode = m.BandwidthLimitMode
 	}
 	c.LoadBalancer.Group = m.Group
 	c.LoadBalancer.GroupKey = m.GroupKey
+	c.LoadBalancer.Strategy = LoadBalanceStrategy(m.GroupStrategy)
+	c.LoadBalancer.Weight = m.GroupWeight
+	c.LoadBalancer.AffinityCookie = m.GroupAffinityCookie
 	c.Metadatas = m.Metas
 	c.Annotations = m.Annotations
+	c.AllowSourceIPs = m.AllowSourceIPs
+	c.DenySourceIPs = m.DenySourceIPs
+	c.Transport.MaxConnections = m.MaxConnections
+	c.Transport.NewConnectionRate = m.NewConnectionRate
+	c.Transport.NewConnectionRatePerIP = m.NewConnectionRatePerIP
+	c.Transport.TrafficQuota.Daily, _ = types.NewByteQuantity(m.TrafficQuotaDaily)
+	c.Transport.TrafficQuota.Monthly, _ = types.NewByteQuantity(m.TrafficQuotaMonthly)
+	c.Transport.TrafficQuota.CloseConnections = m.TrafficQuotaCloseConnections
 }
 
 type TypedProxyConfig struct {
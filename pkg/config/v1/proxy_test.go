@@ -19,6 +19,8 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+
+	"github.com/fatedier/frp/pkg/msg"
 )
 
 func TestUnmarshalTypedProxyConfig(t *testing.T) {
@@ -47,3 +49,23 @@ func TestUnmarshalTypedProxyConfig(t *testing.T) {
 	require.IsType(&TCPProxyConfig{}, proxyConfigs.Proxies[0].ProxyConfigurer)
 	require.IsType(&HTTPProxyConfig{}, proxyConfigs.Proxies[1].ProxyConfigurer)
 }
+
+func TestProxyTransportBandwidthLimits(t *testing.T) {
+	require := require.New(t)
+	var c ProxyTransport
+	err := json.Unmarshal([]byte(`{"bandwidthLimit": "1MB", "bandwidthLimitDown": "512KB"}`), &c)
+	require.NoError(err)
+	up, down := c.BandwidthLimits()
+	require.EqualValues(1024*1024, up)
+	require.EqualValues(512*1024, down)
+
+	// limits are carried to frps
+	var m msg.NewProxy
+	base := ProxyBaseConfig{Transport: c}
+	base.MarshalToMsg(&m)
+	var got ProxyBaseConfig
+	got.UnmarshalFromMsg(&m)
+	up, down = got.Transport.BandwidthLimits()
+	require.EqualValues(1024*1024, up)
+	require.EqualValues(512*1024, down)
+}
@@ -59,6 +59,12 @@ type ServerConfig struct {
 	TCPMuxHTTPConnectPort int `json:"tcpmuxHTTPConnectPort,omitempty"`
 	// If TCPMuxPassthrough is true, frps won't do any update on traffic.
 	TCPMuxPassthrough bool `json:"tcpmuxPassthrough,omitempty"`
+	// ProxyProtocolTrustedCIDRs specifies the addresses of the load balancers
+	// in front of frps. PROXY protocol headers from these addresses are
+	// accepted on the remote ports of tcp proxies, the vhost ports and the
+	// tcpmux port, and the client address in them is used as the address of
+	// user connections. It doesn't apply to vhost ports shared with BindPort.
+	ProxyProtocolTrustedCIDRs []string `json:"proxyProtocolTrustedCIDRs,omitempty"`
 	// SubDomainHost specifies the domain that will be attached to sub-domains
 	// requested by the client when using Vhost proxying. For example, if this
 	// value is set to "frps.com" and the client requested the subdomain
@@ -89,6 +95,15 @@ type ServerConfig struct {
 
 	AllowPorts []types.PortsRange `json:"allowPorts,omitempty"`
 
+	// ProxySourceIPs specifies the source IP lists of proxies that set none.
+	ProxySourceIPs ProxySourceIPsConfig `json:"proxySourceIPs,omitempty"`
+
+	TrafficQuota TrafficQuotaServerConfig `json:"trafficQuota,omitempty"`
+
+	// BandwidthLimit specifies the bandwidth limits shared by all proxies of
+	// a client or a user, applied on top of the limit of each proxy.
+	BandwidthLimit BandwidthLimitServerConfig `json:"bandwidthLimit,omitempty"`
+
 	HTTPPlugins []HTTPPluginOptions `json:"httpPlugins,omitempty"`
 }
 
@@ -151,12 +166,18 @@ type ServerTransportConfig struct {
 	// MaxPoolCount specifies the maximum pool size for each proxy. By default,
 	// this value is 5.
 	MaxPoolCount int64 `json:"maxPoolCount,omitempty"`
+	// MaxDrainTimeoutSeconds bounds the drain timeout requested by clients
+	// when closing a proxy. By default, this value is 300, 0 also means the
+	// default.
+	MaxDrainTimeoutSeconds int64 `json:"maxDrainTimeoutSeconds,omitempty"`
 	// HeartBeatTimeout specifies the maximum time to wait for a heartbeat
 	// before terminating the connection. It is not recommended to change this
 	// value. By default, this value is 90. Set negative value to disable it.
 	HeartbeatTimeout int64 `json:"heartbeatTimeout,omitempty"`
 	// QUIC options.
 	QUIC QUICOptions `json:"quic,omitempty"`
+	// KCP options.
+	KCP KCPOptions `json:"kcp,omitempty"`
 	// TLS specifies TLS settings for the connection from the client.
 	TLS TLSServerConfig `json:"tls,omitempty"`
 }
@@ -166,6 +187,7 @@ func (c *ServerTransportConfig) Complete() {
 	c.TCPMuxKeepaliveInterval = cmp.Or(c.TCPMuxKeepaliveInterval, 30)
 	c.TCPKeepAlive = cmp.Or(c.TCPKeepAlive, 7200)
 	c.MaxPoolCount = cmp.Or(c.MaxPoolCount, 5)
+	c.MaxDrainTimeoutSeconds = cmp.Or(c.MaxDrainTimeoutSeconds, 300)
 	if lo.FromPtr(c.TCPMux) {
 		// If TCPMux is enabled, heartbeat of application layer is unnecessary because we can rely on heartbeat in tcpmux.
 		c.HeartbeatTimeout = cmp.Or(c.HeartbeatTimeout, -1)
@@ -173,6 +195,7 @@ func (c *ServerTransportConfig) Complete() {
 		c.HeartbeatTimeout = cmp.Or(c.HeartbeatTimeout, 90)
 	}
 	c.QUIC.Complete()
+	c.KCP.Complete(1024, 1024)
 	if c.TLS.TrustedCaFile != "" {
 		c.TLS.Force = true
 	}
@@ -185,6 +208,55 @@ type TLSServerConfig struct {
 	TLSConfig
 }
 
+type ProxySourceIPsConfig struct {
+	// Allow and Deny are the CIDRs or IP addresses of the users that may or
+	// may not connect to proxies. If Allow is not empty, only the addresses in
+	// it may connect.
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+	// Force applies the lists to all proxies, in addition to the lists of
+	// the proxy.
+	Force bool `json:"force,omitempty"`
+}
+
+type TrafficQuotaServerConfig struct {
+	// StateFile specifies the file the traffic counted against quotas is
+	// saved to, so it is kept across restarts. If this value is "", it is
+	// kept in memory only.
+	StateFile string `json:"stateFile,omitempty"`
+	// Users specifies the quotas of users, shared by all proxies of a user.
+	Users []UserTrafficQuota `json:"users,omitempty"`
+}
+
+type UserTrafficQuota struct {
+	User string `json:"user"`
+	TrafficQuota
+}
+
+type BandwidthLimitServerConfig struct {
+	// PerClient limits the proxies of each client, identified by its run ID.
+	PerClient AggregateBandwidthLimit `json:"perClient,omitempty"`
+	// PerUser limits the proxies of each user, unless the user is listed in
+	// Users.
+	PerUser AggregateBandwidthLimit `json:"perUser,omitempty"`
+	Users   []UserBandwidthLimit    `json:"users,omitempty"`
+}
+
+// AggregateBandwidthLimit is a bandwidth limit shared by several proxies.
+// Upload is the traffic sent by frpc to users and download is the traffic
+// sent by users to frpc. The bursts default to the traffic of one second.
+type AggregateBandwidthLimit struct {
+	Upload        types.BandwidthQuantity `json:"upload,omitempty"`
+	Download      types.BandwidthQuantity `json:"download,omitempty"`
+	UploadBurst   types.ByteQuantity      `json:"uploadBurst,omitempty"`
+	DownloadBurst types.ByteQuantity      `json:"downloadBurst,omitempty"`
+}
+
+type UserBandwidthLimit struct {
+	User string `json:"user"`
+	AggregateBandwidthLimit
+}
+
 type SSHTunnelGateway struct {
 	BindPort              int    `json:"bindPort,omitempty"`
 	PrivateKeyFile        string `json:"privateKeyFile,omitempty"`
@@ -29,4 +29,5 @@ func TestServerConfigComplete(t *testing.T) {
 	require.EqualValues("token", c.Auth.Method)
 	require.Equal(true, lo.FromPtr(c.Transport.TCPMux))
 	require.Equal(true, lo.FromPtr(c.DetailedErrorsToClient))
+	require.EqualValues(300, c.Transport.MaxDrainTimeoutSeconds)
 }
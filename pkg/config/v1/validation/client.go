@@ -63,6 +63,34 @@ func ValidateClientCommonConfig(c *v1.ClientCommonConfig) (Warning, error) {
 	if !slices.Contains(SupportedTransportProtocols, c.Transport.Protocol) {
 		errs = AppendError(errs, fmt.Errorf("invalid transport.protocol, optional values are %v", SupportedTransportProtocols))
 	}
+	errs = AppendError(errs, validateKCPOptions(&c.Transport.KCP, "transport.kcp"))
+	for _, s := range c.Servers {
+		if s.Addr == "" {
+			errs = AppendError(errs, fmt.Errorf("servers: addr is required"))
+		}
+		errs = AppendError(errs, ValidatePort(s.Port, "servers.port"))
+		if !slices.Contains(SupportedTransportProtocols, s.Protocol) {
+			errs = AppendError(errs, fmt.Errorf("invalid servers.protocol, optional values are %v", SupportedTransportProtocols))
+		}
+	}
+	if !slices.Contains([]string{"order", "latency"}, c.ServerSelection) {
+		errs = AppendError(errs, fmt.Errorf("invalid serverSelection, optional values are [order latency]"))
+	}
+
+	profiles := make(map[string]struct{})
+	for _, p := range c.ServerProfiles {
+		if p.Name == "" {
+			errs = AppendError(errs, fmt.Errorf("serverProfiles: name is required"))
+			continue
+		}
+		if _, ok := profiles[p.Name]; ok {
+			errs = AppendError(errs, fmt.Errorf("serverProfiles: duplicate name [%s]", p.Name))
+		}
+		profiles[p.Name] = struct{}{}
+		if _, err := ValidateClientCommonConfig(c.ProfileConfig(p.Name)); err != nil {
+			errs = AppendError(errs, fmt.Errorf("serverProfiles [%s]: %v", p.Name, err))
+		}
+	}
 
 	for _, f := range c.IncludeConfigFiles {
 		absDir, err := filepath.Abs(filepath.Dir(f))
@@ -87,16 +115,40 @@ func ValidateAllClientConfig(c *v1.ClientCommonConfig, proxyCfgs []v1.ProxyConfi
 		}
 	}
 
+	// without the common config, the server profiles can't be checked
+	var profiles map[string]struct{}
+	if c != nil {
+		profiles = make(map[string]struct{})
+		for _, p := range c.ServerProfiles {
+			profiles[p.Name] = struct{}{}
+		}
+	}
+	validateProfile := func(name string) error {
+		if _, ok := profiles[name]; profiles != nil && name != "" && !ok {
+			return fmt.Errorf("server profile [%s] not found", name)
+		}
+		return nil
+	}
+
 	for _, c := range proxyCfgs {
 		if err := ValidateProxyConfigurerForClient(c); err != nil {
 			return warnings, fmt.Errorf("proxy %s: %v", c.GetBaseConfig().Name, err)
 		}
+		if warning := ProxyConfigurerWarningForClient(c); warning != nil {
+			warnings = AppendError(warnings, fmt.Errorf("proxy %s: %v", c.GetBaseConfig().Name, warning))
+		}
+		if err := validateProfile(c.GetBaseConfig().ServerProfile); err != nil {
+			return warnings, fmt.Errorf("proxy %s: %v", c.GetBaseConfig().Name, err)
+		}
 	}
 
 	for _, c := range visitorCfgs {
 		if err := ValidateVisitorConfigurer(c); err != nil {
 			return warnings, fmt.Errorf("visitor %s: %v", c.GetBaseConfig().Name, err)
 		}
+		if err := validateProfile(c.GetBaseConfig().ServerProfile); err != nil {
+			return warnings, fmt.Errorf("visitor %s: %v", c.GetBaseConfig().Name, err)
+		}
 	}
 	return warnings, nil
 }
@@ -29,6 +29,32 @@ func ValidatePort(port int, fieldPath string) error {
 	return fmt.Errorf("%s: port number %d must be in the range 0..65535", fieldPath, port)
 }
 
+func validateKCPOptions(c *v1.KCPOptions, fieldPath string) error {
+	var errs error
+	if !slices.Contains(SupportedKCPModes, c.Mode) {
+		errs = AppendError(errs, fmt.Errorf("invalid %s.mode, optional values are %v", fieldPath, SupportedKCPModes))
+	}
+	if c.Interval < 10 || c.Interval > 5000 {
+		errs = AppendError(errs, fmt.Errorf("%s.interval must be in the range 10..5000", fieldPath))
+	}
+	if c.Resend < 0 {
+		errs = AppendError(errs, fmt.Errorf("%s.resend must not be negative", fieldPath))
+	}
+	if c.MTU < 50 || c.MTU > 1500 {
+		errs = AppendError(errs, fmt.Errorf("%s.mtu must be in the range 50..1500", fieldPath))
+	}
+	if c.SendWindow < 0 || c.ReceiveWindow < 0 {
+		errs = AppendError(errs, fmt.Errorf("%s.sendWindow and %s.receiveWindow must not be negative", fieldPath, fieldPath))
+	}
+	if c.DataShards < 0 {
+		errs = AppendError(errs, fmt.Errorf("%s.dataShards must not be negative", fieldPath))
+	}
+	if c.ReadBufferSize < 0 || c.WriteBufferSize < 0 {
+		errs = AppendError(errs, fmt.Errorf("%s.readBufferSize and %s.writeBufferSize must not be negative", fieldPath, fieldPath))
+	}
+	return errs
+}
+
 func validateLogConfig(c *v1.LogConfig) error {
 	if !slices.Contains(SupportedLogLevels, c.Level) {
 		return fmt.Errorf("invalid log level, optional values are %v", SupportedLogLevels)
@@ -17,12 +17,16 @@ package validation
 import (
 	"errors"
 	"fmt"
+	"net/http"
+	"regexp"
 	"slices"
 	"strings"
 
+	"golang.org/x/net/dns/dnsmessage"
 	"k8s.io/apimachinery/pkg/util/validation"
 
 	v1 "github.com/fatedier/frp/pkg/config/v1"
+	netpkg "github.com/fatedier/frp/pkg/util/net"
 )
 
 func validateProxyBaseConfigForClient(c *v1.ProxyBaseConfig) error {
@@ -40,20 +44,37 @@ func validateProxyBaseConfigForClient(c *v1.ProxyBaseConfig) error {
 		return fmt.Errorf("bandwidth limit mode should be client or server")
 	}
 
+	if err := validateLoadBalancerConfig(&c.LoadBalancer); err != nil {
+		return err
+	}
+	if _, err := netpkg.NewIPFilter(c.AllowSourceIPs, c.DenySourceIPs); err != nil {
+		return fmt.Errorf("invalid source IPs: %v", err)
+	}
+	if c.Transport.DrainTimeoutSeconds < 0 {
+		return fmt.Errorf("drain timeout should not be negative")
+	}
+	if err := validateConnectionLimits(&c.Transport); err != nil {
+		return err
+	}
+
 	if c.Plugin.Type == "" {
 		if err := ValidatePort(c.LocalPort, "localPort"); err != nil {
 			return fmt.Errorf("localPort: %v", err)
 		}
+		for i, b := range c.Backends {
+			if err := ValidatePort(b.LocalPort, "localPort"); err != nil {
+				return fmt.Errorf("backends[%d].localPort: %v", i, err)
+			}
+		}
+	} else if len(c.Backends) > 0 {
+		return fmt.Errorf("backends should not be set with plugin")
 	}
-
-	if !slices.Contains([]string{"", "tcp", "http"}, c.HealthCheck.Type) {
-		return fmt.Errorf("not support health check type: %s", c.HealthCheck.Type)
+	if !slices.Contains([]string{"", "failover", "round_robin"}, c.BackendsMode) {
+		return fmt.Errorf("not support backends mode: %s", c.BackendsMode)
 	}
-	if c.HealthCheck.Type != "" {
-		if c.HealthCheck.Type == "http" &&
-			c.HealthCheck.Path == "" {
-			return fmt.Errorf("health check path should not be empty")
-		}
+
+	if err := validateHealthCheckConfig(&c.HealthCheck); err != nil {
+		return err
 	}
 
 	if c.Plugin.Type != "" {
@@ -64,10 +85,71 @@ func validateProxyBaseConfigForClient(c *v1.ProxyBaseConfig) error {
 	return nil
 }
 
+func validateHealthCheckConfig(c *v1.HealthCheckConfig) error {
+	if !slices.Contains([]string{"", "tcp", "http", "grpc", "udp"}, c.Type) {
+		return fmt.Errorf("not support health check type: %s", c.Type)
+	}
+	if c.Type == "http" && c.Path == "" {
+		return fmt.Errorf("health check path should not be empty")
+	}
+	if c.Type == "udp" {
+		if c.Send == "" && c.DNSQuery == "" {
+			return fmt.Errorf("health check send or dnsQuery should not be empty")
+		}
+		if c.DNSQuery != "" {
+			if _, err := dnsmessage.NewName(strings.TrimSuffix(c.DNSQuery, ".") + "."); err != nil {
+				return fmt.Errorf("invalid health check dnsQuery: %v", err)
+			}
+		}
+	}
+	for _, code := range c.ExpectedStatus {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("invalid health check expected status: %d", code)
+		}
+	}
+	if c.Expect != "" {
+		if _, err := regexp.Compile(c.Expect); err != nil {
+			return fmt.Errorf("invalid health check expect: %v", err)
+		}
+	}
+	if c.HealthyThreshold < 0 {
+		return fmt.Errorf("health check healthy threshold should not be negative")
+	}
+	return nil
+}
+
 func validateProxyBaseConfigForServer(c *v1.ProxyBaseConfig) error {
 	if err := ValidateAnnotations(c.Annotations); err != nil {
 		return err
 	}
+	if err := validateLoadBalancerConfig(&c.LoadBalancer); err != nil {
+		return err
+	}
+	if _, err := netpkg.NewIPFilter(c.AllowSourceIPs, c.DenySourceIPs); err != nil {
+		return fmt.Errorf("invalid source IPs: %v", err)
+	}
+	return validateConnectionLimits(&c.Transport)
+}
+
+func validateConnectionLimits(c *v1.ProxyTransport) error {
+	if c.MaxConnections < 0 || c.NewConnectionRate < 0 || c.NewConnectionRatePerIP < 0 {
+		return fmt.Errorf("connection limits should not be negative")
+	}
+	return nil
+}
+
+func validateLoadBalancerConfig(c *v1.LoadBalancerConfig) error {
+	if c.Strategy != "" && !slices.Contains(v1.LoadBalanceStrategies, c.Strategy) {
+		return fmt.Errorf("not support load balance strategy: %s", c.Strategy)
+	}
+	if c.Weight < 0 || c.Weight > v1.MaxLoadBalanceWeight {
+		return fmt.Errorf("load balance weight should be in the range 0..%d", v1.MaxLoadBalanceWeight)
+	}
+	if c.AffinityCookie != "" {
+		if err := (&http.Cookie{Name: c.AffinityCookie}).Valid(); err != nil {
+			return fmt.Errorf("invalid affinity cookie: %v", err)
+		}
+	}
 	return nil
 }
 
@@ -109,7 +191,7 @@ func ValidateProxyConfigurerForClient(c v1.ProxyConfigurer) error {
 	case *v1.TCPProxyConfig:
 		return nil
 	case *v1.UDPProxyConfig:
-		return nil
+		return validateUDPProxyConfigForClient(v)
 	case *v1.TCPMuxProxyConfig:
 		return validateTCPMuxProxyConfigForClient(v)
 	case *v1.HTTPProxyConfig:
@@ -119,11 +201,60 @@ func ValidateProxyConfigurerForClient(c v1.ProxyConfigurer) error {
 	case *v1.STCPProxyConfig:
 		return nil
 	case *v1.SUDPProxyConfig:
-		return nil
+		if err := validateUDPBackends(&v.ProxyBaseConfig); err != nil {
+			return err
+		}
+		return validateUDPHealthCheckConfig(&v.HealthCheck)
 	}
 	return errors.New("unknown proxy config type")
 }
 
+func validateUDPProxyConfigForClient(c *v1.UDPProxyConfig) error {
+	if c.Transport.ProxyProtocolVersion == "v1" {
+		return fmt.Errorf("proxy protocol v1 is not supported by udp proxies")
+	}
+	if err := validateUDPBackends(&c.ProxyBaseConfig); err != nil {
+		return err
+	}
+	return validateUDPHealthCheckConfig(&c.HealthCheck)
+}
+
+// validateUDPBackends rejects backends for udp and sudp proxies, their
+// packets are only forwarded to LocalIP and LocalPort.
+func validateUDPBackends(c *v1.ProxyBaseConfig) error {
+	if len(c.Backends) > 0 {
+		return fmt.Errorf("backends are not supported by udp proxies")
+	}
+	return nil
+}
+
+// validateUDPHealthCheckConfig rejects the health checks that need a stream
+// connection to the local service of udp and sudp proxies. The tcp and http
+// checks were accepted before udp checks existed, so they are only warned
+// about by ProxyConfigurerWarningForClient.
+func validateUDPHealthCheckConfig(c *v1.HealthCheckConfig) error {
+	if !slices.Contains([]string{"", "udp", "tcp", "http"}, c.Type) {
+		return fmt.Errorf("health check type %s is not supported by udp proxies", c.Type)
+	}
+	return nil
+}
+
+// ProxyConfigurerWarningForClient returns the problems of c which are accepted
+// for compatibility.
+func ProxyConfigurerWarningForClient(c v1.ProxyConfigurer) Warning {
+	var hc *v1.HealthCheckConfig
+	switch v := c.(type) {
+	case *v1.UDPProxyConfig:
+		hc = &v.HealthCheck
+	case *v1.SUDPProxyConfig:
+		hc = &v.HealthCheck
+	}
+	if hc != nil && (hc.Type == "tcp" || hc.Type == "http") {
+		return fmt.Errorf("health check type %s connects to the local service over tcp, use udp for udp proxies", hc.Type)
+	}
+	return nil
+}
+
 func validateTCPMuxProxyConfigForClient(c *v1.TCPMuxProxyConfig) error {
 	if err := validateDomainConfigForClient(&c.DomainConfig); err != nil {
 		return err
@@ -21,6 +21,7 @@ import (
 	"github.com/samber/lo"
 
 	v1 "github.com/fatedier/frp/pkg/config/v1"
+	netpkg "github.com/fatedier/frp/pkg/util/net"
 )
 
 func ValidateServerConfig(c *v1.ServerConfig) (Warning, error) {
@@ -41,11 +42,36 @@ func ValidateServerConfig(c *v1.ServerConfig) (Warning, error) {
 
 	errs = AppendError(errs, ValidatePort(c.BindPort, "bindPort"))
 	errs = AppendError(errs, ValidatePort(c.KCPBindPort, "kcpBindPort"))
+	errs = AppendError(errs, validateKCPOptions(&c.Transport.KCP, "transport.kcp"))
+	if c.Transport.MaxDrainTimeoutSeconds < 0 {
+		errs = AppendError(errs, fmt.Errorf("transport.maxDrainTimeoutSeconds should not be negative"))
+	}
 	errs = AppendError(errs, ValidatePort(c.QUICBindPort, "quicBindPort"))
 	errs = AppendError(errs, ValidatePort(c.VhostHTTPPort, "vhostHTTPPort"))
 	errs = AppendError(errs, ValidatePort(c.VhostHTTPSPort, "vhostHTTPSPort"))
 	errs = AppendError(errs, ValidatePort(c.TCPMuxHTTPConnectPort, "tcpMuxHTTPConnectPort"))
 
+	if _, err := netpkg.ParseCIDRs(c.ProxyProtocolTrustedCIDRs); err != nil {
+		errs = AppendError(errs, fmt.Errorf("invalid proxyProtocolTrustedCIDRs: %v", err))
+	}
+	if _, err := netpkg.NewIPFilter(c.ProxySourceIPs.Allow, c.ProxySourceIPs.Deny); err != nil {
+		errs = AppendError(errs, fmt.Errorf("invalid proxySourceIPs: %v", err))
+	}
+	quotaUsers := make(map[string]struct{})
+	for _, u := range c.TrafficQuota.Users {
+		if _, ok := quotaUsers[u.User]; ok {
+			errs = AppendError(errs, fmt.Errorf("duplicate traffic quota of user [%s]", u.User))
+		}
+		quotaUsers[u.User] = struct{}{}
+	}
+	bandwidthUsers := make(map[string]struct{})
+	for _, u := range c.BandwidthLimit.Users {
+		if _, ok := bandwidthUsers[u.User]; ok {
+			errs = AppendError(errs, fmt.Errorf("duplicate bandwidth limit of user [%s]", u.User))
+		}
+		bandwidthUsers[u.User] = struct{}{}
+	}
+
 	for _, p := range c.HTTPPlugins {
 		if !lo.Every(SupportedHTTPPluginOps, p.Ops) {
 			errs = AppendError(errs, fmt.Errorf("invalid http plugin ops, optional values are %v", SupportedHTTPPluginOps))
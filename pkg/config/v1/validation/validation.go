@@ -30,6 +30,12 @@ var (
 		"wss",
 	}
 
+	SupportedKCPModes = []string{
+		v1.KCPModeNormal,
+		v1.KCPModeFast,
+		v1.KCPModeTurbo,
+	}
+
 	SupportedAuthMethods = []v1.AuthMethod{
 		"token",
 		"oidc",
@@ -16,7 +16,12 @@ package validation
 
 import (
 	"errors"
+	"fmt"
+	"net"
+	"slices"
+
 	v1 "github.com/fatedier/frp/pkg/config/v1"
+	"github.com/fatedier/frp/pkg/util/passwd"
 )
 
 func ValidateVisitorConfigurer(c v1.VisitorConfigurer) error {
@@ -25,8 +30,11 @@ func ValidateVisitorConfigurer(c v1.VisitorConfigurer) error {
 		return err
 	}
 
-	switch c.(type) {
+	switch v := c.(type) {
 	case *v1.STCPVisitorConfig:
+		if err := validateSTCPVisitorConfig(v); err != nil {
+			return err
+		}
 	case *v1.SUDPVisitorConfig:
 	default:
 		return errors.New("unknown visitor config type")
@@ -46,5 +54,34 @@ func validateVisitorBaseConfig(c *v1.VisitorBaseConfig) error {
 	if c.BindPort == 0 {
 		return errors.New("bind port is required")
 	}
+
+	for user, password := range c.Users {
+		if err := passwd.Check(password); err != nil {
+			return fmt.Errorf("invalid password of user %q: %v", user, err)
+		}
+	}
+	return nil
+}
+
+func validateSTCPVisitorConfig(c *v1.STCPVisitorConfig) error {
+	if err := ValidatePort(c.DNS.BindPort, "dns.bindPort"); err != nil {
+		return err
+	}
+	if c.DNS.BindPort > 0 {
+		if _, _, err := net.SplitHostPort(c.DNS.Resolver); err != nil {
+			return fmt.Errorf("invalid dns.resolver %q: %v", c.DNS.Resolver, err)
+		}
+	}
+	if err := ValidatePort(c.Transparent.BindPort, "transparent.bindPort"); err != nil {
+		return err
+	}
+	if c.Transparent.BindPort > 0 && !slices.Contains([]string{"redirect", "tproxy"}, c.Transparent.Mode) {
+		return fmt.Errorf("invalid transparent.mode %q, optional values are redirect and tproxy", c.Transparent.Mode)
+	}
+	for _, cidr := range c.PAC.CIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid pac.cidrs %q: %v", cidr, err)
+		}
+	}
 	return nil
 }
@@ -43,7 +43,15 @@ type VisitorBaseConfig struct {
 	// other visitors. (This is not supported for SUDP now)
 	BindPort int `json:"bindPort,omitempty"`
 
+	// Users authenticates the socks and http proxy users of the visitor. The
+	// password can be plaintext or a bcrypt, argon2id or {SHA} hash, which can
+	// be generated by "frp passwd".
 	Users map[string]string `json:"users,omitempty"`
+	// UsersFile is an htpasswd file with more users, in addition to Users.
+	UsersFile string `json:"usersFile,omitempty"`
+	// ServerProfile is the name of the server profile the visitor connects
+	// through, "" means the main server.
+	ServerProfile string `json:"serverProfile,omitempty"`
 }
 
 func (c *VisitorBaseConfig) GetBaseConfig() *VisitorBaseConfig {
@@ -140,6 +148,58 @@ var _ VisitorConfigurer = &STCPVisitorConfig{}
 
 type STCPVisitorConfig struct {
 	VisitorBaseConfig
+
+	// DNS runs a local DNS server which resolves names through the tunnel.
+	DNS VisitorDNSConfig `json:"dns,omitempty"`
+	// PAC serves a proxy auto-config file on /proxy.pac of the http handler.
+	PAC VisitorPACConfig `json:"pac,omitempty"`
+	// Transparent accepts connections redirected by iptables or nftables on
+	// Linux and sends them to their original destination through the tunnel.
+	Transparent VisitorTransparentConfig `json:"transparent,omitempty"`
+}
+
+func (c *STCPVisitorConfig) Complete(g *ClientCommonConfig) {
+	c.VisitorBaseConfig.Complete(g)
+
+	if c.DNS.BindPort > 0 && c.DNS.Resolver == "" {
+		c.DNS.Resolver = "127.0.0.1:53"
+	}
+	if c.Transparent.BindPort > 0 && c.Transparent.Mode == "" {
+		c.Transparent.Mode = "redirect"
+	}
+}
+
+type VisitorDNSConfig struct {
+	// BindPort is the port that the DNS server listens on for both UDP and TCP,
+	// using the BindAddr of the visitor. 0 disables the DNS server.
+	BindPort int `json:"bindPort,omitempty"`
+	// Resolver is the address of the DNS resolver as seen from the frpc
+	// running the stcp proxy. By default, this value is "127.0.0.1:53".
+	Resolver string `json:"resolver,omitempty"`
+	// CacheSize is the maximum number of cached answers. 0 means the default
+	// size of 1024, a negative value disables the cache.
+	CacheSize int `json:"cacheSize,omitempty"`
+}
+
+type VisitorTransparentConfig struct {
+	// BindPort is the port at BindAddr that the redirected connections are
+	// sent to. 0 disables the transparent proxy.
+	BindPort int `json:"bindPort,omitempty"`
+	// Mode is "redirect" for REDIRECT rules or "tproxy" for TPROXY rules.
+	// By default, this value is "redirect".
+	Mode string `json:"mode,omitempty"`
+}
+
+type VisitorPACConfig struct {
+	// Domains are sent to the visitor together with their subdomains.
+	Domains []string `json:"domains,omitempty"`
+	// CIDRs are the IP ranges sent to the visitor, e.g. "10.0.0.0/8".
+	CIDRs []string `json:"cidrs,omitempty"`
+}
+
+// Enabled returns true if the PAC file should be served.
+func (c *VisitorPACConfig) Enabled() bool {
+	return len(c.Domains) > 0 || len(c.CIDRs) > 0
 }
 
 var _ VisitorConfigurer = &SUDPVisitorConfig{}
@@ -0,0 +1,175 @@
+package dnsproxy
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// DefaultCacheSize is the number of answers kept by NewCache when size is 0.
+const DefaultCacheSize = 1024
+
+// Cache is a small TTL based cache of DNS responses keyed by question.
+type Cache struct {
+	size    int
+	mu      sync.Mutex
+	entries map[cacheKey]*cacheEntry
+	now     func() time.Time
+}
+
+type cacheKey struct {
+	name  string
+	typ   dnsmessage.Type
+	class dnsmessage.Class
+}
+
+type cacheEntry struct {
+	resp    []byte
+	expires time.Time
+}
+
+// NewCache creates a cache holding at most size responses.
+// A size of 0 means DefaultCacheSize, a negative size returns nil (no cache).
+func NewCache(size int) *Cache {
+	if size < 0 {
+		return nil
+	}
+	if size == 0 {
+		size = DefaultCacheSize
+	}
+	return &Cache{
+		size:    size,
+		entries: make(map[cacheKey]*cacheEntry),
+		now:     time.Now,
+	}
+}
+
+func newCacheKey(q dnsmessage.Question) cacheKey {
+	return cacheKey{name: strings.ToLower(q.Name.String()), typ: q.Type, class: q.Class}
+}
+
+// Get returns a copy of the cached response for q with its ID set to id.
+func (c *Cache) Get(q dnsmessage.Question, id uint16) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	key := newCacheKey(q)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !c.now().Before(e.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	resp := make([]byte, len(e.resp))
+	copy(resp, e.resp)
+	resp[0], resp[1] = byte(id>>8), byte(id)
+	return resp, true
+}
+
+// Put stores resp for q if it is cacheable. Only successful and NXDOMAIN
+// responses with a positive TTL are kept.
+func (c *Cache) Put(q dnsmessage.Question, resp []byte) {
+	if c == nil {
+		return
+	}
+	ttl, ok := responseTTL(resp)
+	if !ok || ttl <= 0 {
+		return
+	}
+	key := newCacheKey(q)
+	now := c.now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exist := c.entries[key]; !exist && len(c.entries) >= c.size {
+		c.evictLocked(now)
+	}
+	c.entries[key] = &cacheEntry{
+		resp:    append([]byte(nil), resp...),
+		expires: now.Add(ttl),
+	}
+}
+
+// Len returns the number of cached responses, including expired ones not yet evicted.
+func (c *Cache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// evictLocked drops expired entries, or one arbitrary entry if none has expired.
+func (c *Cache) evictLocked(now time.Time) {
+	for k, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	if len(c.entries) < c.size {
+		return
+	}
+	for k := range c.entries {
+		delete(c.entries, k)
+		return
+	}
+}
+
+// responseTTL returns the minimum TTL of the answer records, or of the SOA
+// record in the authority section for negative answers.
+func responseTTL(resp []byte) (time.Duration, bool) {
+	var p dnsmessage.Parser
+	h, err := p.Start(resp)
+	if err != nil || h.Truncated {
+		return 0, false
+	}
+	if h.RCode != dnsmessage.RCodeSuccess && h.RCode != dnsmessage.RCodeNameError {
+		return 0, false
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return 0, false
+	}
+
+	var (
+		minTTL uint32
+		found  bool
+	)
+	answers, err := p.AllAnswers()
+	if err != nil {
+		return 0, false
+	}
+	for _, a := range answers {
+		if !found || a.Header.TTL < minTTL {
+			minTTL, found = a.Header.TTL, true
+		}
+	}
+	if !found {
+		authorities, err := p.AllAuthorities()
+		if err != nil {
+			return 0, false
+		}
+		for _, a := range authorities {
+			soa, ok := a.Body.(*dnsmessage.SOAResource)
+			if !ok {
+				continue
+			}
+			ttl := min(a.Header.TTL, soa.MinTTL)
+			if !found || ttl < minTTL {
+				minTTL, found = ttl, true
+			}
+		}
+	}
+	if !found {
+		return 0, false
+	}
+	return time.Duration(minTTL) * time.Second, true
+}
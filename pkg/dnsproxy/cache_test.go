@@ -0,0 +1,60 @@
+package dnsproxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func buildResponse(t *testing.T, id uint16, q dnsmessage.Question, ttl uint32) []byte {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, Response: true})
+	require.NoError(t, b.StartQuestions())
+	require.NoError(t, b.Question(q))
+	require.NoError(t, b.StartAnswers())
+	require.NoError(t, b.AResource(dnsmessage.ResourceHeader{
+		Name:  q.Name,
+		Class: dnsmessage.ClassINET,
+		TTL:   ttl,
+	}, dnsmessage.AResource{A: [4]byte{10, 0, 0, 1}}))
+	resp, err := b.Finish()
+	require.NoError(t, err)
+	return resp
+}
+
+func TestCache(t *testing.T) {
+	require := require.New(t)
+	now := time.Now()
+	c := NewCache(2)
+	c.now = func() time.Time { return now }
+
+	q := dnsmessage.Question{
+		Name:  dnsmessage.MustNewName("db.internal."),
+		Type:  dnsmessage.TypeA,
+		Class: dnsmessage.ClassINET,
+	}
+	c.Put(q, buildResponse(t, 1, q, 60))
+
+	upper := q
+	upper.Name = dnsmessage.MustNewName("DB.Internal.")
+	resp, ok := c.Get(upper, 0xabcd)
+	require.True(ok)
+	require.Equal([]byte{0xab, 0xcd}, resp[:2])
+
+	now = now.Add(time.Minute)
+	_, ok = c.Get(q, 1)
+	require.False(ok)
+
+	zero := dnsmessage.Question{Name: dnsmessage.MustNewName("zero."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}
+	c.Put(zero, buildResponse(t, 1, zero, 0))
+	require.Equal(0, c.Len())
+
+	for _, name := range []string{"a.", "b.", "c."} {
+		q := dnsmessage.Question{Name: dnsmessage.MustNewName(name), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}
+		c.Put(q, buildResponse(t, 1, q, 30))
+	}
+	require.Equal(2, c.Len())
+
+	require.Nil(NewCache(-1))
+}
@@ -0,0 +1,262 @@
+package dnsproxy
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// maxUDPSize is the payload size allowed for UDP clients without EDNS0.
+	maxUDPSize = 512
+	// maxMsgSize is the biggest message that can be framed over TCP.
+	maxMsgSize = 65535
+)
+
+// Server is a DNS forwarder that answers queries on UDP and TCP by sending
+// them over TCP to Upstream through ProxyDial.
+type Server struct {
+	// Upstream is the resolver address that ProxyDial connects to.
+	Upstream string
+	// ProxyDial specifies the dial function used to reach Upstream.
+	ProxyDial func(ctx context.Context, network, address string) (net.Conn, error)
+	// Cache is the optional response cache.
+	Cache *Cache
+	// Timeout bounds a single upstream exchange, 5 seconds by default.
+	Timeout time.Duration
+	// Logger error log
+	Logger Logger
+	// Context is default context
+	Context context.Context
+}
+
+// Logger is satisfied by *xlog.Logger.
+type Logger interface {
+	Warnf(format string, v ...any)
+}
+
+// ServeUDP answers every query read from conn until it is closed.
+func (s *Server) ServeUDP(conn net.PacketConn) error {
+	buf := make([]byte, maxMsgSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		query := append([]byte(nil), buf[:n]...)
+		go func() {
+			resp, err := s.exchange(query, true)
+			if err != nil {
+				s.logf("dns udp query from %s error: %v", addr, err)
+				return
+			}
+			if _, err := conn.WriteTo(resp, addr); err != nil {
+				s.logf("dns udp reply to %s error: %v", addr, err)
+			}
+		}()
+	}
+}
+
+// Serve accepts DNS over TCP connections from l.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn answers length prefixed queries on a single TCP connection.
+func (s *Server) ServeConn(conn net.Conn) {
+	defer conn.Close()
+	for {
+		query, err := readMsg(conn)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				s.logf("dns tcp read from %s error: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		resp, err := s.exchange(query, false)
+		if err != nil {
+			s.logf("dns tcp query from %s error: %v", conn.RemoteAddr(), err)
+			return
+		}
+		if err := writeMsg(conn, resp); err != nil {
+			return
+		}
+	}
+}
+
+// exchange answers query from the cache or upstream. Upstream failures are
+// reported to the client as SERVFAIL, only malformed queries return an error.
+func (s *Server) exchange(query []byte, udp bool) ([]byte, error) {
+	h, q, udpSize, err := parseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, ok := s.Cache.Get(q, h.ID)
+	if !ok {
+		resp, err = s.forward(query, h.ID)
+		if err != nil {
+			s.logf("dns forward %s %s error: %v", q.Name, q.Type, err)
+			return errorResponse(h, q, dnsmessage.RCodeServerFailure)
+		}
+		s.Cache.Put(q, resp)
+	}
+
+	if udp && len(resp) > udpSize {
+		return truncate(resp, q)
+	}
+	return resp, nil
+}
+
+func (s *Server) forward(query []byte, id uint16) ([]byte, error) {
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(s.context(), timeout)
+	defer cancel()
+
+	dial := s.ProxyDial
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	conn, err := dial(ctx, "tcp", s.Upstream)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	if err := writeMsg(conn, query); err != nil {
+		return nil, err
+	}
+	resp, err := readMsg(conn)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) < 2 || binary.BigEndian.Uint16(resp) != id {
+		return nil, fmt.Errorf("mismatched response id")
+	}
+	return resp, nil
+}
+
+func (s *Server) context() context.Context {
+	if s.Context == nil {
+		return context.Background()
+	}
+	return s.Context
+}
+
+func (s *Server) logf(format string, v ...any) {
+	if s.Logger != nil {
+		s.Logger.Warnf(format, v...)
+	}
+}
+
+func readMsg(r io.Reader) ([]byte, error) {
+	var l [2]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return nil, err
+	}
+	b := make([]byte, binary.BigEndian.Uint16(l[:]))
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func writeMsg(w io.Writer, b []byte) error {
+	if len(b) > maxMsgSize {
+		return fmt.Errorf("dns message too large: %d", len(b))
+	}
+	buf := make([]byte, 2+len(b))
+	binary.BigEndian.PutUint16(buf, uint16(len(b)))
+	copy(buf[2:], b)
+	_, err := w.Write(buf)
+	return err
+}
+
+// parseQuery returns the header, the first question and the UDP payload size
+// advertised by the client through EDNS0.
+func parseQuery(query []byte) (dnsmessage.Header, dnsmessage.Question, int, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(query)
+	if err != nil {
+		return h, dnsmessage.Question{}, 0, err
+	}
+	q, err := p.Question()
+	if err != nil {
+		return h, q, 0, err
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return h, q, 0, err
+	}
+	udpSize := maxUDPSize
+	if err := p.SkipAllAnswers(); err != nil {
+		return h, q, udpSize, nil
+	}
+	if err := p.SkipAllAuthorities(); err != nil {
+		return h, q, udpSize, nil
+	}
+	for {
+		rh, err := p.AdditionalHeader()
+		if err != nil {
+			break
+		}
+		if rh.Type == dnsmessage.TypeOPT && int(rh.Class) > udpSize {
+			udpSize = int(rh.Class)
+		}
+		if err := p.SkipAdditional(); err != nil {
+			break
+		}
+	}
+	return h, q, udpSize, nil
+}
+
+func errorResponse(h dnsmessage.Header, q dnsmessage.Question, rcode dnsmessage.RCode) ([]byte, error) {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:               h.ID,
+		Response:         true,
+		OpCode:           h.OpCode,
+		RecursionDesired: h.RecursionDesired,
+		RCode:            rcode,
+	})
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(q); err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+// truncate strips the records of resp and sets the TC bit, so that the
+// client retries the query over TCP.
+func truncate(resp []byte, q dnsmessage.Question) ([]byte, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(resp)
+	if err != nil {
+		return nil, err
+	}
+	h.Truncated = true
+	b := dnsmessage.NewBuilder(nil, h)
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(q); err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
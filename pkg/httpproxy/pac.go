@@ -0,0 +1,107 @@
+package httpproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// PACPath is the path that PAC serves the proxy auto-config file on.
+const PACPath = "/proxy.pac"
+
+// PAC generates a proxy auto-config file, which sends the hosts matching
+// Domains or CIDRs to the proxy and everything else DIRECT.
+type PAC struct {
+	// Domains matches the domain itself and all of its subdomains.
+	Domains []string
+	// CIDRs matches IP literal hosts within the ranges.
+	CIDRs []*net.IPNet
+	// NotFound handles the requests for other paths
+	NotFound http.Handler
+}
+
+// NewPAC creates a PAC from domain names and CIDR strings.
+func NewPAC(domains, cidrs []string) (*PAC, error) {
+	p := &PAC{}
+	for _, d := range domains {
+		d = strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(d), "*"), ".")
+		if d != "" {
+			p.Domains = append(p.Domains, d)
+		}
+	}
+	for _, c := range cidrs {
+		_, ipNet, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, err
+		}
+		p.CIDRs = append(p.CIDRs, ipNet)
+	}
+	return p, nil
+}
+
+// Script returns the PAC file content pointing matching hosts at proxyAddr.
+func (p *PAC) Script(proxyAddr string) string {
+	var (
+		nets4 [][2]string
+		nets6 []string
+	)
+	for _, n := range p.CIDRs {
+		if ip4 := n.IP.To4(); ip4 != nil {
+			nets4 = append(nets4, [2]string{ip4.String(), net.IP(n.Mask).String()})
+		} else {
+			nets6 = append(nets6, n.String())
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("function FindProxyForURL(url, host) {\n")
+	fmt.Fprintf(&b, "  var proxy = %s;\n", jsValue("PROXY "+proxyAddr))
+	fmt.Fprintf(&b, "  var domains = %s;\n", jsValue(p.Domains))
+	b.WriteString("  host = host.toLowerCase();\n")
+	b.WriteString("  for (var i = 0; i < domains.length; i++) {\n")
+	b.WriteString("    if (host == domains[i] || dnsDomainIs(host, \".\" + domains[i])) return proxy;\n")
+	b.WriteString("  }\n")
+	fmt.Fprintf(&b, "  var nets = %s;\n", jsValue(nets4))
+	b.WriteString("  if (/^\\d+\\.\\d+\\.\\d+\\.\\d+$/.test(host)) {\n")
+	b.WriteString("    for (var i = 0; i < nets.length; i++) {\n")
+	b.WriteString("      if (isInNet(host, nets[i][0], nets[i][1])) return proxy;\n")
+	b.WriteString("    }\n")
+	b.WriteString("  }\n")
+	if len(nets6) > 0 {
+		fmt.Fprintf(&b, "  var nets6 = %s;\n", jsValue(nets6))
+		b.WriteString("  if (host.indexOf(\":\") >= 0 && typeof isInNetEx == \"function\") {\n")
+		b.WriteString("    for (var i = 0; i < nets6.length; i++) {\n")
+		b.WriteString("      if (isInNetEx(host, nets6[i])) return proxy;\n")
+		b.WriteString("    }\n")
+		b.WriteString("  }\n")
+	}
+	b.WriteString("  return \"DIRECT\";\n")
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// ServeHTTP serves the PAC file on PACPath, the proxy address is taken from
+// the Host the file was requested with.
+func (p *PAC) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != PACPath {
+		handle := p.NotFound
+		if handle == nil {
+			handle = http.HandlerFunc(http.NotFound)
+		}
+		handle.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ns-proxy-autoconfig")
+	_, _ = w.Write([]byte(p.Script(r.Host)))
+}
+
+// jsValue encodes v as a JavaScript literal, nil slices become empty arrays.
+func jsValue(v any) string {
+	b, _ := json.Marshal(v)
+	if string(b) == "null" {
+		return "[]"
+	}
+	return string(b)
+}
@@ -0,0 +1,35 @@
+package httpproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPAC(t *testing.T) {
+	require := require.New(t)
+	pac, err := NewPAC([]string{"*.Corp.example.com", "internal"}, []string{"10.0.0.0/8", "fd00::/8"})
+	require.NoError(err)
+	require.Equal([]string{"corp.example.com", "internal"}, pac.Domains)
+
+	script := pac.Script("127.0.0.1:9000")
+	require.Contains(script, `var proxy = "PROXY 127.0.0.1:9000";`)
+	require.Contains(script, `var domains = ["corp.example.com","internal"];`)
+	require.Contains(script, `var nets = [["10.0.0.0","255.0.0.0"]];`)
+	require.Contains(script, `var nets6 = ["fd00::/8"];`)
+
+	w := httptest.NewRecorder()
+	pac.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://visitor:9000/proxy.pac", nil))
+	require.Equal(http.StatusOK, w.Code)
+	require.Equal("application/x-ns-proxy-autoconfig", w.Header().Get("Content-Type"))
+	require.Contains(w.Body.String(), `"PROXY visitor:9000"`)
+
+	w = httptest.NewRecorder()
+	pac.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://visitor:9000/other", nil))
+	require.Equal(http.StatusNotFound, w.Code)
+
+	_, err = NewPAC(nil, []string{"10.0.0.1"})
+	require.Error(err)
+}
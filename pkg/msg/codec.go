@@ -0,0 +1,436 @@
+package msg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// CodecBinary is a compact binary encoding of the messages. Messages in either
+// encoding are always readable, FeatureBinaryCodec negotiated at login only
+// selects how they are written to the peer.
+const CodecBinary = "binary"
+
+// A message in the binary encoding is framed as its type byte with the high
+// bit set, the uvarint length of the body and the body.
+const (
+	binaryTypeFlag   byte = 0x80
+	maxBinaryMsgSize      = 10240
+)
+
+// Values are encoded as a tag followed by the content. Struct fields are keyed
+// by the FNV-1a hash of their JSON name, so fields unknown to the reader are
+// skipped like in JSON. Zero values are omitted.
+const (
+	tagFalse  byte = iota
+	tagTrue        // no content
+	tagInt         // zigzag varint
+	tagUint        // uvarint
+	tagBytes       // uvarint length, bytes
+	tagList        // uvarint count, values
+	tagMap         // uvarint count, key and value pairs
+	tagStruct      // uvarint count, 4-byte field key and value pairs
+)
+
+var (
+	msgTypes     = make(map[byte]reflect.Type)
+	msgTypeBytes = make(map[reflect.Type]byte)
+)
+
+func init() {
+	for typeByte, m := range msgTypeMap {
+		msgTypes[typeByte] = reflect.TypeOf(m)
+		msgTypeBytes[reflect.TypeOf(m)] = typeByte
+	}
+}
+
+// WriteMsgWithCodec writes msg in the encoding of codec, JSON if it is empty.
+func WriteMsgWithCodec(c io.Writer, msg any, codec string) error {
+	if codec != CodecBinary {
+		return WriteMsg(c, msg)
+	}
+	v := reflect.ValueOf(msg)
+	if v.Kind() != reflect.Pointer {
+		return fmt.Errorf("message %T is not a pointer", msg)
+	}
+	typeByte, ok := msgTypeBytes[v.Type().Elem()]
+	if !ok {
+		return fmt.Errorf("unknown message type %T", msg)
+	}
+	body, err := appendValue(nil, v.Elem())
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 0, 1+binary.MaxVarintLen64+len(body))
+	buf = append(buf, typeByte|binaryTypeFlag)
+	buf = binary.AppendUvarint(buf, uint64(len(body)))
+	_, err = c.Write(append(buf, body...))
+	return err
+}
+
+// readMsg reads a message in either encoding, into msgIn if it isn't nil.
+func readMsg(c io.Reader, msgIn Message) (Message, error) {
+	var typeByte [1]byte
+	if _, err := io.ReadFull(c, typeByte[:]); err != nil {
+		return nil, err
+	}
+	if typeByte[0]&binaryTypeFlag == 0 {
+		r := io.MultiReader(bytes.NewReader(typeByte[:]), c)
+		if msgIn != nil {
+			return msgIn, msgCtl.ReadMsgInto(r, msgIn)
+		}
+		return msgCtl.ReadMsg(r)
+	}
+
+	t, ok := msgTypes[typeByte[0]&^binaryTypeFlag]
+	if !ok {
+		return nil, fmt.Errorf("unknown message type %d", typeByte[0]&^binaryTypeFlag)
+	}
+	length, err := binary.ReadUvarint(byteReader{c})
+	if err != nil {
+		return nil, err
+	}
+	if length > maxBinaryMsgSize {
+		return nil, fmt.Errorf("message length %d exceeds the limit", length)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c, body); err != nil {
+		return nil, err
+	}
+
+	m := msgIn
+	if m == nil {
+		m = reflect.New(t).Interface()
+	}
+	v := reflect.ValueOf(m)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return nil, fmt.Errorf("message %T is not a pointer", m)
+	}
+	r := bytes.NewReader(body)
+	if err := decodeValue(r, v.Elem()); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// byteReader reads single bytes without buffering, so nothing after the
+// length is consumed.
+type byteReader struct {
+	io.Reader
+}
+
+func (r byteReader) ReadByte() (byte, error) {
+	var b [1]byte
+	_, err := io.ReadFull(r.Reader, b[:])
+	return b[0], err
+}
+
+type structField struct {
+	index int
+	key   uint32
+}
+
+type structInfo struct {
+	fields []structField
+	byKey  map[uint32]int
+}
+
+var structInfos sync.Map
+
+func getStructInfo(t reflect.Type) *structInfo {
+	if info, ok := structInfos.Load(t); ok {
+		return info.(*structInfo)
+	}
+	info := &structInfo{byKey: make(map[uint32]int)}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(name))
+		key := h.Sum32()
+		if _, ok := info.byKey[key]; ok {
+			panic(fmt.Sprintf("msg: field key of %s.%s collides with another field", t.Name(), f.Name))
+		}
+		info.byKey[key] = i
+		info.fields = append(info.fields, structField{index: i, key: key})
+	}
+	structInfos.Store(t, info)
+	return info
+}
+
+func appendValue(b []byte, v reflect.Value) ([]byte, error) {
+	var err error
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return append(b, tagTrue), nil
+		}
+		return append(b, tagFalse), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return binary.AppendVarint(append(b, tagInt), v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return binary.AppendUvarint(append(b, tagUint), v.Uint()), nil
+	case reflect.String:
+		b = binary.AppendUvarint(append(b, tagBytes), uint64(v.Len()))
+		return append(b, v.String()...), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b = binary.AppendUvarint(append(b, tagBytes), uint64(v.Len()))
+			return append(b, v.Bytes()...), nil
+		}
+		b = binary.AppendUvarint(append(b, tagList), uint64(v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			if b, err = appendValue(b, v.Index(i)); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case reflect.Map:
+		b = binary.AppendUvarint(append(b, tagMap), uint64(v.Len()))
+		iter := v.MapRange()
+		for iter.Next() {
+			if b, err = appendValue(b, iter.Key()); err != nil {
+				return nil, err
+			}
+			if b, err = appendValue(b, iter.Value()); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case reflect.Pointer:
+		return appendValue(b, v.Elem())
+	case reflect.Struct:
+		info := getStructInfo(v.Type())
+		var count uint64
+		var fields []byte
+		for _, f := range info.fields {
+			fv := v.Field(f.index)
+			if fv.IsZero() {
+				continue
+			}
+			fields = binary.BigEndian.AppendUint32(fields, f.key)
+			if fields, err = appendValue(fields, fv); err != nil {
+				return nil, err
+			}
+			count++
+		}
+		b = binary.AppendUvarint(append(b, tagStruct), count)
+		return append(b, fields...), nil
+	default:
+		return nil, fmt.Errorf("unsupported kind %s in binary message", v.Kind())
+	}
+}
+
+var errTagMismatch = errors.New("binary message value doesn't match the field type")
+
+func decodeValue(r *bytes.Reader, v reflect.Value) error {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if tag != tagFalse && tag != tagTrue {
+			return errTagMismatch
+		}
+		v.SetBool(tag == tagTrue)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if tag != tagInt {
+			return errTagMismatch
+		}
+		n, err := binary.ReadVarint(r)
+		if err != nil {
+			return err
+		}
+		if v.OverflowInt(n) {
+			return fmt.Errorf("binary message value %d overflows %s", n, v.Type())
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if tag != tagUint {
+			return errTagMismatch
+		}
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return err
+		}
+		if v.OverflowUint(n) {
+			return fmt.Errorf("binary message value %d overflows %s", n, v.Type())
+		}
+		v.SetUint(n)
+	case reflect.String:
+		if tag != tagBytes {
+			return errTagMismatch
+		}
+		b, err := readBytes(r)
+		if err != nil {
+			return err
+		}
+		v.SetString(string(b))
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if tag != tagBytes {
+				return errTagMismatch
+			}
+			b, err := readBytes(r)
+			if err != nil {
+				return err
+			}
+			v.SetBytes(b)
+			return nil
+		}
+		if tag != tagList {
+			return errTagMismatch
+		}
+		n, err := readCount(r)
+		if err != nil {
+			return err
+		}
+		s := reflect.MakeSlice(v.Type(), n, n)
+		for i := 0; i < n; i++ {
+			if err := decodeValue(r, s.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+	case reflect.Map:
+		if tag != tagMap {
+			return errTagMismatch
+		}
+		n, err := readCount(r)
+		if err != nil {
+			return err
+		}
+		m := reflect.MakeMapWithSize(v.Type(), n)
+		for i := 0; i < n; i++ {
+			key := reflect.New(v.Type().Key()).Elem()
+			if err := decodeValue(r, key); err != nil {
+				return err
+			}
+			value := reflect.New(v.Type().Elem()).Elem()
+			if err := decodeValue(r, value); err != nil {
+				return err
+			}
+			m.SetMapIndex(key, value)
+		}
+		v.Set(m)
+	case reflect.Struct:
+		if tag != tagStruct {
+			return errTagMismatch
+		}
+		n, err := readCount(r)
+		if err != nil {
+			return err
+		}
+		info := getStructInfo(v.Type())
+		for i := 0; i < n; i++ {
+			var key [4]byte
+			if _, err := io.ReadFull(r, key[:]); err != nil {
+				return err
+			}
+			index, ok := info.byKey[binary.BigEndian.Uint32(key[:])]
+			if !ok {
+				err = skipValue(r)
+			} else {
+				err = decodeValue(r, v.Field(index))
+			}
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported kind %s in binary message", v.Kind())
+	}
+	return nil
+}
+
+// skipValue skips a value of a field unknown to the reader.
+func skipValue(r *bytes.Reader) error {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	switch tag {
+	case tagFalse, tagTrue:
+		return nil
+	case tagInt:
+		_, err = binary.ReadVarint(r)
+	case tagUint:
+		_, err = binary.ReadUvarint(r)
+	case tagBytes:
+		_, err = readBytes(r)
+	case tagList, tagMap:
+		var n int
+		if n, err = readCount(r); err != nil {
+			return err
+		}
+		if tag == tagMap {
+			n *= 2
+		}
+		for i := 0; i < n && err == nil; i++ {
+			err = skipValue(r)
+		}
+	case tagStruct:
+		var n int
+		if n, err = readCount(r); err != nil {
+			return err
+		}
+		for i := 0; i < n && err == nil; i++ {
+			if _, err = r.Seek(4, io.SeekCurrent); err == nil {
+				err = skipValue(r)
+			}
+		}
+	default:
+		err = fmt.Errorf("unknown binary message tag %d", tag)
+	}
+	return err
+}
+
+// readCount reads the number of elements of a list, map or struct, which
+// can't be more than the bytes left.
+func readCount(r *bytes.Reader) (int, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(r.Len()) || n > math.MaxInt32 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return int(n), nil
+}
+
+func readBytes(r *bytes.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(r, b)
+	return b, err
+}
@@ -0,0 +1,115 @@
+package msg
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBinaryCodecRoundTrip(t *testing.T) {
+	require := require.New(t)
+	msgs := []Message{
+		&Login{
+			Version:    "0.61.0",
+			Timestamp:  -1700000000,
+			Metas:      map[string]string{"a": "1", "b": ""},
+			ClientSpec: ClientSpec{Type: "ssh-tunnel", AlwaysAuthPass: true},
+			PoolCount:  5,
+			Features:   SupportedFeatures(),
+		},
+		&NewProxy{
+			ProxyName:      "udp",
+			ProxyType:      "udp",
+			UseEncryption:  true,
+			AllowSourceIPs: []string{"10.0.0.0/8", "::1"},
+			Headers:        map[string]string{"X-From": "frp"},
+			RemotePort:     6000,
+			UDPDatagram:    true,
+		},
+		&StartWorkConn{ProxyName: "udp", SrcAddr: "1.2.3.4", SrcPort: 65535, UDPDatagramID: 1 << 31},
+		&ReqWorkConn{},
+		&Ping{},
+		&UDPPacket{
+			Content:    "aGVsbG8=",
+			LocalAddr:  &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1).To4(), Port: 53},
+			RemoteAddr: &net.UDPAddr{IP: net.ParseIP("::1"), Port: 5353, Zone: "lo"},
+		},
+	}
+	for _, m := range msgs {
+		buf := &bytes.Buffer{}
+		require.NoError(WriteMsgWithCodec(buf, m, CodecBinary))
+		require.NotZero(buf.Bytes()[0] & binaryTypeFlag)
+
+		out, err := ReadMsg(buf)
+		require.NoError(err)
+		require.Equal(m, out)
+		require.Zero(buf.Len())
+	}
+}
+
+func TestBinaryCodecAllTypes(t *testing.T) {
+	require := require.New(t)
+	for typeByte, m := range msgTypeMap {
+		require.Zero(typeByte&binaryTypeFlag, "type %c", typeByte)
+		in := reflect.New(reflect.TypeOf(m)).Interface()
+		buf := &bytes.Buffer{}
+		require.NoError(WriteMsgWithCodec(buf, in, CodecBinary))
+		out, err := ReadMsg(buf)
+		require.NoError(err)
+		require.Equal(in, out)
+	}
+}
+
+func TestReadMsgDetectsCodec(t *testing.T) {
+	require := require.New(t)
+	buf := &bytes.Buffer{}
+	require.NoError(WriteMsgWithCodec(buf, &Pong{Error: "json"}, ""))
+	require.NoError(WriteMsgWithCodec(buf, &Pong{Error: "binary"}, CodecBinary))
+	require.NoError(WriteMsg(buf, &NewWorkConn{RunID: "json"}))
+
+	m, err := ReadMsg(buf)
+	require.NoError(err)
+	require.Equal(&Pong{Error: "json"}, m)
+	m, err = ReadMsg(buf)
+	require.NoError(err)
+	require.Equal(&Pong{Error: "binary"}, m)
+
+	var workConn NewWorkConn
+	require.NoError(ReadMsgInto(buf, &workConn))
+	require.Equal("json", workConn.RunID)
+}
+
+func TestBinaryCodecSkipsUnknownFields(t *testing.T) {
+	require := require.New(t)
+	type newerLoginResp struct {
+		Version string         `json:"version,omitempty"`
+		Tags    []string       `json:"tags,omitempty"`
+		Limits  map[string]int `json:"limits,omitempty"`
+		Nested  struct {
+			On  bool  `json:"on,omitempty"`
+			Num int64 `json:"num,omitempty"`
+		} `json:"nested,omitempty"`
+		RunID string `json:"run_id,omitempty"`
+	}
+	in := newerLoginResp{
+		Version: "1.0.0",
+		Tags:    []string{"a", "b"},
+		Limits:  map[string]int{"x": -3},
+		RunID:   "run",
+	}
+	in.Nested.On = true
+	in.Nested.Num = 42
+	body, err := appendValue(nil, reflect.ValueOf(in))
+	require.NoError(err)
+
+	buf := &bytes.Buffer{}
+	buf.WriteByte(TypeLoginResp | binaryTypeFlag)
+	buf.WriteByte(byte(len(body)))
+	buf.Write(body)
+	m, err := ReadMsg(buf)
+	require.NoError(err)
+	require.Equal(&LoginResp{Version: "1.0.0", RunID: "run"}, m)
+}
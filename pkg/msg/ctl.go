@@ -41,7 +41,7 @@ func ReadMsgTimeout(conn net.Conn, timeout time.Duration) (msg Message, err erro
 }
 
 func ReadMsg(c io.Reader) (msg Message, err error) {
-	return msgCtl.ReadMsg(c)
+	return readMsg(c, nil)
 }
 
 func ReadMsgIntoTimeout(conn net.Conn, msg Message, timeout time.Duration) error {
@@ -54,7 +54,8 @@ func ReadMsgIntoTimeout(conn net.Conn, msg Message, timeout time.Duration) error
 }
 
 func ReadMsgInto(c io.Reader, msg Message) (err error) {
-	return msgCtl.ReadMsgInto(c, msg)
+	_, err = readMsg(c, msg)
+	return
 }
 
 func WriteMsg(c io.Writer, msg interface{}) (err error) {
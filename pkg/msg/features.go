@@ -0,0 +1,98 @@
+package msg
+
+import (
+	"context"
+	"slices"
+)
+
+// Features of the protocol announced in Login and LoginResp. frpc sends the
+// features it supports and frps replies with the ones both sides support, so
+// code branches on the negotiated features instead of on versions.
+const (
+	// FeatureBinaryCodec means messages are written in the binary codec.
+	FeatureBinaryCodec = "codec.binary"
+	// FeatureUDPBinaryFraming means UDP packets on work connections may use
+	// the binary framing.
+	FeatureUDPBinaryFraming = "udp.framing.binary"
+	// FeatureUDPDatagram means UDP packets may be sent as QUIC datagrams.
+	FeatureUDPDatagram = "udp.datagram"
+	// FeatureProxyDrain means the DrainTimeout of CloseProxy is honored.
+	FeatureProxyDrain = "proxy.drain"
+	// FeatureTargetDialReply means a TargetDialResp is sent for the
+	// StartWorkConn with TargetDialReply set.
+	FeatureTargetDialReply = "target.dial_reply"
+)
+
+var supportedFeatures = []string{
+	FeatureBinaryCodec,
+	FeatureUDPBinaryFraming,
+	FeatureUDPDatagram,
+	FeatureProxyDrain,
+	FeatureTargetDialReply,
+}
+
+// SupportedFeatures returns the features supported by this build.
+func SupportedFeatures() []string {
+	return slices.Clone(supportedFeatures)
+}
+
+// Features is a set of negotiated features. The zero value is an empty set,
+// which is what a peer predating feature negotiation supports.
+type Features map[string]struct{}
+
+func NewFeatures(names ...string) Features {
+	f := make(Features, len(names))
+	for _, name := range names {
+		f[name] = struct{}{}
+	}
+	return f
+}
+
+// NegotiateFeatures returns the features in both local and peer.
+func NegotiateFeatures(local, peer []string) Features {
+	f := make(Features)
+	for _, name := range peer {
+		if slices.Contains(local, name) {
+			f[name] = struct{}{}
+		}
+	}
+	return f
+}
+
+func (f Features) Has(name string) bool {
+	_, ok := f[name]
+	return ok
+}
+
+// List returns the features sorted by name.
+func (f Features) List() []string {
+	names := make([]string, 0, len(f))
+	for name := range f {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// MsgCodec returns the codec used to write messages to the peer.
+func (f Features) MsgCodec() string {
+	if f.Has(FeatureBinaryCodec) {
+		return CodecBinary
+	}
+	return ""
+}
+
+type featuresKey struct{}
+
+// NewFeaturesContext returns a context carrying the features negotiated with
+// the peer.
+func NewFeaturesContext(ctx context.Context, f Features) context.Context {
+	return context.WithValue(ctx, featuresKey{}, f)
+}
+
+// FeaturesFromContext returns the features in ctx, an empty set if there are
+// none.
+func FeaturesFromContext(ctx context.Context) Features {
+	f, _ := ctx.Value(featuresKey{}).(Features)
+	return f
+}
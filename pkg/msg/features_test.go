@@ -0,0 +1,33 @@
+package msg
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateFeatures(t *testing.T) {
+	require := require.New(t)
+	f := NegotiateFeatures(SupportedFeatures(), []string{FeatureUDPDatagram, FeatureBinaryCodec, "future.feature"})
+	require.Equal([]string{FeatureBinaryCodec, FeatureUDPDatagram}, f.List())
+	require.True(f.Has(FeatureUDPDatagram))
+	require.False(f.Has(FeatureProxyDrain))
+	require.False(f.Has("future.feature"))
+	require.Equal(CodecBinary, f.MsgCodec())
+
+	// a peer predating feature negotiation
+	f = NegotiateFeatures(SupportedFeatures(), nil)
+	require.Empty(f.List())
+	require.Equal("", f.MsgCodec())
+}
+
+func TestFeaturesContext(t *testing.T) {
+	require := require.New(t)
+	f := FeaturesFromContext(context.Background())
+	require.False(f.Has(FeatureBinaryCodec))
+	require.Equal("", f.MsgCodec())
+
+	ctx := NewFeaturesContext(context.Background(), NewFeatures(FeatureProxyDrain))
+	require.True(FeaturesFromContext(ctx).Has(FeatureProxyDrain))
+}
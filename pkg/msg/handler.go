@@ -27,7 +27,8 @@ func AsyncHandler(f func(Message)) func(Message) {
 
 // Dispatcher is used to send messages to net.Conn or register handlers for messages read from net.Conn.
 type Dispatcher struct {
-	rw io.ReadWriter
+	rw    io.ReadWriter
+	codec string
 
 	sendCh         chan Message
 	doneCh         chan struct{}
@@ -44,6 +45,11 @@ func NewDispatcher(rw io.ReadWriter) *Dispatcher {
 	}
 }
 
+// SetCodec sets the codec of the messages sent, it must be called before Run.
+func (d *Dispatcher) SetCodec(codec string) {
+	d.codec = codec
+}
+
 // Run will block until io.EOF or some error occurs.
 func (d *Dispatcher) Run() {
 	go d.sendLoop()
@@ -56,7 +62,7 @@ func (d *Dispatcher) sendLoop() {
 		case <-d.doneCh:
 			return
 		case m := <-d.sendCh:
-			_ = WriteMsg(d.rw, m)
+			_ = WriteMsgWithCodec(d.rw, m, d.codec)
 		}
 	}
 }
@@ -32,6 +32,7 @@ const (
 	TypePing               = 'h'
 	TypePong               = '4'
 	TypeUDPPacket          = 'u'
+	TypeTargetDialResp     = 'd'
 )
 
 var msgTypeMap = map[byte]interface{}{
@@ -48,6 +49,7 @@ var msgTypeMap = map[byte]interface{}{
 	TypePing:               Ping{},
 	TypePong:               Pong{},
 	TypeUDPPacket:          UDPPacket{},
+	TypeTargetDialResp:     TargetDialResp{},
 }
 
 type ClientSpec struct {
@@ -76,26 +78,47 @@ type Login struct {
 
 	// Some global configures.
 	PoolCount int `json:"pool_count,omitempty"`
+
+	// Features supported by frpc.
+	Features []string `json:"features,omitempty"`
 }
 
 type LoginResp struct {
 	Version string `json:"version,omitempty"`
 	RunID   string `json:"run_id,omitempty"`
 	Error   string `json:"error,omitempty"`
+	// Features supported by both frps and frpc.
+	Features []string `json:"features,omitempty"`
 }
 
 // When frpc login success, send this message to frps for running a new proxy.
 type NewProxy struct {
-	ProxyName          string            `json:"proxy_name,omitempty"`
-	ProxyType          string            `json:"proxy_type,omitempty"`
-	UseEncryption      bool              `json:"use_encryption,omitempty"`
-	UseCompression     bool              `json:"use_compression,omitempty"`
-	BandwidthLimit     string            `json:"bandwidth_limit,omitempty"`
-	BandwidthLimitMode string            `json:"bandwidth_limit_mode,omitempty"`
-	Group              string            `json:"group,omitempty"`
-	GroupKey           string            `json:"group_key,omitempty"`
-	Metas              map[string]string `json:"metas,omitempty"`
-	Annotations        map[string]string `json:"annotations,omitempty"`
+	ProxyName           string            `json:"proxy_name,omitempty"`
+	ProxyType           string            `json:"proxy_type,omitempty"`
+	UseEncryption       bool              `json:"use_encryption,omitempty"`
+	UseCompression      bool              `json:"use_compression,omitempty"`
+	BandwidthLimit      string            `json:"bandwidth_limit,omitempty"`
+	BandwidthLimitMode  string            `json:"bandwidth_limit_mode,omitempty"`
+	BandwidthLimitUp    string            `json:"bandwidth_limit_up,omitempty"`
+	BandwidthLimitDown  string            `json:"bandwidth_limit_down,omitempty"`
+	BandwidthLimitBurst string            `json:"bandwidth_limit_burst,omitempty"`
+	Group               string            `json:"group,omitempty"`
+	GroupKey            string            `json:"group_key,omitempty"`
+	GroupStrategy       string            `json:"group_strategy,omitempty"`
+	GroupWeight         int               `json:"group_weight,omitempty"`
+	GroupAffinityCookie string            `json:"group_affinity_cookie,omitempty"`
+	Metas               map[string]string `json:"metas,omitempty"`
+	Annotations         map[string]string `json:"annotations,omitempty"`
+	AllowSourceIPs      []string          `json:"allow_source_ips,omitempty"`
+	DenySourceIPs       []string          `json:"deny_source_ips,omitempty"`
+
+	MaxConnections         int `json:"max_connections,omitempty"`
+	NewConnectionRate      int `json:"new_connection_rate,omitempty"`
+	NewConnectionRatePerIP int `json:"new_connection_rate_per_ip,omitempty"`
+
+	TrafficQuotaDaily            string `json:"traffic_quota_daily,omitempty"`
+	TrafficQuotaMonthly          string `json:"traffic_quota_monthly,omitempty"`
+	TrafficQuotaCloseConnections bool   `json:"traffic_quota_close_connections,omitempty"`
 
 	// tcp and udp only
 	RemotePort int `json:"remote_port,omitempty"`
@@ -117,6 +140,12 @@ type NewProxy struct {
 
 	// tcpmux
 	Multiplexer string `json:"multiplexer,omitempty"`
+
+	// udp and sudp only, the framing of UDP packets frpc supports on work
+	// connections besides JSON messages.
+	UDPFraming string `json:"udp_framing,omitempty"`
+	// UDPDatagram is true if frpc can exchange UDP packets as QUIC datagrams.
+	UDPDatagram bool `json:"udp_datagram,omitempty"`
 }
 
 type NewProxyResp struct {
@@ -127,6 +156,10 @@ type NewProxyResp struct {
 
 type CloseProxy struct {
 	ProxyName string `json:"proxy_name,omitempty"`
+	// DrainTimeout is the number of seconds the work connections in use may
+	// keep running after the proxy is closed, 0 lets them run until they
+	// finish.
+	DrainTimeout int64 `json:"drain_timeout,omitempty"`
 }
 
 type NewWorkConn struct {
@@ -145,7 +178,20 @@ type StartWorkConn struct {
 	DstPort   uint16 `json:"dst_port,omitempty"`
 	Error     string `json:"error,omitempty"`
 
-	TargetAddr string `json:"target_addr,omitempty"`
+	TargetAddr        string `json:"target_addr,omitempty"`
+	TargetNetwork     string `json:"target_network,omitempty"`
+	TargetDialTimeout int64  `json:"target_dial_timeout,omitempty"`
+	TargetDialReply   bool   `json:"target_dial_reply,omitempty"`
+
+	// VisitorUser is the frp user of the visitor for stcp proxies.
+	VisitorUser string `json:"visitor_user,omitempty"`
+
+	// UDPFraming is the framing of UDP packets on the work connection of udp
+	// and sudp proxies, empty for JSON messages.
+	UDPFraming string `json:"udp_framing,omitempty"`
+	// UDPDatagramID is the flow of QUIC datagrams carrying the UDP packets of
+	// the work connection, 0 if they are sent on the work connection.
+	UDPDatagramID uint32 `json:"udp_datagram_id,omitempty"`
 }
 
 type NewVisitorConn struct {
@@ -156,12 +202,38 @@ type NewVisitorConn struct {
 	UseEncryption  bool   `json:"use_encryption,omitempty"`
 	UseCompression bool   `json:"use_compression,omitempty"`
 
-	TargetAddr string `json:"target_addr,omitempty"`
+	// TargetDialTimeout is in milliseconds. If TargetDialReply is true, frpc
+	// sends a TargetDialResp through the work connection after dialing.
+	TargetAddr        string `json:"target_addr,omitempty"`
+	TargetNetwork     string `json:"target_network,omitempty"`
+	TargetDialTimeout int64  `json:"target_dial_timeout,omitempty"`
+	TargetDialReply   bool   `json:"target_dial_reply,omitempty"`
+
+	// UDPFraming is the framing of UDP packets the sudp visitor prefers.
+	UDPFraming string `json:"udp_framing,omitempty"`
+	// UDPDatagram is true if the sudp visitor can exchange UDP packets as QUIC
+	// datagrams.
+	UDPDatagram bool `json:"udp_datagram,omitempty"`
 }
 
 type NewVisitorConnResp struct {
 	ProxyName string `json:"proxy_name,omitempty"`
 	Error     string `json:"error,omitempty"`
+	// UDPFraming is the framing of UDP packets on the visitor connection,
+	// empty for JSON messages.
+	UDPFraming string `json:"udp_framing,omitempty"`
+	// UDPDatagramID is the flow of QUIC datagrams carrying the UDP packets of
+	// the visitor connection, 0 if they are sent on the connection.
+	UDPDatagramID uint32 `json:"udp_datagram_id,omitempty"`
+	// TargetDialReply is true if a TargetDialResp follows on the connection,
+	// which requires the frpc of the proxy to support it.
+	TargetDialReply bool `json:"target_dial_reply,omitempty"`
+}
+
+// TargetDialResp is the result of dialing the target of a visitor connection.
+type TargetDialResp struct {
+	Status int    `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
 }
 
 type Ping struct {
@@ -174,7 +246,10 @@ type Pong struct {
 }
 
 type UDPPacket struct {
-	Content    string       `json:"c,omitempty"`
+	Content string `json:"c,omitempty"`
+	// Data is the raw payload, Content is only filled from it when the packet
+	// is sent as a JSON message.
+	Data       []byte       `json:"-"`
 	LocalAddr  *net.UDPAddr `json:"l,omitempty"`
 	RemoteAddr *net.UDPAddr `json:"r,omitempty"`
 }
@@ -0,0 +1,359 @@
+package udp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/fatedier/frp/pkg/msg"
+	netpkg "github.com/fatedier/frp/pkg/util/net"
+)
+
+// FramingBinary sends UDP packets as length-prefixed payloads, referring to
+// the addresses by compact IDs defined once per connection instead of JSON
+// messages with base64 content.
+const FramingBinary = "binary"
+
+const (
+	frameAddr   byte = 1 // id(2) | remote addr | local addr
+	framePacket byte = 2 // id(2) | length(2) | payload
+	framePing   byte = 3
+
+	// maxAddrIDs is the number of address pairs remembered by each side, the
+	// least recently defined one is replaced when a new pair is seen.
+	maxAddrIDs = 4096
+	maxPayload = 65535
+)
+
+type addrPair struct {
+	remote *net.UDPAddr
+	local  *net.UDPAddr
+}
+
+// MsgConn reads and writes the msg.UDPPacket and msg.Ping messages of a udp
+// or sudp work connection in the negotiated framing.
+type MsgConn struct {
+	conn   net.Conn
+	binary bool
+
+	reader    *bufio.Reader
+	readAddrs []*addrPair
+
+	writeMu   sync.Mutex
+	writeIDs  map[string]uint16
+	writeKeys []string
+	nextID    int
+	writeBuf  []byte
+
+	// set if packets are sent as QUIC datagrams, messages on conn are then
+	// read in another goroutine
+	flow      *netpkg.DatagramFlow
+	connMsgCh chan connMsg
+	closeCh   chan struct{}
+	closeOnce sync.Once
+}
+
+type connMsg struct {
+	m   msg.Message
+	err error
+}
+
+// NewMsgConn returns a MsgConn using framing, JSON messages are used if it is
+// empty or unknown.
+func NewMsgConn(conn net.Conn, framing string) *MsgConn {
+	c := &MsgConn{
+		conn:   conn,
+		binary: framing == FramingBinary,
+	}
+	if c.binary {
+		c.reader = bufio.NewReader(conn)
+		c.readAddrs = make([]*addrPair, maxAddrIDs)
+		c.writeIDs = make(map[string]uint16)
+		c.writeKeys = make([]string, maxAddrIDs)
+	}
+	return c
+}
+
+// UseDatagrams sends the packets as unreliable datagrams of flow. The
+// connection is still used for pings and for packets too large for a datagram.
+// It must be called before reading and writing messages.
+func (c *MsgConn) UseDatagrams(flow *netpkg.DatagramFlow) {
+	c.flow = flow
+	c.connMsgCh = make(chan connMsg)
+	c.closeCh = make(chan struct{})
+	go func() {
+		for {
+			m, err := c.readConnMsg()
+			select {
+			case c.connMsgCh <- connMsg{m: m, err: err}:
+			case <-c.closeCh:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+}
+
+func (c *MsgConn) Close() error {
+	if c.flow != nil {
+		c.closeOnce.Do(func() {
+			close(c.closeCh)
+			c.flow.Close()
+		})
+	}
+	return c.conn.Close()
+}
+
+func (c *MsgConn) ReadMsgTimeout(timeout time.Duration) (m msg.Message, err error) {
+	if c.flow != nil {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		return c.readMsg(timer.C)
+	}
+	_ = c.conn.SetReadDeadline(time.Now().Add(timeout))
+	m, err = c.ReadMsg()
+	_ = c.conn.SetReadDeadline(time.Time{})
+	return
+}
+
+func (c *MsgConn) ReadMsg() (msg.Message, error) {
+	if c.flow != nil {
+		return c.readMsg(nil)
+	}
+	return c.readConnMsg()
+}
+
+func (c *MsgConn) readMsg(timeout <-chan time.Time) (msg.Message, error) {
+	for {
+		select {
+		case r := <-c.connMsgCh:
+			return r.m, r.err
+		case b, ok := <-c.flow.Receive():
+			if !ok {
+				return nil, net.ErrClosed
+			}
+			m, err := decodeDatagram(b)
+			if err != nil {
+				// a corrupted datagram is dropped like a lost one
+				continue
+			}
+			return m, nil
+		case <-timeout:
+			return nil, fmt.Errorf("read udp message timeout")
+		}
+	}
+}
+
+func (c *MsgConn) readConnMsg() (msg.Message, error) {
+	if !c.binary {
+		return msg.ReadMsg(c.conn)
+	}
+	for {
+		typ, err := c.reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		switch typ {
+		case framePing:
+			return &msg.Ping{}, nil
+		case frameAddr:
+			if err := c.readAddrFrame(); err != nil {
+				return nil, err
+			}
+		case framePacket:
+			return c.readPacketFrame()
+		default:
+			return nil, fmt.Errorf("unknown udp frame type %d", typ)
+		}
+	}
+}
+
+func (c *MsgConn) readAddrFrame() error {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.reader, hdr[:]); err != nil {
+		return err
+	}
+	id := binary.BigEndian.Uint16(hdr[:])
+	if int(id) >= maxAddrIDs {
+		return fmt.Errorf("invalid udp address id %d", id)
+	}
+	remote, err := readAddr(c.reader)
+	if err != nil {
+		return err
+	}
+	local, err := readAddr(c.reader)
+	if err != nil {
+		return err
+	}
+	c.readAddrs[id] = &addrPair{remote: remote, local: local}
+	return nil
+}
+
+func (c *MsgConn) readPacketFrame() (*msg.UDPPacket, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(c.reader, hdr[:]); err != nil {
+		return nil, err
+	}
+	id := binary.BigEndian.Uint16(hdr[:2])
+	if int(id) >= maxAddrIDs || c.readAddrs[id] == nil {
+		return nil, fmt.Errorf("unknown udp address id %d", id)
+	}
+	data := make([]byte, binary.BigEndian.Uint16(hdr[2:]))
+	if _, err := io.ReadFull(c.reader, data); err != nil {
+		return nil, err
+	}
+	addrs := c.readAddrs[id]
+	return &msg.UDPPacket{
+		Data:       data,
+		RemoteAddr: addrs.remote,
+		LocalAddr:  addrs.local,
+	}, nil
+}
+
+func (c *MsgConn) WriteMsg(m msg.Message) error {
+	if p, ok := m.(*msg.UDPPacket); ok && c.flow != nil {
+		b, err := encodeDatagram(p)
+		if err != nil {
+			return err
+		}
+		if err = c.flow.Send(b); !errors.Is(err, netpkg.ErrDatagramTooLarge) {
+			return err
+		}
+	}
+
+	if !c.binary {
+		// the payload of packets created locally is only encoded when needed
+		if p, ok := m.(*msg.UDPPacket); ok && p.Content == "" && p.Data != nil {
+			encoded := *p
+			encoded.Content = base64.StdEncoding.EncodeToString(p.Data)
+			m = &encoded
+		}
+		return msg.WriteMsg(c.conn, m)
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	b := c.writeBuf[:0]
+	switch m := m.(type) {
+	case *msg.Ping:
+		b = append(b, framePing)
+	case *msg.UDPPacket:
+		data, err := GetContent(m)
+		if err != nil {
+			return err
+		}
+		if len(data) > maxPayload {
+			return fmt.Errorf("udp packet of %d bytes is too large", len(data))
+		}
+
+		// the encoded addresses are the key of the pair
+		b = append(b, frameAddr, 0, 0)
+		b = appendAddr(b, m.RemoteAddr)
+		b = appendAddr(b, m.LocalAddr)
+		key := string(b[3:])
+		id, ok := c.writeIDs[key]
+		if ok {
+			b = b[:0]
+		} else {
+			id = uint16(c.nextID)
+			c.nextID = (c.nextID + 1) % maxAddrIDs
+			delete(c.writeIDs, c.writeKeys[id])
+			c.writeIDs[key] = id
+			c.writeKeys[id] = key
+			binary.BigEndian.PutUint16(b[1:3], id)
+		}
+
+		b = append(b, framePacket)
+		b = binary.BigEndian.AppendUint16(b, id)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(data)))
+		b = append(b, data...)
+	default:
+		return fmt.Errorf("unexpected message type %T on udp work connection", m)
+	}
+	c.writeBuf = b
+	_, err := c.conn.Write(b)
+	return err
+}
+
+// encodeDatagram encodes p as the remote and local addresses followed by the
+// payload.
+func encodeDatagram(p *msg.UDPPacket) ([]byte, error) {
+	data, err := GetContent(p)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 0, 2*(2+net.IPv6len+1)+len(data))
+	b = appendAddr(b, p.RemoteAddr)
+	b = appendAddr(b, p.LocalAddr)
+	return append(b, data...), nil
+}
+
+func decodeDatagram(b []byte) (*msg.UDPPacket, error) {
+	r := bytes.NewReader(b)
+	remote, err := readAddr(r)
+	if err != nil {
+		return nil, err
+	}
+	local, err := readAddr(r)
+	if err != nil {
+		return nil, err
+	}
+	return &msg.UDPPacket{
+		Data:       b[len(b)-r.Len():],
+		RemoteAddr: remote,
+		LocalAddr:  local,
+	}, nil
+}
+
+// appendAddr encodes addr as a kind byte followed by the IP and the port. The
+// kind is 0 for a nil address, otherwise the length of the IP plus one. The
+// zone of IPv6 addresses is not kept.
+func appendAddr(b []byte, addr *net.UDPAddr) []byte {
+	if addr == nil {
+		return append(b, 0)
+	}
+	ip := addr.IP.To4()
+	if ip == nil {
+		ip = addr.IP.To16()
+	}
+	b = append(b, byte(len(ip)+1))
+	b = append(b, ip...)
+	return binary.BigEndian.AppendUint16(b, uint16(addr.Port))
+}
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+func readAddr(r byteReader) (*net.UDPAddr, error) {
+	kind, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if kind == 0 {
+		return nil, nil
+	}
+	ipLen := int(kind) - 1
+	if ipLen != 0 && ipLen != net.IPv4len && ipLen != net.IPv6len {
+		return nil, fmt.Errorf("invalid udp address kind %d", kind)
+	}
+	buf := make([]byte, ipLen+2)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	addr := &net.UDPAddr{Port: int(binary.BigEndian.Uint16(buf[ipLen:]))}
+	if ipLen > 0 {
+		addr.IP = net.IP(buf[:ipLen])
+	}
+	return addr, nil
+}
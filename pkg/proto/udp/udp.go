@@ -15,8 +15,10 @@
 package udp
 
 import (
+	"bytes"
 	"encoding/base64"
 	"net"
+	"strings"
 	"sync"
 	"time"
 
@@ -28,17 +30,29 @@ import (
 
 func NewUDPPacket(buf []byte, laddr, raddr *net.UDPAddr) *msg.UDPPacket {
 	return &msg.UDPPacket{
-		Content:    base64.StdEncoding.EncodeToString(buf),
+		Data:       bytes.Clone(buf),
 		LocalAddr:  laddr,
 		RemoteAddr: raddr,
 	}
 }
 
 func GetContent(m *msg.UDPPacket) (buf []byte, err error) {
+	if m.Data != nil {
+		return m.Data, nil
+	}
 	buf, err = base64.StdEncoding.DecodeString(m.Content)
 	return
 }
 
+// PayloadSize returns the size of the datagram carried by m.
+func PayloadSize(m *msg.UDPPacket) int {
+	if m.Data != nil {
+		return len(m.Data)
+	}
+	padding := strings.Count(m.Content[max(len(m.Content)-2, 0):], "=")
+	return base64.StdEncoding.DecodedLen(len(m.Content)) - padding
+}
+
 func ForwardUserConn(udpConn *net.UDPConn, readCh <-chan *msg.UDPPacket, sendCh chan<- *msg.UDPPacket, bufSize int) {
 	// read
 	go func() {
@@ -52,6 +66,7 @@ func ForwardUserConn(udpConn *net.UDPConn, readCh <-chan *msg.UDPPacket, sendCh
 	}()
 
 	// write
+	localAddr, _ := udpConn.LocalAddr().(*net.UDPAddr)
 	buf := pool.GetBuf(bufSize)
 	defer pool.PutBuf(buf)
 	for {
@@ -59,8 +74,8 @@ func ForwardUserConn(udpConn *net.UDPConn, readCh <-chan *msg.UDPPacket, sendCh
 		if err != nil {
 			return
 		}
-		// buf[:n] will be encoded to string, so the bytes can be reused
-		udpMsg := NewUDPPacket(buf[:n], nil, remoteAddr)
+		// buf[:n] is copied into the packet, so the bytes can be reused
+		udpMsg := NewUDPPacket(buf[:n], localAddr, remoteAddr)
 
 		select {
 		case sendCh <- udpMsg:
@@ -69,7 +84,12 @@ func ForwardUserConn(udpConn *net.UDPConn, readCh <-chan *msg.UDPPacket, sendCh
 	}
 }
 
-func Forwarder(dstAddr *net.UDPAddr, readCh <-chan *msg.UDPPacket, sendCh chan<- msg.Message, bufSize int) {
+// Forwarder relays the packets in readCh to dstAddr and the replies to sendCh.
+// If headerFn is not nil, its result is prepended to each packet sent to
+// dstAddr.
+func Forwarder(dstAddr *net.UDPAddr, readCh <-chan *msg.UDPPacket, sendCh chan<- msg.Message, bufSize int,
+	headerFn func(*msg.UDPPacket) []byte,
+) {
 	var mu sync.RWMutex
 	udpConnMap := make(map[string]*net.UDPConn)
 
@@ -110,6 +130,9 @@ func Forwarder(dstAddr *net.UDPAddr, readCh <-chan *msg.UDPPacket, sendCh chan<-
 			if err != nil {
 				continue
 			}
+			if headerFn != nil {
+				buf = append(headerFn(udpMsg), buf...)
+			}
 			mu.Lock()
 			udpConn, ok := udpConnMap[udpMsg.RemoteAddr.String()]
 			if !ok {
@@ -1,9 +1,18 @@
 package udp
 
 import (
+	"context"
+	"net"
 	"testing"
+	"time"
 
+	quic "github.com/quic-go/quic-go"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fatedier/frp/pkg/msg"
+	"github.com/fatedier/frp/pkg/transport"
+	netpkg "github.com/fatedier/frp/pkg/util/net"
 )
 
 func TestUdpPacket(t *testing.T) {
@@ -16,3 +25,136 @@ func TestUdpPacket(t *testing.T) {
 	assert.NoError(err)
 	assert.EqualValues(buf, newBuf)
 }
+
+func TestMsgConnFraming(t *testing.T) {
+	for _, framing := range []string{"", FramingBinary} {
+		t.Run("framing="+framing, func(t *testing.T) {
+			require := require.New(t)
+			c1, c2 := net.Pipe()
+			defer c1.Close()
+			defer c2.Close()
+			w := NewMsgConn(c1, framing)
+			r := NewMsgConn(c2, framing)
+
+			local := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 7000}
+			remote1 := &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 5000}
+			remote2 := &net.UDPAddr{IP: net.ParseIP("192.168.1.2"), Port: 6000}
+			msgs := []msg.Message{
+				NewUDPPacket([]byte("first"), local, remote1),
+				NewUDPPacket([]byte("second"), local, remote1),
+				&msg.Ping{},
+				NewUDPPacket([]byte("third"), nil, remote2),
+				NewUDPPacket(nil, local, remote1),
+			}
+			go func() {
+				for _, m := range msgs {
+					if err := w.WriteMsg(m); err != nil {
+						return
+					}
+				}
+			}()
+
+			for _, m := range msgs {
+				got, err := r.ReadMsg()
+				require.NoError(err)
+				want, ok := m.(*msg.UDPPacket)
+				if !ok {
+					require.IsType(&msg.Ping{}, got)
+					continue
+				}
+				p, ok := got.(*msg.UDPPacket)
+				require.True(ok)
+				content, err := GetContent(p)
+				require.NoError(err)
+				require.Equal(string(want.Data), string(content))
+				require.Equal(len(want.Data), PayloadSize(p))
+				require.Equal(want.RemoteAddr.String(), p.RemoteAddr.String())
+				require.Equal(want.LocalAddr.String(), p.LocalAddr.String())
+			}
+		})
+	}
+}
+
+func TestMsgConnBinaryAddrIDReuse(t *testing.T) {
+	require := require.New(t)
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	w := NewMsgConn(c1, FramingBinary)
+	r := NewMsgConn(c2, FramingBinary)
+
+	// more peers than address ids, the oldest ones are redefined
+	count := maxAddrIDs + 10
+	go func() {
+		for i := range count {
+			raddr := &net.UDPAddr{IP: net.IPv4(10, 0, byte(i>>8), byte(i)), Port: 1000 + i%maxAddrIDs}
+			if err := w.WriteMsg(NewUDPPacket([]byte{byte(i)}, nil, raddr)); err != nil {
+				return
+			}
+		}
+	}()
+	for i := range count {
+		m, err := r.ReadMsg()
+		require.NoError(err)
+		p := m.(*msg.UDPPacket)
+		require.Equal([]byte{byte(i)}, p.Data)
+		require.Equal(net.IPv4(10, 0, byte(i>>8), byte(i)).String(), p.RemoteAddr.IP.String())
+	}
+}
+
+func TestMsgConnDatagrams(t *testing.T) {
+	require := require.New(t)
+	serverTLS, err := transport.NewServerTLSConfig("", "", "")
+	require.NoError(err)
+	serverTLS.NextProtos = []string{"frp"}
+	l, err := quic.ListenAddr("127.0.0.1:0", serverTLS, &quic.Config{EnableDatagrams: true})
+	require.NoError(err)
+	defer l.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	clientTLS, err := transport.NewClientTLSConfig("", "", "", "")
+	require.NoError(err)
+	clientTLS.NextProtos = []string{"frp"}
+	clientConn, err := quic.DialAddr(ctx, l.Addr().String(), clientTLS, &quic.Config{EnableDatagrams: true})
+	require.NoError(err)
+	defer func() { _ = clientConn.CloseWithError(0, "") }()
+	serverConn, err := l.Accept(ctx)
+	require.NoError(err)
+
+	clientStream, err := clientConn.OpenStreamSync(ctx)
+	require.NoError(err)
+	_, err = clientStream.Write([]byte{0})
+	require.NoError(err)
+	serverStream, err := serverConn.AcceptStream(ctx)
+	require.NoError(err)
+	_, err = serverStream.Read(make([]byte, 1))
+	require.NoError(err)
+
+	serverFlow := netpkg.NewDatagramSession(serverConn).NewFlow()
+	clientFlow := netpkg.NewDatagramSession(clientConn).OpenFlow(serverFlow.ID())
+	w := NewMsgConn(netpkg.QuicStreamToNetConn(serverStream, serverConn, nil), FramingBinary)
+	w.UseDatagrams(serverFlow)
+	defer w.Close()
+	r := NewMsgConn(netpkg.QuicStreamToNetConn(clientStream, clientConn, nil), FramingBinary)
+	r.UseDatagrams(clientFlow)
+	defer r.Close()
+
+	raddr := &net.UDPAddr{IP: net.ParseIP("192.168.1.2"), Port: 6000}
+	// the large packet doesn't fit in a datagram and is sent on the stream
+	large := make([]byte, 4000)
+	for _, m := range []msg.Message{
+		NewUDPPacket([]byte("datagram"), nil, raddr),
+		&msg.Ping{},
+		NewUDPPacket(large, nil, raddr),
+	} {
+		require.NoError(w.WriteMsg(m))
+		got, err := r.ReadMsgTimeout(5 * time.Second)
+		require.NoError(err)
+		require.IsType(m, got)
+		if p, ok := m.(*msg.UDPPacket); ok {
+			require.Equal(p.Data, got.(*msg.UDPPacket).Data)
+			require.Equal(raddr.String(), got.(*msg.UDPPacket).RemoteAddr.String())
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/fatedier/frp/client"
 	httppkg "github.com/fatedier/frp/pkg/util/http"
@@ -97,6 +98,33 @@ func (c *Client) Stop(ctx context.Context) error {
 	return err
 }
 
+// DrainProxy takes the proxy out of service, a zero timeout uses the drain
+// timeout of the proxy config.
+func (c *Client) DrainProxy(ctx context.Context, name string, timeout time.Duration) error {
+	v := url.Values{}
+	v.Set("name", name)
+	if timeout > 0 {
+		v.Set("timeout", strconv.Itoa(int(timeout/time.Second)))
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", "http://"+c.address+"/api/proxy/drain?"+v.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	_, err = c.do(req)
+	return err
+}
+
+func (c *Client) ResumeProxy(ctx context.Context, name string) error {
+	v := url.Values{}
+	v.Set("name", name)
+	req, err := http.NewRequestWithContext(ctx, "POST", "http://"+c.address+"/api/proxy/resume?"+v.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	_, err = c.do(req)
+	return err
+}
+
 func (c *Client) GetConfig(ctx context.Context) (string, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+c.address+"/api/config", nil)
 	if err != nil {
@@ -0,0 +1,40 @@
+package limit
+
+import (
+	"io"
+
+	libio "github.com/fatedier/golib/io"
+	"golang.org/x/time/rate"
+)
+
+// NewLimiter returns a limiter of bytesPerSecond allowing bursts of burst
+// bytes, which defaults to bytesPerSecond. It returns nil if bytesPerSecond
+// is 0.
+func NewLimiter(bytesPerSecond, burst int64) *rate.Limiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = bytesPerSecond
+	}
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), int(burst))
+}
+
+// NewReadWriteCloser limits reading from rwc with readLimiter and writing to
+// it with writeLimiter, either of which may be nil.
+func NewReadWriteCloser(rwc io.ReadWriteCloser, readLimiter, writeLimiter *rate.Limiter) io.ReadWriteCloser {
+	if readLimiter == nil && writeLimiter == nil {
+		return rwc
+	}
+	var (
+		r io.Reader = rwc
+		w io.Writer = rwc
+	)
+	if readLimiter != nil {
+		r = NewReader(rwc, readLimiter)
+	}
+	if writeLimiter != nil {
+		w = NewWriter(rwc, writeLimiter)
+	}
+	return libio.WrapReadWriteCloser(r, w, rwc.Close)
+}
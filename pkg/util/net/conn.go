@@ -149,7 +149,7 @@ func WrapCloseNotifyConn(c net.Conn, closeFn func()) net.Conn {
 func (cc *CloseNotifyConn) Close() (err error) {
 	pflag := atomic.SwapInt32(&cc.closeFlag, 1)
 	if pflag == 0 {
-		err = cc.Close()
+		err = cc.Conn.Close()
 		if cc.closeFn != nil {
 			cc.closeFn()
 		}
@@ -198,16 +198,24 @@ func (statsConn *StatsConn) Close() (err error) {
 
 type wrapQuicStream struct {
 	quic.Stream
-	c quic.Connection
+	c         quic.Connection
+	datagrams *DatagramSession
 }
 
-func QuicStreamToNetConn(s quic.Stream, c quic.Connection) net.Conn {
+// QuicStreamToNetConn returns s as a net.Conn. datagrams is the datagram
+// session of c, or nil if it doesn't support datagrams.
+func QuicStreamToNetConn(s quic.Stream, c quic.Connection, datagrams *DatagramSession) net.Conn {
 	return &wrapQuicStream{
-		Stream: s,
-		c:      c,
+		Stream:    s,
+		c:         c,
+		datagrams: datagrams,
 	}
 }
 
+func (conn *wrapQuicStream) DatagramSession() *DatagramSession {
+	return conn.datagrams
+}
+
 func (conn *wrapQuicStream) LocalAddr() net.Addr {
 	if conn.c != nil {
 		return conn.c.LocalAddr()
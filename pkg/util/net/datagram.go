@@ -0,0 +1,168 @@
+package net
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	quic "github.com/quic-go/quic-go"
+)
+
+// ErrDatagramTooLarge is returned when a payload doesn't fit in a single QUIC
+// datagram, it should be sent on a stream instead.
+var ErrDatagramTooLarge = errors.New("datagram too large")
+
+// DatagramSessionAware is implemented by streams of QUIC connections, it
+// returns the datagram session of the connection or nil if the peer doesn't
+// support datagrams.
+type DatagramSessionAware interface {
+	DatagramSession() *DatagramSession
+}
+
+func GetDatagramSession(obj any) *DatagramSession {
+	if s, ok := obj.(DatagramSessionAware); ok {
+		return s.DatagramSession()
+	}
+	return nil
+}
+
+// DatagramSession splits the unreliable datagrams of a QUIC connection into
+// flows. Each datagram starts with the 4-byte ID of its flow, IDs are only
+// allocated by frps and announced to frpc on the stream the flow belongs to.
+type DatagramSession struct {
+	conn quic.Connection
+
+	flows  map[uint32]*DatagramFlow
+	nextID uint32
+	closed bool
+	mu     sync.Mutex
+}
+
+// NewDatagramSession returns the datagram session of conn, or nil if the peer
+// doesn't support datagrams.
+func NewDatagramSession(conn quic.Connection) *DatagramSession {
+	if !conn.ConnectionState().SupportsDatagrams {
+		return nil
+	}
+	s := &DatagramSession{
+		conn:  conn,
+		flows: make(map[uint32]*DatagramFlow),
+	}
+	go s.receiveLoop()
+	return s
+}
+
+func (s *DatagramSession) receiveLoop() {
+	defer s.close()
+	for {
+		b, err := s.conn.ReceiveDatagram(context.Background())
+		if err != nil {
+			return
+		}
+		if len(b) < 4 {
+			continue
+		}
+		s.mu.Lock()
+		if f, ok := s.flows[binary.BigEndian.Uint32(b)]; ok {
+			// datagrams are unreliable, drop them if the reader is too slow
+			select {
+			case f.ch <- b[4:]:
+			default:
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *DatagramSession) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for id, f := range s.flows {
+		delete(s.flows, id)
+		close(f.ch)
+	}
+}
+
+// NewFlow allocates a flow with an unused ID. It returns nil if s is nil or
+// closed.
+func (s *DatagramSession) NewFlow() *DatagramFlow {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		s.nextID++
+		if _, ok := s.flows[s.nextID]; !ok && s.nextID != 0 {
+			break
+		}
+	}
+	return s.addFlow(s.nextID)
+}
+
+// OpenFlow returns the flow with the ID allocated by the peer. It returns nil
+// if s is nil or closed.
+func (s *DatagramSession) OpenFlow(id uint32) *DatagramFlow {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, ok := s.flows[id]; ok {
+		return f
+	}
+	return s.addFlow(id)
+}
+
+func (s *DatagramSession) addFlow(id uint32) *DatagramFlow {
+	if s.closed {
+		return nil
+	}
+	f := &DatagramFlow{
+		id: id,
+		s:  s,
+		ch: make(chan []byte, 1024),
+	}
+	s.flows[id] = f
+	return f
+}
+
+// DatagramFlow sends and receives the datagrams of one flow of a session.
+type DatagramFlow struct {
+	id uint32
+	s  *DatagramSession
+	ch chan []byte
+}
+
+func (f *DatagramFlow) ID() uint32 {
+	return f.id
+}
+
+// Send sends b as a single datagram, ErrDatagramTooLarge is returned if it
+// doesn't fit.
+func (f *DatagramFlow) Send(b []byte) error {
+	buf := make([]byte, 4, 4+len(b))
+	binary.BigEndian.PutUint32(buf, f.id)
+	err := f.s.conn.SendDatagram(append(buf, b...))
+	if errors.Is(err, &quic.DatagramTooLargeError{}) {
+		return ErrDatagramTooLarge
+	}
+	return err
+}
+
+// Receive returns the channel of the datagrams received, it is closed when
+// the flow or the session is closed.
+func (f *DatagramFlow) Receive() <-chan []byte {
+	return f.ch
+}
+
+func (f *DatagramFlow) Close() {
+	f.s.mu.Lock()
+	defer f.s.mu.Unlock()
+	if f.s.flows[f.id] == f {
+		delete(f.s.flows, f.id)
+		close(f.ch)
+	}
+}
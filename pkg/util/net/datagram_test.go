@@ -0,0 +1,75 @@
+package net
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	quic "github.com/quic-go/quic-go"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fatedier/frp/pkg/transport"
+)
+
+func newDatagramSessions(t *testing.T) (server, client *DatagramSession) {
+	require := require.New(t)
+	serverTLS, err := transport.NewServerTLSConfig("", "", "")
+	require.NoError(err)
+	serverTLS.NextProtos = []string{"frp"}
+	l, err := quic.ListenAddr("127.0.0.1:0", serverTLS, &quic.Config{EnableDatagrams: true})
+	require.NoError(err)
+	t.Cleanup(func() { l.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	clientTLS, err := transport.NewClientTLSConfig("", "", "", "")
+	require.NoError(err)
+	clientTLS.NextProtos = []string{"frp"}
+	clientConn, err := quic.DialAddr(ctx, l.Addr().String(), clientTLS, &quic.Config{EnableDatagrams: true})
+	require.NoError(err)
+	t.Cleanup(func() { _ = clientConn.CloseWithError(0, "") })
+	serverConn, err := l.Accept(ctx)
+	require.NoError(err)
+
+	server = NewDatagramSession(serverConn)
+	client = NewDatagramSession(clientConn)
+	require.NotNil(server)
+	require.NotNil(client)
+	return server, client
+}
+
+func receiveDatagram(t *testing.T, f *DatagramFlow) []byte {
+	select {
+	case b := <-f.Receive():
+		return b
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "no datagram received")
+		return nil
+	}
+}
+
+func TestDatagramFlows(t *testing.T) {
+	require := require.New(t)
+	server, client := newDatagramSessions(t)
+
+	serverFlow1 := server.NewFlow()
+	serverFlow2 := server.NewFlow()
+	require.NotEqual(serverFlow1.ID(), serverFlow2.ID())
+	clientFlow1 := client.OpenFlow(serverFlow1.ID())
+	clientFlow2 := client.OpenFlow(serverFlow2.ID())
+
+	require.NoError(clientFlow1.Send([]byte("to flow 1")))
+	require.NoError(clientFlow2.Send([]byte("to flow 2")))
+	require.Equal("to flow 1", string(receiveDatagram(t, serverFlow1)))
+	require.Equal("to flow 2", string(receiveDatagram(t, serverFlow2)))
+
+	require.NoError(serverFlow1.Send([]byte("reply")))
+	require.Equal("reply", string(receiveDatagram(t, clientFlow1)))
+
+	require.ErrorIs(clientFlow1.Send(make([]byte, 64*1024)), ErrDatagramTooLarge)
+
+	serverFlow1.Close()
+	_, ok := <-serverFlow1.Receive()
+	require.False(ok)
+	require.NotEqual(serverFlow1.ID(), server.NewFlow().ID())
+}
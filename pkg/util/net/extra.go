@@ -1,78 +1,123 @@
 package net
 
 import (
-	"bytes"
-	"fmt"
 	"net"
-	"strings"
 )
 
 type TargetAware interface {
-	GetTargetAddr() string
+	GetTarget() Target
+}
+
+// VisitorUserAware is implemented by connections from visitors, it returns
+// the frp user of the visitor.
+type VisitorUserAware interface {
+	GetVisitorUser() string
+}
+
+// UDPFramingAware is implemented by connections from sudp visitors, it returns
+// the framing of UDP packets negotiated with the visitor.
+type UDPFramingAware interface {
+	GetUDPFraming() string
+}
+
+// UDPDatagramAware is implemented by connections from sudp visitors, it returns
+// the flow of QUIC datagrams carrying the UDP packets of the visitor.
+type UDPDatagramAware interface {
+	GetUDPDatagram() *DatagramFlow
 }
 
 var (
-	_ TargetAware = (*ConnExtra)(nil)
-	_ TargetAware = (*AddrExtra)(nil)
+	_ TargetAware      = (*ConnExtra)(nil)
+	_ TargetAware      = (*AddrExtra)(nil)
+	_ VisitorUserAware = (*ConnExtra)(nil)
+	_ VisitorUserAware = (*AddrExtra)(nil)
+	_ UDPFramingAware  = (*ConnExtra)(nil)
+	_ UDPFramingAware  = (*AddrExtra)(nil)
+	_ UDPDatagramAware = (*ConnExtra)(nil)
+	_ UDPDatagramAware = (*AddrExtra)(nil)
+
+	_ DatagramSessionAware = (*ConnExtra)(nil)
 )
 
-func GetTarget(dst any) string {
+func GetTarget(dst any) Target {
 	if target, ok := dst.(TargetAware); ok {
-		return target.GetTargetAddr()
+		return target.GetTarget()
+	}
+	return Target{}
+}
+
+func GetVisitorUser(obj any) string {
+	if u, ok := obj.(VisitorUserAware); ok {
+		return u.GetVisitorUser()
+	}
+	return ""
+}
+
+func GetUDPFraming(obj any) string {
+	if f, ok := obj.(UDPFramingAware); ok {
+		return f.GetUDPFraming()
 	}
 	return ""
 }
 
+func GetUDPDatagram(obj any) *DatagramFlow {
+	if d, ok := obj.(UDPDatagramAware); ok {
+		return d.GetUDPDatagram()
+	}
+	return nil
+}
+
 func WrapAddrTarget(obj any, addr net.Addr) net.Addr {
 	if extra, ok := obj.(TargetAware); ok {
-		return &AddrExtra{Addr: addr, TargetAddr: extra.GetTargetAddr()}
+		return &AddrExtra{
+			Addr:        addr,
+			Target:      extra.GetTarget(),
+			VisitorUser: GetVisitorUser(obj),
+			UDPFraming:  GetUDPFraming(obj),
+			UDPDatagram: GetUDPDatagram(obj),
+		}
 	}
 
 	return addr
 }
 
-func WrapConnTarget(c net.Conn, targetAddr string) net.Conn {
+func WrapConnTarget(c net.Conn, target Target) net.Conn {
 	return &ConnExtra{
-		Conn:       c,
-		TargetAddr: targetAddr,
+		Conn:   c,
+		Target: target,
 	}
 }
 
 type ConnExtra struct {
 	net.Conn
-	TargetAddr string
+	Target      Target
+	VisitorUser string
+	UDPFraming  string
+	UDPDatagram *DatagramFlow
 }
 
-func (c *ConnExtra) GetTargetAddr() string { return c.TargetAddr }
+func (c *ConnExtra) GetTarget() Target { return c.Target }
+
+func (c *ConnExtra) GetVisitorUser() string { return c.VisitorUser }
+
+func (c *ConnExtra) GetUDPFraming() string { return c.UDPFraming }
+
+func (c *ConnExtra) GetUDPDatagram() *DatagramFlow { return c.UDPDatagram }
+
+func (c *ConnExtra) DatagramSession() *DatagramSession { return GetDatagramSession(c.Conn) }
 
 type AddrExtra struct {
 	net.Addr
-	TargetAddr string
+	Target      Target
+	VisitorUser string
+	UDPFraming  string
+	UDPDatagram *DatagramFlow
 }
 
-func (c *AddrExtra) GetTargetAddr() string { return c.TargetAddr }
+func (c *AddrExtra) GetTarget() Target { return c.Target }
 
-func ParseTargetHead(userConn net.Conn) (string, error) {
-	var targetBuf []byte
-	buf := make([]byte, 1)
-	for {
-		n, err := userConn.Read(buf)
-		if err != nil {
-			return "", fmt.Errorf("read userConn: %w", err)
-		}
+func (c *AddrExtra) GetVisitorUser() string { return c.VisitorUser }
 
-		targetBuf = append(targetBuf, buf[:n]...)
-		if bytes.HasSuffix(targetBuf, []byte(";")) {
-			break
-		}
-	}
+func (c *AddrExtra) GetUDPFraming() string { return c.UDPFraming }
 
-	targetAddr := string(targetBuf)
-	if !strings.HasPrefix(targetAddr, "TARGET ") {
-		return "", fmt.Errorf("bad head format %q", targetAddr)
-	}
-
-	targetAddr = strings.TrimPrefix(targetAddr, "TARGET ")
-	targetAddr = strings.TrimSuffix(targetAddr, ";")
-	return targetAddr, nil
-}
+func (c *AddrExtra) GetUDPDatagram() *DatagramFlow { return c.UDPDatagram }
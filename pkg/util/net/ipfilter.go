@@ -0,0 +1,86 @@
+package net
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ParseCIDRs parses a list of CIDRs, a single IP address stands for itself.
+func ParseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, s := range cidrs {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address: %s", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// IPFilter decides whether a source address is allowed. An address in the
+// deny list is rejected, and if the allow list is not empty, only the
+// addresses in it are accepted. A nil IPFilter allows all addresses.
+type IPFilter struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// NewIPFilter returns nil if both lists are empty.
+func NewIPFilter(allow, deny []string) (*IPFilter, error) {
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil, nil
+	}
+	allowNets, err := ParseCIDRs(allow)
+	if err != nil {
+		return nil, fmt.Errorf("allow list: %v", err)
+	}
+	denyNets, err := ParseCIDRs(deny)
+	if err != nil {
+		return nil, fmt.Errorf("deny list: %v", err)
+	}
+	return &IPFilter{allow: allowNets, deny: denyNets}, nil
+}
+
+// Allowed reports whether the IP of addr is allowed, addr is an IP address
+// with an optional port. Addresses without a valid IP are allowed only if the
+// allow list is empty.
+func (f *IPFilter) Allowed(addr string) bool {
+	if f == nil {
+		return true
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return len(f.allow) == 0
+	}
+	for _, n := range f.deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	for _, n := range f.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
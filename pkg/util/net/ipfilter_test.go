@@ -0,0 +1,52 @@
+package net
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCIDRs(t *testing.T) {
+	require := require.New(t)
+	nets, err := ParseCIDRs([]string{"10.0.0.0/8", "192.168.1.1", "::1"})
+	require.NoError(err)
+	require.Len(nets, 3)
+	require.True(nets[0].Contains(net.ParseIP("10.1.2.3")))
+	require.True(nets[1].Contains(net.ParseIP("192.168.1.1")))
+	require.False(nets[1].Contains(net.ParseIP("192.168.1.2")))
+	require.True(nets[2].Contains(net.ParseIP("::1")))
+
+	_, err = ParseCIDRs([]string{"10.0.0.0/33"})
+	require.Error(err)
+	_, err = ParseCIDRs([]string{"example.com"})
+	require.Error(err)
+}
+
+func TestIPFilter(t *testing.T) {
+	require := require.New(t)
+	var f *IPFilter
+	require.True(f.Allowed("1.2.3.4:80"))
+
+	f, err := NewIPFilter(nil, nil)
+	require.NoError(err)
+	require.Nil(f)
+
+	f, err = NewIPFilter([]string{"10.0.0.0/8"}, []string{"10.0.0.1"})
+	require.NoError(err)
+	require.True(f.Allowed("10.1.2.3:80"))
+	require.True(f.Allowed("10.1.2.3"))
+	require.False(f.Allowed("10.0.0.1:80"))
+	require.False(f.Allowed("192.168.1.1:80"))
+	require.False(f.Allowed("invalid"))
+
+	f, err = NewIPFilter(nil, []string{"192.168.0.0/16", "::1"})
+	require.NoError(err)
+	require.True(f.Allowed("10.1.2.3:80"))
+	require.False(f.Allowed("192.168.1.1:80"))
+	require.False(f.Allowed("[::1]:80"))
+	require.True(f.Allowed("invalid"))
+
+	_, err = NewIPFilter([]string{"10.0.0.0/40"}, nil)
+	require.Error(err)
+}
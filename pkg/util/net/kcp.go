@@ -18,7 +18,10 @@ import (
 	"fmt"
 	"net"
 
+	"github.com/samber/lo"
 	kcp "github.com/xtaci/kcp-go/v5"
+
+	v1 "github.com/fatedier/frp/pkg/config/v1"
 )
 
 type KCPListener struct {
@@ -27,13 +30,13 @@ type KCPListener struct {
 	closeFlag bool
 }
 
-func ListenKcp(address string) (l *KCPListener, err error) {
-	listener, err := kcp.ListenWithOptions(address, nil, 10, 3)
+func ListenKcp(address string, opts *v1.KCPOptions) (l *KCPListener, err error) {
+	listener, err := kcp.ListenWithOptions(address, nil, opts.DataShards, opts.ParityShards)
 	if err != nil {
 		return l, err
 	}
-	_ = listener.SetReadBuffer(4194304)
-	_ = listener.SetWriteBuffer(4194304)
+	_ = listener.SetReadBuffer(opts.ReadBufferSize)
+	_ = listener.SetWriteBuffer(opts.WriteBufferSize)
 
 	l = &KCPListener{
 		listener:  listener,
@@ -51,12 +54,7 @@ func ListenKcp(address string) (l *KCPListener, err error) {
 				}
 				continue
 			}
-			conn.SetStreamMode(true)
-			conn.SetWriteDelay(true)
-			conn.SetNoDelay(1, 20, 2, 1)
-			conn.SetMtu(1350)
-			conn.SetWindowSize(1024, 1024)
-			conn.SetACKNoDelay(false)
+			setKCPOptions(conn, opts)
 
 			l.acceptCh <- conn
 		}
@@ -84,6 +82,27 @@ func (l *KCPListener) Addr() net.Addr {
 	return l.listener.Addr()
 }
 
+// DialKCP dials a KCP session to addr.
+func DialKCP(addr string, opts *v1.KCPOptions) (net.Conn, error) {
+	conn, err := kcp.DialWithOptions(addr, nil, opts.DataShards, opts.ParityShards)
+	if err != nil {
+		return nil, err
+	}
+	setKCPOptions(conn, opts)
+	_ = conn.SetReadBuffer(opts.ReadBufferSize)
+	_ = conn.SetWriteBuffer(opts.WriteBufferSize)
+	return conn, nil
+}
+
+func setKCPOptions(conn *kcp.UDPSession, opts *v1.KCPOptions) {
+	conn.SetStreamMode(true)
+	conn.SetWriteDelay(true)
+	conn.SetNoDelay(lo.Ternary(lo.FromPtr(opts.NoDelay), 1, 0), opts.Interval, opts.Resend, lo.Ternary(lo.FromPtr(opts.NoCongestion), 1, 0))
+	conn.SetMtu(opts.MTU)
+	conn.SetWindowSize(opts.SendWindow, opts.ReceiveWindow)
+	conn.SetACKNoDelay(false)
+}
+
 func NewKCPConnFromUDP(conn *net.UDPConn, connected bool, raddr string) (net.Conn, error) {
 	udpAddr, err := net.ResolveUDPAddr("udp", raddr)
 	if err != nil {
@@ -0,0 +1,42 @@
+package net
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/fatedier/frp/pkg/config/v1"
+)
+
+func TestKCPOptions(t *testing.T) {
+	require := require.New(t)
+	for _, opts := range []*v1.KCPOptions{
+		{},
+		{Mode: v1.KCPModeTurbo, MTU: 1200, ParityShards: -1},
+	} {
+		opts.Complete(128, 512)
+		l, err := ListenKcp("127.0.0.1:0", opts)
+		require.NoError(err)
+
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			_, _ = io.Copy(conn, conn)
+		}()
+
+		conn, err := DialKCP(l.Addr().String(), opts)
+		require.NoError(err)
+		_, err = conn.Write([]byte("hello kcp"))
+		require.NoError(err)
+		buf := make([]byte, 9)
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(err)
+		require.Equal("hello kcp", string(buf))
+		conn.Close()
+		l.Close()
+	}
+}
@@ -0,0 +1,43 @@
+package net
+
+import (
+	"net"
+
+	pp "github.com/pires/go-proxyproto"
+)
+
+// Custom PROXY protocol v2 TLV types frpc adds to the headers sent to local
+// services, their values are UTF-8 strings.
+const (
+	// PP2TypeFRPProxyName is the name of the proxy.
+	PP2TypeFRPProxyName pp.PP2Type = 0xE0
+	// PP2TypeFRPUser is the frp user of the proxy.
+	PP2TypeFRPUser pp.PP2Type = 0xE1
+	// PP2TypeFRPVisitorUser is the frp user of the visitor of a stcp proxy.
+	PP2TypeFRPVisitorUser pp.PP2Type = 0xE2
+)
+
+// NewProxyProtocolListener returns a listener accepting PROXY protocol v1 and
+// v2 headers from the trusted networks. The header is read on the first use of
+// a connection and RemoteAddr returns the client address in it. Connections
+// from other addresses are left untouched.
+func NewProxyProtocolListener(l net.Listener, trusted []*net.IPNet) net.Listener {
+	if len(trusted) == 0 {
+		return l
+	}
+	return &pp.Listener{
+		Listener: l,
+		ConnPolicy: func(opts pp.ConnPolicyOptions) (pp.Policy, error) {
+			addr, ok := opts.Upstream.(*net.TCPAddr)
+			if !ok {
+				return pp.SKIP, nil
+			}
+			for _, n := range trusted {
+				if n.Contains(addr.IP) {
+					return pp.USE, nil
+				}
+			}
+			return pp.SKIP, nil
+		},
+	}
+}
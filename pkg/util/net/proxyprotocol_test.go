@@ -0,0 +1,56 @@
+package net
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	pp "github.com/pires/go-proxyproto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyProtocolListener(t *testing.T) {
+	require := require.New(t)
+	header := &pp.Header{
+		Version:           2,
+		Command:           pp.PROXY,
+		TransportProtocol: pp.TCPv4,
+		SourceAddr:        &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 5678},
+		DestinationAddr:   &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 80},
+	}
+
+	accept := func(trusted string) (net.Conn, []byte) {
+		nets, err := ParseCIDRs([]string{trusted})
+		require.NoError(err)
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(err)
+		defer ln.Close()
+		l := NewProxyProtocolListener(ln, nets)
+
+		go func() {
+			c, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				return
+			}
+			defer c.Close()
+			_, _ = header.WriteTo(c)
+			_, _ = c.Write([]byte("hello"))
+		}()
+		c, err := l.Accept()
+		require.NoError(err)
+		data, err := io.ReadAll(c)
+		require.NoError(err)
+		return c, data
+	}
+
+	c, data := accept("127.0.0.0/8")
+	defer c.Close()
+	require.Equal("1.2.3.4:5678", c.RemoteAddr().String())
+	require.Equal("hello", string(data))
+
+	// headers from untrusted addresses are passed through as is
+	c, data = accept("10.0.0.0/8")
+	defer c.Close()
+	require.Equal("127.0.0.1", c.RemoteAddr().(*net.TCPAddr).IP.String())
+	require.NotEqual("hello", string(data))
+}
@@ -0,0 +1,218 @@
+package net
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fatedier/frp/pkg/cmux"
+)
+
+// Target is the address that a visitor asks the frpc behind a stcp proxy to
+// dial instead of its local service.
+type Target struct {
+	Addr string
+	// Network is "tcp" or "udp", empty means "tcp".
+	Network string
+	// DialTimeout bounds the dial on frpc, 0 means the default.
+	DialTimeout time.Duration
+	// DialReply asks frpc to report the dial result before relaying data.
+	DialReply bool
+}
+
+// Status codes replied to the TARGET2 handshake.
+const (
+	TargetStatusOK             = 200
+	TargetStatusBadRequest     = 400
+	TargetStatusHostNotFound   = 404
+	TargetStatusDialFailed     = 500
+	TargetStatusNetUnreachable = 501
+	TargetStatusTunnelFailed   = 502
+	TargetStatusRefused        = 503
+	TargetStatusTimeout        = 504
+)
+
+var targetStatusText = map[int]string{
+	TargetStatusOK:             "OK",
+	TargetStatusBadRequest:     "Bad Request",
+	TargetStatusHostNotFound:   "Host Not Found",
+	TargetStatusDialFailed:     "Dial Failed",
+	TargetStatusNetUnreachable: "Network Unreachable",
+	TargetStatusTunnelFailed:   "Tunnel Failed",
+	TargetStatusRefused:        "Connection Refused",
+	TargetStatusTimeout:        "Connect Timeout",
+}
+
+// TargetStatusText returns the reason phrase of a target status code.
+func TargetStatusText(code int) string {
+	if text, ok := targetStatusText[code]; ok {
+		return text
+	}
+	return targetStatusText[TargetStatusDialFailed]
+}
+
+// TargetDialStatus classifies the error of dialing a target.
+func TargetDialStatus(err error) int {
+	var (
+		dnsErr *net.DNSError
+		netErr net.Error
+	)
+	switch {
+	case err == nil:
+		return TargetStatusOK
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return TargetStatusRefused
+	case errors.As(err, &dnsErr):
+		return TargetStatusHostNotFound
+	case errors.Is(err, syscall.ENETUNREACH), errors.Is(err, syscall.EHOSTUNREACH):
+		return TargetStatusNetUnreachable
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return TargetStatusTimeout
+	default:
+		return TargetStatusDialFailed
+	}
+}
+
+const (
+	targetHeadV1 = "TARGET "
+	targetHeadV2 = "TARGET2 "
+
+	maxTargetHeadSize = 1024
+)
+
+// TargetHeadPrefixes are the prefixes that start a target header.
+var TargetHeadPrefixes = []string{targetHeadV1, targetHeadV2}
+
+// ParseTargetHead reads a "TARGET host:port;" or a
+// "TARGET2 host:port [network=tcp|udp] [timeout=5s]\n" header from userConn.
+// It returns the protocol version of the header, which is known even if the
+// options are invalid, and a conn that replays the bytes read past the header.
+func ParseTargetHead(userConn net.Conn) (target Target, version int, conn net.Conn, err error) {
+	conn = userConn
+	r := bufio.NewReaderSize(userConn, maxTargetHeadSize)
+	defer func() {
+		if n := r.Buffered(); n > 0 {
+			rest, _ := r.Peek(n)
+			conn = cmux.UnreadConn(userConn, bytes.Clone(rest))
+		}
+	}()
+
+	prefix, err := r.Peek(len(targetHeadV1))
+	if err != nil {
+		return target, 0, conn, fmt.Errorf("read userConn: %w", err)
+	}
+	if string(prefix) != targetHeadV1 {
+		if prefix, err = r.Peek(len(targetHeadV2)); err != nil || string(prefix) != targetHeadV2 {
+			return target, 0, conn, fmt.Errorf("bad head format %q", prefix)
+		}
+		version = 2
+	} else {
+		version = 1
+	}
+
+	delim := byte(';')
+	if version == 2 {
+		delim = '\n'
+	}
+	line, err := r.ReadSlice(delim)
+	if err != nil {
+		if errors.Is(err, bufio.ErrBufferFull) {
+			return target, version, conn, fmt.Errorf("target head exceeds %d bytes", maxTargetHeadSize)
+		}
+		return target, version, conn, fmt.Errorf("read userConn: %w", err)
+	}
+
+	if version == 1 {
+		target.Addr = strings.TrimSuffix(strings.TrimPrefix(string(line), targetHeadV1), ";")
+		return target, version, conn, nil
+	}
+
+	fields := strings.Fields(strings.TrimPrefix(string(line), targetHeadV2))
+	if len(fields) == 0 {
+		return target, version, conn, errors.New("target address is required")
+	}
+	target.Addr = fields[0]
+	if _, _, err := net.SplitHostPort(target.Addr); err != nil {
+		return target, version, conn, err
+	}
+	for _, opt := range fields[1:] {
+		k, v, _ := strings.Cut(opt, "=")
+		switch k {
+		case "network":
+			if v != "tcp" && v != "udp" {
+				return target, version, conn, fmt.Errorf("unsupported network %q", v)
+			}
+			target.Network = v
+		case "timeout":
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				return target, version, conn, fmt.Errorf("invalid timeout %q", v)
+			}
+			target.DialTimeout = d
+		default:
+			return target, version, conn, fmt.Errorf("unknown option %q", k)
+		}
+	}
+	return target, version, conn, nil
+}
+
+// WriteTargetStatus writes the "TARGET2 <code> <reason>\n" status line.
+func WriteTargetStatus(w io.Writer, code int) error {
+	_, err := fmt.Fprintf(w, "%s%d %s\n", targetHeadV2, code, TargetStatusText(code))
+	return err
+}
+
+// PacketStreamConn relays the datagrams of a connected packet conn as a byte
+// stream, in which each datagram is prefixed by its 2 bytes big endian length.
+type PacketStreamConn struct {
+	net.Conn
+
+	buf  []byte
+	rbuf []byte
+	wbuf []byte
+}
+
+func NewPacketStreamConn(c net.Conn) *PacketStreamConn {
+	return &PacketStreamConn{
+		Conn: c,
+		buf:  make([]byte, 2+65535),
+	}
+}
+
+func (c *PacketStreamConn) Read(p []byte) (int, error) {
+	if len(c.rbuf) == 0 {
+		n, err := c.Conn.Read(c.buf[2:])
+		if err != nil {
+			return 0, err
+		}
+		binary.BigEndian.PutUint16(c.buf, uint16(n))
+		c.rbuf = c.buf[:2+n]
+	}
+	n := copy(p, c.rbuf)
+	c.rbuf = c.rbuf[n:]
+	return n, nil
+}
+
+func (c *PacketStreamConn) Write(p []byte) (int, error) {
+	c.wbuf = append(c.wbuf, p...)
+	off := 0
+	for len(c.wbuf)-off >= 2 {
+		size := int(binary.BigEndian.Uint16(c.wbuf[off:]))
+		if len(c.wbuf)-off-2 < size {
+			break
+		}
+		if _, err := c.Conn.Write(c.wbuf[off+2 : off+2+size]); err != nil {
+			return 0, err
+		}
+		off += 2 + size
+	}
+	c.wbuf = append(c.wbuf[:0], c.wbuf[off:]...)
+	return len(p), nil
+}
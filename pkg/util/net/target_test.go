@@ -0,0 +1,87 @@
+package net
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func parseHead(t *testing.T, head string) (Target, int, []byte, error) {
+	client, server := net.Pipe()
+	go func() {
+		_, _ = client.Write([]byte(head))
+		client.Close()
+	}()
+	target, version, conn, err := ParseTargetHead(server)
+	if err != nil {
+		return target, version, nil, err
+	}
+	rest, _ := io.ReadAll(conn)
+	return target, version, rest, nil
+}
+
+func TestParseTargetHead(t *testing.T) {
+	require := require.New(t)
+
+	target, version, rest, err := parseHead(t, "TARGET 10.0.0.1:22;SSH-2.0")
+	require.NoError(err)
+	require.Equal(1, version)
+	require.Equal(Target{Addr: "10.0.0.1:22"}, target)
+	require.Equal("SSH-2.0", string(rest))
+
+	target, version, rest, err = parseHead(t, "TARGET2 db:5432 network=udp timeout=3s\nhello")
+	require.NoError(err)
+	require.Equal(2, version)
+	require.Equal(Target{Addr: "db:5432", Network: "udp", DialTimeout: 3 * time.Second}, target)
+	require.Equal("hello", string(rest))
+
+	_, version, _, err = parseHead(t, "TARGET2 db:5432 proto=sctp\n")
+	require.Error(err)
+	require.Equal(2, version)
+
+	_, _, _, err = parseHead(t, "TARGET2 db\n")
+	require.Error(err)
+}
+
+func TestWriteTargetStatus(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WriteTargetStatus(&buf, TargetStatusRefused))
+	require.Equal(t, "TARGET2 503 Connection Refused\n", buf.String())
+}
+
+func TestPacketStreamConn(t *testing.T) {
+	require := require.New(t)
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(err)
+	defer server.Close()
+
+	conn, err := net.Dial("udp", server.LocalAddr().String())
+	require.NoError(err)
+	c := NewPacketStreamConn(conn)
+	defer c.Close()
+
+	// one datagram written in two parts, followed by a second one
+	_, err = c.Write([]byte{0, 3, 'a'})
+	require.NoError(err)
+	_, err = c.Write([]byte{'b', 'c', 0, 1, 'd'})
+	require.NoError(err)
+
+	buf := make([]byte, 16)
+	n, addr, err := server.ReadFrom(buf)
+	require.NoError(err)
+	require.Equal("abc", string(buf[:n]))
+	n, _, err = server.ReadFrom(buf)
+	require.NoError(err)
+	require.Equal("d", string(buf[:n]))
+
+	_, err = server.WriteTo([]byte("pong"), addr)
+	require.NoError(err)
+	frame := make([]byte, 6)
+	_, err = io.ReadFull(c, frame)
+	require.NoError(err)
+	require.Equal([]byte{0, 4, 'p', 'o', 'n', 'g'}, frame)
+}
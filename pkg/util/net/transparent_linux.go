@@ -0,0 +1,102 @@
+package net
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// soOriginalDst is SO_ORIGINAL_DST of netfilter, which is also
+	// IP6T_SO_ORIGINAL_DST at the SOL_IPV6 level.
+	soOriginalDst = 80
+	// ipv6Transparent is IPV6_TRANSPARENT, missing in package syscall.
+	ipv6Transparent = 75
+)
+
+// ListenTransparent listens for connections redirected by iptables or
+// nftables. If tproxy is true, the listener is marked IP_TRANSPARENT to
+// accept connections from TPROXY rules, which needs CAP_NET_ADMIN.
+func ListenTransparent(network, address string, tproxy bool) (net.Listener, error) {
+	lc := net.ListenConfig{}
+	if tproxy {
+		lc.Control = func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+			err := c.Control(func(fd uintptr) {
+				sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_IP, syscall.IP_TRANSPARENT, 1)
+				if sockErr == nil && network == "tcp6" {
+					sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_IPV6, ipv6Transparent, 1)
+				}
+			})
+			if err != nil {
+				return err
+			}
+			if sockErr != nil {
+				return fmt.Errorf("set IP_TRANSPARENT: %w", sockErr)
+			}
+			return nil
+		}
+	}
+	return lc.Listen(context.Background(), network, address)
+}
+
+// OriginalDst returns the destination address of a connection before it was
+// redirected. Connections accepted by TPROXY keep it as the local address,
+// REDIRECT rules need SO_ORIGINAL_DST to recover it.
+func OriginalDst(conn net.Conn, tproxy bool) (string, error) {
+	if tproxy {
+		return conn.LocalAddr().String(), nil
+	}
+
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return "", fmt.Errorf("unsupported connection type %T", conn)
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return "", err
+	}
+
+	var (
+		addr   string
+		optErr error
+	)
+	isIPv4 := true
+	if tcpAddr, ok := conn.LocalAddr().(*net.TCPAddr); ok && tcpAddr.IP.To4() == nil {
+		isIPv4 = false
+	}
+	err = rc.Control(func(fd uintptr) {
+		if isIPv4 {
+			// sockaddr_in fits in the 16 bytes of IPv6Mreq
+			mreq, err := syscall.GetsockoptIPv6Mreq(int(fd), syscall.SOL_IP, soOriginalDst)
+			if err != nil {
+				optErr = err
+				return
+			}
+			raw := mreq.Multiaddr
+			port := binary.BigEndian.Uint16(raw[2:4])
+			addr = net.JoinHostPort(net.IP(raw[4:8]).String(), strconv.Itoa(int(port)))
+			return
+		}
+		// sockaddr_in6 fits in the Addr of IPv6MTUInfo
+		info, err := syscall.GetsockoptIPv6MTUInfo(int(fd), syscall.SOL_IPV6, soOriginalDst)
+		if err != nil {
+			optErr = err
+			return
+		}
+		// the port is kept in network byte order
+		port := binary.BigEndian.Uint16((*[2]byte)(unsafe.Pointer(&info.Addr.Port))[:])
+		addr = net.JoinHostPort(net.IP(info.Addr.Addr[:]).String(), strconv.Itoa(int(port)))
+	})
+	if err != nil {
+		return "", err
+	}
+	if optErr != nil {
+		return "", fmt.Errorf("get SO_ORIGINAL_DST: %w", optErr)
+	}
+	return addr, nil
+}
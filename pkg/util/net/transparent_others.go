@@ -0,0 +1,18 @@
+//go:build !linux
+
+package net
+
+import (
+	"errors"
+	"net"
+)
+
+var errTransparentUnsupported = errors.New("transparent proxy is only supported on linux")
+
+func ListenTransparent(network, address string, tproxy bool) (net.Listener, error) {
+	return nil, errTransparentUnsupported
+}
+
+func OriginalDst(conn net.Conn, tproxy bool) (string, error) {
+	return "", errTransparentUnsupported
+}
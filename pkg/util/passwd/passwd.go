@@ -0,0 +1,199 @@
+package passwd
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	AlgoBcrypt   = "bcrypt"
+	AlgoArgon2id = "argon2id"
+)
+
+// argon2id parameters used by Hash, as recommended by RFC 9106.
+const (
+	argon2Memory  = 64 * 1024
+	argon2Time    = 3
+	argon2Threads = 4
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+)
+
+// Hash hashes password with algo, which is AlgoBcrypt or AlgoArgon2id.
+func Hash(password, algo string) (string, error) {
+	switch algo {
+	case AlgoBcrypt, "":
+		h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		return string(h), err
+	case AlgoArgon2id:
+		salt := make([]byte, argon2SaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argon2Memory, argon2Time, argon2Threads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	default:
+		return "", fmt.Errorf("unsupported hash algorithm %q", algo)
+	}
+}
+
+// Check validates the format of a stored password, which is either a
+// bcrypt, argon2id or {SHA} hash, or a plaintext password.
+func Check(stored string) error {
+	switch {
+	case isBcrypt(stored):
+		_, err := bcrypt.Cost([]byte(stored))
+		return err
+	case strings.HasPrefix(stored, "$argon2id$"):
+		_, err := parseArgon2id(stored)
+		return err
+	case strings.HasPrefix(stored, "$apr1$"), strings.HasPrefix(stored, "$1$"):
+		return errors.New("md5 password hashes are not supported, use bcrypt")
+	}
+	return nil
+}
+
+// Verify reports whether password matches the stored password.
+func Verify(stored, password string) bool {
+	switch {
+	case isBcrypt(stored):
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil
+	case strings.HasPrefix(stored, "$argon2id$"):
+		p, err := parseArgon2id(stored)
+		if err != nil {
+			return false
+		}
+		key := argon2.IDKey([]byte(password), p.salt, p.time, p.memory, p.threads, uint32(len(p.key)))
+		return subtle.ConstantTimeCompare(key, p.key) == 1
+	case strings.HasPrefix(stored, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		return subtle.ConstantTimeCompare([]byte(stored[len("{SHA}"):]),
+			[]byte(base64.StdEncoding.EncodeToString(sum[:]))) == 1
+	default:
+		return subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+	}
+}
+
+func isBcrypt(s string) bool {
+	return strings.HasPrefix(s, "$2a$") || strings.HasPrefix(s, "$2b$") || strings.HasPrefix(s, "$2y$")
+}
+
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func parseArgon2id(s string) (*argon2Params, error) {
+	// $argon2id$v=19$m=65536,t=3,p=4$salt$key
+	parts := strings.Split(s, "$")
+	if len(parts) != 6 {
+		return nil, errors.New("invalid argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2id version %q", parts[2])
+	}
+	p := &argon2Params{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return nil, fmt.Errorf("invalid argon2id parameters %q", parts[3])
+	}
+	var err error
+	if p.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, fmt.Errorf("invalid argon2id salt: %v", err)
+	}
+	if p.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(p.key) == 0 {
+		return nil, errors.New("invalid argon2id key")
+	}
+	return p, nil
+}
+
+// LoadHtpasswd reads "user:password" lines from an htpasswd file.
+func LoadHtpasswd(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		user, stored, ok := strings.Cut(text, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("%s:%d: invalid htpasswd line", path, line)
+		}
+		if err := Check(stored); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		users[user] = stored
+	}
+	return users, scanner.Err()
+}
+
+// Users verifies user credentials against stored passwords. Successful
+// verifications of hashed passwords are remembered, so that clients sending
+// credentials with every request do not pay for the hash each time.
+type Users struct {
+	stored map[string]string
+
+	mu       sync.Mutex
+	verified map[string][sha256.Size]byte
+}
+
+func NewUsers(stored map[string]string) *Users {
+	return &Users{
+		stored:   stored,
+		verified: make(map[string][sha256.Size]byte),
+	}
+}
+
+func (u *Users) Len() int {
+	return len(u.stored)
+}
+
+func (u *Users) Exists(user string) bool {
+	_, ok := u.stored[user]
+	return ok
+}
+
+func (u *Users) Verify(user, password string) bool {
+	stored, ok := u.stored[user]
+	if !ok {
+		return false
+	}
+	sum := sha256.Sum256([]byte(password))
+	u.mu.Lock()
+	last, ok := u.verified[user]
+	u.mu.Unlock()
+	if ok && subtle.ConstantTimeCompare(last[:], sum[:]) == 1 {
+		return true
+	}
+
+	if !Verify(stored, password) {
+		return false
+	}
+	u.mu.Lock()
+	u.verified[user] = sum
+	u.mu.Unlock()
+	return true
+}
@@ -0,0 +1,54 @@
+package passwd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashAndVerify(t *testing.T) {
+	require := require.New(t)
+	for _, algo := range []string{AlgoBcrypt, AlgoArgon2id} {
+		hash, err := Hash("s3cret", algo)
+		require.NoError(err)
+		require.NoError(Check(hash))
+		require.True(Verify(hash, "s3cret"), algo)
+		require.False(Verify(hash, "wrong"), algo)
+	}
+
+	// htpasswd -s
+	require.True(Verify("{SHA}qUqP5cyxm6YcTAhz05Hph5gvu9M=", "test"))
+	require.True(Verify("plain", "plain"))
+	require.False(Verify("plain", "plain2"))
+
+	_, err := Hash("x", "md5")
+	require.Error(err)
+	require.Error(Check("$apr1$abc$def"))
+	require.Error(Check("$argon2id$v=19$m=1$bad"))
+}
+
+func TestUsers(t *testing.T) {
+	require := require.New(t)
+	hash, err := Hash("pass", AlgoBcrypt)
+	require.NoError(err)
+
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	require.NoError(os.WriteFile(path, []byte("# team\nalice:"+hash+"\nbob:{SHA}qUqP5cyxm6YcTAhz05Hph5gvu9M=\n"), 0o600))
+	stored, err := LoadHtpasswd(path)
+	require.NoError(err)
+
+	users := NewUsers(stored)
+	require.Equal(2, users.Len())
+	require.True(users.Exists("bob"))
+	require.True(users.Verify("alice", "pass"))
+	require.True(users.Verify("alice", "pass"))
+	require.False(users.Verify("alice", "other"))
+	require.True(users.Verify("bob", "test"))
+	require.False(users.Verify("carol", "pass"))
+
+	require.NoError(os.WriteFile(path, []byte("broken\n"), 0o600))
+	_, err = LoadHtpasswd(path)
+	require.Error(err)
+}
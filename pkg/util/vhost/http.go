@@ -15,7 +15,11 @@
 package vhost
 
 import (
+	"cmp"
 	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -36,6 +40,10 @@ import (
 
 var ErrNoRouteFound = errors.New("no route found")
 
+// ErrRateLimited is returned by AcquireRequestFn when a request is rejected
+// by a rate limit rather than a concurrency limit.
+var ErrRateLimited = errors.New("rate limited")
+
 type HTTPReverseProxyOptions struct {
 	ResponseHeaderTimeoutS int64
 }
@@ -63,7 +71,6 @@ func NewHTTPReverseProxy(option HTTPReverseProxyOptions, vhostRouter *Routers) *
 			req := r.Out
 			req.URL.Scheme = "http"
 			reqRouteInfo := req.Context().Value(RouteInfoKey).(*RequestRouteInfo)
-			originalHost, _ := httppkg.CanonicalHost(reqRouteInfo.Host)
 
 			rc := req.Context().Value(RouteConfigKey).(*RouteConfig)
 			if rc != nil {
@@ -71,14 +78,8 @@ func NewHTTPReverseProxy(option HTTPReverseProxyOptions, vhostRouter *Routers) *
 					req.Host = rc.RewriteHost
 				}
 
-				var endpoint string
-				if rc.ChooseEndpointFn != nil {
-					// ignore error here, it will use CreateConnFn instead later
-					endpoint, _ = rc.ChooseEndpointFn()
-					reqRouteInfo.Endpoint = endpoint
-					log.Tracef("choose endpoint name [%s] for http request host [%s] path [%s] httpuser [%s]",
-						endpoint, originalHost, reqRouteInfo.URL, reqRouteInfo.HTTPUser)
-				}
+				// the endpoint is chosen by ServeHTTP
+				endpoint := reqRouteInfo.Endpoint
 				// Set {domain}.{location}.{routeByHTTPUser}.{endpoint} as URL host here to let http transport reuse connections.
 				req.URL.Host = rc.Domain + "." +
 					base64.StdEncoding.EncodeToString([]byte(rc.Location)) + "." +
@@ -93,11 +94,25 @@ func NewHTTPReverseProxy(option HTTPReverseProxyOptions, vhostRouter *Routers) *
 			}
 		},
 		ModifyResponse: func(r *http.Response) error {
-			rc := r.Request.Context().Value(RouteConfigKey).(*RouteConfig)
-			if rc != nil {
+			rc, ok := r.Request.Context().Value(RouteConfigKey).(*RouteConfig)
+			if ok && rc != nil {
 				for k, v := range rc.ResponseHeaders {
 					r.Header.Set(k, v)
 				}
+				var endpoint string
+				if info, ok := r.Request.Context().Value(RouteInfoKey).(*RequestRouteInfo); ok {
+					endpoint = info.Endpoint
+				}
+				if rc.AffinityCookie != "" && endpoint != "" && getAffinity(r.Request, rc.AffinityCookie) != AffinityToken(endpoint) {
+					cookie := &http.Cookie{
+						Name:     rc.AffinityCookie,
+						Value:    AffinityToken(endpoint),
+						Path:     cmp.Or(rc.Location, "/"),
+						HttpOnly: true,
+						SameSite: http.SameSiteLaxMode,
+					}
+					r.Header.Add("Set-Cookie", cookie.String())
+				}
 			}
 			return nil
 		},
@@ -197,6 +212,89 @@ func (rp *HTTPReverseProxy) CheckAuth(domain, location, routeByHTTPUser, user, p
 	return true
 }
 
+// CheckSource reports whether a client from remoteAddr may use the route.
+func (rp *HTTPReverseProxy) CheckSource(domain, location, routeByHTTPUser, remoteAddr string) bool {
+	vr, ok := rp.getVhost(domain, location, routeByHTTPUser)
+	if ok {
+		if fn := vr.payload.(*RouteConfig).AllowSourceFn; fn != nil {
+			return fn(remoteAddr)
+		}
+	}
+	return true
+}
+
+// chooseEndpoint chooses the endpoint of rc for the request, if rc has more
+// than one.
+func (rp *HTTPReverseProxy) chooseEndpoint(req *http.Request) {
+	reqRouteInfo := req.Context().Value(RouteInfoKey).(*RequestRouteInfo)
+	rc, ok := req.Context().Value(RouteConfigKey).(*RouteConfig)
+	if !ok || rc == nil || rc.ChooseEndpointFn == nil {
+		return
+	}
+	// ignore error here, it will use CreateConnFn instead later
+	reqRouteInfo.Endpoint, _ = rc.ChooseEndpointFn(reqRouteInfo.RemoteAddr, getAffinity(req, rc.AffinityCookie))
+	originalHost, _ := httppkg.CanonicalHost(reqRouteInfo.Host)
+	log.Tracef("choose endpoint name [%s] for http request host [%s] path [%s] httpuser [%s]",
+		reqRouteInfo.Endpoint, originalHost, reqRouteInfo.URL, reqRouteInfo.HTTPUser)
+}
+
+// acquireRequest admits the request to its route and endpoint. If it is
+// admitted, release should be called when the request is done.
+func (rp *HTTPReverseProxy) acquireRequest(req *http.Request) (release func(), err error) {
+	reqRouteInfo := req.Context().Value(RouteInfoKey).(*RequestRouteInfo)
+	rc, ok := req.Context().Value(RouteConfigKey).(*RouteConfig)
+	if !ok || rc == nil {
+		return func() {}, nil
+	}
+	if reqRouteInfo.Endpoint != "" && rc.AcquireRequestByEndpointFn != nil {
+		return rc.AcquireRequestByEndpointFn(reqRouteInfo.Endpoint, reqRouteInfo.RemoteAddr)
+	}
+	if rc.AcquireRequestFn != nil {
+		return rc.AcquireRequestFn(reqRouteInfo.RemoteAddr)
+	}
+	return func() {}, nil
+}
+
+// affinityKey keys the affinity tokens, so the cookies don't reveal the names
+// of the endpoints. It is random unless SetAffinitySecret is called.
+var affinityKey = func() []byte {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	return key
+}()
+
+// SetAffinitySecret derives the key of the affinity tokens from secret, so
+// they stay valid across restarts with the same secret. It should be called
+// before any route is registered, an empty secret keeps the random key.
+func SetAffinitySecret(secret string) {
+	if secret == "" {
+		return
+	}
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte("frp affinity cookie"))
+	affinityKey = h.Sum(nil)
+}
+
+// AffinityToken returns the opaque value of the affinity cookie of endpoint.
+// Tokens change when the key set by SetAffinitySecret changes.
+func AffinityToken(endpoint string) string {
+	h := hmac.New(sha256.New, affinityKey)
+	h.Write([]byte(endpoint))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:16])
+}
+
+// getAffinity returns the token of the affinity cookie of req.
+func getAffinity(req *http.Request, cookieName string) string {
+	if cookieName == "" {
+		return ""
+	}
+	c, err := req.Cookie(cookieName)
+	if err != nil {
+		return ""
+	}
+	return c.Value
+}
+
 // getVhost tries to get vhost router by route policy.
 func (rp *HTTPReverseProxy) getVhost(domain, location, routeByHTTPUser string) (*Router, bool) {
 	findRouter := func(inDomain, inLocation, inRouteByHTTPUser string) (*Router, bool) {
@@ -245,27 +343,36 @@ func (rp *HTTPReverseProxy) getVhost(domain, location, routeByHTTPUser string) (
 	return nil, false
 }
 
-func (rp *HTTPReverseProxy) connectHandler(rw http.ResponseWriter, req *http.Request) {
+// connectHandler tunnels a CONNECT request, release is called once the
+// tunnel is closed.
+func (rp *HTTPReverseProxy) connectHandler(rw http.ResponseWriter, req *http.Request, release func()) {
 	hj, ok := rw.(http.Hijacker)
 	if !ok {
+		release()
 		rw.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
 	client, _, err := hj.Hijack()
 	if err != nil {
+		release()
 		rw.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	remote, err := rp.CreateConnection(req.Context().Value(RouteInfoKey).(*RequestRouteInfo), false)
+	reqRouteInfo := req.Context().Value(RouteInfoKey).(*RequestRouteInfo)
+	remote, err := rp.CreateConnection(reqRouteInfo, reqRouteInfo.Endpoint != "")
 	if err != nil {
+		release()
 		_ = NotFoundResponse().Write(client)
 		client.Close()
 		return
 	}
 	_ = req.Write(remote)
-	go libio.Join(remote, client)
+	go func() {
+		defer release()
+		libio.Join(remote, client)
+	}()
 }
 
 func parseBasicAuth(auth string) (username, password string, ok bool) {
@@ -325,11 +432,27 @@ func (rp *HTTPReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request)
 		http.Error(rw, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 		return
 	}
+	if !rp.CheckSource(domain, location, user, req.RemoteAddr) {
+		http.Error(rw, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
 
 	newreq := rp.injectRequestInfoToCtx(req)
+	rp.chooseEndpoint(newreq)
+	release, err := rp.acquireRequest(newreq)
+	if err != nil {
+		code := http.StatusServiceUnavailable
+		if errors.Is(err, ErrRateLimited) {
+			code = http.StatusTooManyRequests
+		}
+		http.Error(rw, http.StatusText(code), code)
+		return
+	}
+
 	if req.Method == http.MethodConnect {
-		rp.connectHandler(rw, newreq)
+		rp.connectHandler(rw, newreq, release)
 	} else {
+		defer release()
 		rp.proxy.ServeHTTP(rw, newreq)
 	}
 }
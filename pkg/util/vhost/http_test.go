@@ -0,0 +1,70 @@
+package vhost
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAffinityToken(t *testing.T) {
+	require := require.New(t)
+	key := affinityKey
+	defer func() { affinityKey = key }()
+
+	SetAffinitySecret("")
+	require.Equal(key, affinityKey)
+
+	// the same secret gives the same tokens, as after a restart
+	SetAffinitySecret("token")
+	token := AffinityToken("a")
+	affinityKey = key
+	require.NotEqual(token, AffinityToken("a"))
+	SetAffinitySecret("token")
+	require.Equal(token, AffinityToken("a"))
+	require.NotEqual(token, AffinityToken("b"))
+}
+
+func TestHTTPReverseProxyAcquireRequest(t *testing.T) {
+	require := require.New(t)
+	rp := NewHTTPReverseProxy(HTTPReverseProxyOptions{}, NewRouters())
+
+	var (
+		acquireErr error
+		released   int
+	)
+	err := rp.Register(RouteConfig{
+		Domain: "example.com",
+		CreateConnFn: func(string) (net.Conn, error) {
+			return nil, errors.New("no work conn")
+		},
+		AcquireRequestFn: func(remoteAddr string) (func(), error) {
+			require.Equal("1.2.3.4:5678", remoteAddr)
+			if acquireErr != nil {
+				return nil, acquireErr
+			}
+			return func() { released++ }, nil
+		},
+	})
+	require.NoError(err)
+
+	serve := func() int {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		req.RemoteAddr = "1.2.3.4:5678"
+		rec := httptest.NewRecorder()
+		rp.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	require.Equal(http.StatusNotFound, serve())
+	require.Equal(1, released)
+
+	acquireErr = errors.Join(errors.New("over the rate"), ErrRateLimited)
+	require.Equal(http.StatusTooManyRequests, serve())
+	acquireErr = errors.New("too many connections")
+	require.Equal(http.StatusServiceUnavailable, serve())
+	require.Equal(1, released)
+}
@@ -100,7 +100,9 @@ func (v *Muxer) SetRewriteHostFunc(f hostRewriteFunc) *Muxer {
 	return v
 }
 
-type ChooseEndpointFunc func() (string, error)
+// ChooseEndpointFunc chooses the endpoint serving a request, affinity is the
+// AffinityToken in the affinity cookie of the request if there is one.
+type ChooseEndpointFunc func(remoteAddr, affinity string) (string, error)
 
 type CreateConnFunc func(remoteAddr string) (net.Conn, error)
 
@@ -116,6 +118,18 @@ type RouteConfig struct {
 	Headers         map[string]string
 	ResponseHeaders map[string]string
 	RouteByHTTPUser string
+	// AffinityCookie is the name of the cookie that keeps a client on the
+	// endpoint chosen for it, only used with ChooseEndpointFn.
+	AffinityCookie string
+	// AllowSourceFn reports whether a client address may use the route, if
+	// it is not nil.
+	AllowSourceFn func(remoteAddr string) bool
+	// AcquireRequestFn admits a request from a client address, if it is not
+	// nil. It returns ErrRateLimited for requests over a rate limit.
+	AcquireRequestFn func(remoteAddr string) (release func(), err error)
+	// AcquireRequestByEndpointFn admits a request against the endpoint chosen
+	// by ChooseEndpointFn, it is used instead of AcquireRequestFn then.
+	AcquireRequestByEndpointFn func(endpoint, remoteAddr string) (release func(), err error)
 
 	CreateConnFn           CreateConnFunc
 	ChooseEndpointFn       ChooseEndpointFunc
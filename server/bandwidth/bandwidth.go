@@ -0,0 +1,100 @@
+package bandwidth
+
+import (
+	"sync"
+
+	"golang.org/x/time/rate"
+
+	v1 "github.com/fatedier/frp/pkg/config/v1"
+	"github.com/fatedier/frp/pkg/util/limit"
+)
+
+// Limiter limits the traffic of all proxies sharing it. A nil limiter means
+// no limit in that direction.
+type Limiter struct {
+	Upload   *rate.Limiter
+	Download *rate.Limiter
+}
+
+func newLimiter(cfg v1.AggregateBandwidthLimit) *Limiter {
+	l := &Limiter{
+		Upload:   limit.NewLimiter(cfg.Upload.Bytes(), cfg.UploadBurst.Bytes()),
+		Download: limit.NewLimiter(cfg.Download.Bytes(), cfg.DownloadBurst.Bytes()),
+	}
+	if l.Upload == nil && l.Download == nil {
+		return nil
+	}
+	return l
+}
+
+type sharedLimiter struct {
+	*Limiter
+	refs int
+}
+
+// Manager hands out the limiters shared by the proxies of a client or a user.
+// A limiter is dropped when the last proxy using it releases it.
+type Manager struct {
+	perClient v1.AggregateBandwidthLimit
+	perUser   v1.AggregateBandwidthLimit
+	users     map[string]v1.AggregateBandwidthLimit
+
+	limiters map[string]*sharedLimiter
+	mu       sync.Mutex
+}
+
+func NewManager(cfg v1.BandwidthLimitServerConfig) *Manager {
+	m := &Manager{
+		perClient: cfg.PerClient,
+		perUser:   cfg.PerUser,
+		users:     make(map[string]v1.AggregateBandwidthLimit),
+		limiters:  make(map[string]*sharedLimiter),
+	}
+	for _, u := range cfg.Users {
+		m.users[u.User] = u.AggregateBandwidthLimit
+	}
+	return m
+}
+
+// Client returns the limiter of the client with runID, or nil if clients have
+// no limit. release should be called when the proxy using it is closed.
+func (m *Manager) Client(runID string) (l *Limiter, release func()) {
+	return m.acquire("client/"+runID, m.perClient)
+}
+
+// User returns the limiter of user, or nil if the user has no limit. release
+// should be called when the proxy using it is closed.
+func (m *Manager) User(user string) (l *Limiter, release func()) {
+	cfg, ok := m.users[user]
+	if !ok {
+		cfg = m.perUser
+	}
+	return m.acquire("user/"+user, cfg)
+}
+
+func (m *Manager) acquire(key string, cfg v1.AggregateBandwidthLimit) (*Limiter, func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sl, ok := m.limiters[key]
+	if !ok {
+		l := newLimiter(cfg)
+		if l == nil {
+			return nil, func() {}
+		}
+		sl = &sharedLimiter{Limiter: l}
+		m.limiters[key] = sl
+	}
+	sl.refs++
+
+	var once sync.Once
+	return sl.Limiter, func() {
+		once.Do(func() {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			sl.refs--
+			if sl.refs == 0 && m.limiters[key] == sl {
+				delete(m.limiters, key)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package bandwidth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/fatedier/frp/pkg/config/types"
+	v1 "github.com/fatedier/frp/pkg/config/v1"
+)
+
+func TestManager(t *testing.T) {
+	require := require.New(t)
+	quantity := func(s string) types.BandwidthQuantity {
+		q, err := types.NewBandwidthQuantity(s)
+		require.NoError(err)
+		return q
+	}
+	burst, err := types.NewByteQuantity("4MB")
+	require.NoError(err)
+
+	m := NewManager(v1.BandwidthLimitServerConfig{
+		PerClient: v1.AggregateBandwidthLimit{Upload: quantity("1MB")},
+		Users: []v1.UserBandwidthLimit{{
+			User:                    "alice",
+			AggregateBandwidthLimit: v1.AggregateBandwidthLimit{Download: quantity("2MB"), DownloadBurst: burst},
+		}},
+	})
+
+	// proxies of the same client share the limiter until all release it
+	l1, release1 := m.Client("run1")
+	l2, release2 := m.Client("run1")
+	require.Same(l1, l2)
+	require.Nil(l1.Download)
+	require.Equal(1024*1024, l1.Upload.Burst())
+	release1()
+	release1()
+	l3, release3 := m.Client("run1")
+	require.Same(l1, l3)
+	release2()
+	release3()
+	l4, release4 := m.Client("run1")
+	require.NotSame(l1, l4)
+	release4()
+
+	l, release := m.User("alice")
+	require.Nil(l.Upload)
+	require.Equal(4*1024*1024, l.Download.Burst())
+	release()
+	l, release = m.User("bob")
+	require.Nil(l)
+	release()
+}
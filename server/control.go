@@ -117,6 +117,9 @@ type Control struct {
 	// login message
 	loginMsg *msg.Login
 
+	// features negotiated with the client
+	features msg.Features
+
 	// control connection
 	conn net.Conn
 
@@ -178,6 +181,7 @@ func NewControl(
 		poolCount:     poolCount,
 		portsUsedNum:  0,
 		runID:         loginMsg.RunID,
+		features:      msg.NegotiateFeatures(msg.SupportedFeatures(), loginMsg.Features),
 		serverCfg:     serverCfg,
 		xl:            xlog.FromContextSafe(ctx),
 		ctx:           ctx,
@@ -194,6 +198,7 @@ func NewControl(
 	} else {
 		ctl.msgDispatcher = msg.NewDispatcher(ctl.conn)
 	}
+	ctl.msgDispatcher.SetCodec(ctl.features.MsgCodec())
 	ctl.registerMsgHandlers()
 	ctl.msgTransporter = transport.NewMessageTransporter(ctl.msgDispatcher.SendChannel())
 	return ctl, nil
@@ -202,9 +207,10 @@ func NewControl(
 // Start send a login success message to client and start working.
 func (ctl *Control) Start() {
 	loginRespMsg := &msg.LoginResp{
-		Version: version.Full(),
-		RunID:   ctl.runID,
-		Error:   "",
+		Version:  version.Full(),
+		RunID:    ctl.runID,
+		Error:    "",
+		Features: ctl.features.List(),
 	}
 	_ = msg.WriteMsg(ctl.conn, loginRespMsg)
 
@@ -452,7 +458,7 @@ func (ctl *Control) RegisterProxy(pxyMsg *msg.NewProxy) (remoteAddr string, err
 
 	// NewProxy will return an interface Proxy.
 	// In fact, it creates different proxies based on the proxy type. We just call run() here.
-	pxy, err := proxy.NewProxy(ctl.ctx, &proxy.Options{
+	options := &proxy.Options{
 		UserInfo:           userInfo,
 		LoginMsg:           ctl.loginMsg,
 		PoolCount:          ctl.poolCount,
@@ -460,7 +466,16 @@ func (ctl *Control) RegisterProxy(pxyMsg *msg.NewProxy) (remoteAddr string, err
 		GetWorkConnFn:      ctl.GetWorkConn,
 		Configurer:         pxyConf,
 		ServerCfg:          ctl.serverCfg,
-	})
+		Features:           ctl.features,
+	}
+	if ctl.features.Has(msg.FeatureUDPBinaryFraming) {
+		options.UDPFraming = pxyMsg.UDPFraming
+	}
+	// work connections are streams of the same QUIC connection as the control
+	if pxyMsg.UDPDatagram && ctl.features.Has(msg.FeatureUDPDatagram) {
+		options.Datagrams = netpkg.GetDatagramSession(ctl.conn)
+	}
+	pxy, err := proxy.NewProxy(ctl.ctx, options)
 	if err != nil {
 		return remoteAddr, err
 	}
@@ -523,6 +538,11 @@ func (ctl *Control) CloseProxy(closeMsg *msg.CloseProxy) (err error) {
 		ctl.portsUsedNum -= pxy.GetUsedPortsNum()
 	}
 	pxy.Close()
+	// without a drain timeout, the connections in use run until they finish
+	drainTimeout := min(closeMsg.DrainTimeout, ctl.serverCfg.Transport.MaxDrainTimeoutSeconds)
+	if drainTimeout > 0 {
+		pxy.CloseWorkConns(time.Duration(drainTimeout) * time.Second)
+	}
 	ctl.pxyManager.Del(pxy.GetName())
 	delete(ctl.proxies, closeMsg.ProxyName)
 	ctl.mu.Unlock()
@@ -15,11 +15,15 @@
 package controller
 
 import (
+	"net"
+
 	plugin "github.com/fatedier/frp/pkg/plugin/server"
 	"github.com/fatedier/frp/pkg/util/tcpmux"
 	"github.com/fatedier/frp/pkg/util/vhost"
+	"github.com/fatedier/frp/server/bandwidth"
 	"github.com/fatedier/frp/server/group"
 	"github.com/fatedier/frp/server/ports"
+	"github.com/fatedier/frp/server/quota"
 	"github.com/fatedier/frp/server/visitor"
 )
 
@@ -43,6 +47,15 @@ type ResourceController struct {
 	// Manage all UDP ports
 	UDPPortManager *ports.Manager
 
+	// Networks of the load balancers whose PROXY protocol headers are accepted
+	ProxyProtocolTrustedNets []*net.IPNet
+
+	// Track the traffic quotas of proxies and users
+	TrafficQuotaManager *quota.Manager
+
+	// Bandwidth limiters shared by the proxies of a client or user
+	BandwidthLimitManager *bandwidth.Manager
+
 	// For HTTP proxies, forwarding HTTP requests
 	HTTPReverseProxy *vhost.HTTPReverseProxy
 
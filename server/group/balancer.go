@@ -0,0 +1,191 @@
+package group
+
+import (
+	"cmp"
+	"hash/fnv"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	v1 "github.com/fatedier/frp/pkg/config/v1"
+	netpkg "github.com/fatedier/frp/pkg/util/net"
+)
+
+// virtual nodes per unit of weight on the consistent hash ring
+const hashReplicas = 160
+
+type member struct {
+	name   string
+	weight int
+
+	// active is the number of open connections to this member
+	active atomic.Int64
+	// current is the running weight of smooth weighted round-robin
+	current int
+}
+
+// track counts conn as an active connection of the member until it is closed.
+func (m *member) track(c net.Conn) net.Conn {
+	m.active.Add(1)
+	return netpkg.WrapCloseNotifyConn(c, func() {
+		m.active.Add(-1)
+	})
+}
+
+type ringNode struct {
+	hash   uint32
+	member *member
+}
+
+// balancer chooses the member of a group that serves a new connection by the
+// load balance strategy of the group.
+type balancer struct {
+	strategy v1.LoadBalanceStrategy
+
+	members []*member
+	ring    []ringNode
+	index   uint64
+	mu      sync.Mutex
+}
+
+func newBalancer(strategy v1.LoadBalanceStrategy) *balancer {
+	return &balancer{
+		strategy: cmp.Or(strategy, v1.LoadBalanceRoundRobin),
+	}
+}
+
+// sameStrategy reports whether a proxy joining the group asks for the same
+// strategy as the group.
+func (b *balancer) sameStrategy(strategy v1.LoadBalanceStrategy) bool {
+	return b.strategy == cmp.Or(strategy, v1.LoadBalanceRoundRobin)
+}
+
+func (b *balancer) add(name string, weight int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.members = append(b.members, &member{
+		name:   name,
+		weight: min(max(weight, 1), v1.MaxLoadBalanceWeight),
+	})
+	b.buildRing()
+}
+
+func (b *balancer) remove(name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.members = slices.DeleteFunc(b.members, func(m *member) bool {
+		return m.name == name
+	})
+	b.buildRing()
+}
+
+func (b *balancer) len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.members)
+}
+
+func (b *balancer) get(name string) *member {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, m := range b.members {
+		if m.name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// pick returns the member for a connection from srcAddr, or nil if the group
+// is empty.
+func (b *balancer) pick(srcAddr string) *member {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.members) == 0 {
+		return nil
+	}
+
+	switch b.strategy {
+	case v1.LoadBalanceRandom:
+		return b.members[rand.IntN(len(b.members))]
+	case v1.LoadBalanceLeastConn:
+		// start from the next member in turn to spread ties evenly
+		start := int(b.index % uint64(len(b.members)))
+		b.index++
+		var best *member
+		for i := range b.members {
+			m := b.members[(start+i)%len(b.members)]
+			if best == nil || m.active.Load() < best.active.Load() {
+				best = m
+			}
+		}
+		return best
+	case v1.LoadBalanceWeighted:
+		// smooth weighted round-robin, which interleaves the members instead
+		// of sending bursts to the heaviest one
+		total := 0
+		var best *member
+		for _, m := range b.members {
+			m.current += m.weight
+			total += m.weight
+			if best == nil || m.current > best.current {
+				best = m
+			}
+		}
+		best.current -= total
+		return best
+	case v1.LoadBalanceSourceHash:
+		h := hashKey(sourceIP(srcAddr))
+		i, _ := slices.BinarySearchFunc(b.ring, h, func(n ringNode, h uint32) int {
+			return cmp.Compare(n.hash, h)
+		})
+		if i == len(b.ring) {
+			i = 0
+		}
+		return b.ring[i].member
+	default:
+		m := b.members[b.index%uint64(len(b.members))]
+		b.index++
+		return m
+	}
+}
+
+func (b *balancer) buildRing() {
+	if b.strategy != v1.LoadBalanceSourceHash {
+		return
+	}
+	b.ring = b.ring[:0]
+	for _, m := range b.members {
+		for i := range m.weight * hashReplicas {
+			b.ring = append(b.ring, ringNode{
+				hash:   hashKey(m.name + "#" + strconv.Itoa(i)),
+				member: m,
+			})
+		}
+	}
+	slices.SortFunc(b.ring, func(a, b ringNode) int {
+		return cmp.Compare(a.hash, b.hash)
+	})
+}
+
+// sourceIP strips the port from srcAddr, so that all connections from a client
+// hash to the same member.
+func sourceIP(srcAddr string) string {
+	if ap, err := netip.ParseAddrPort(srcAddr); err == nil {
+		return ap.Addr().Unmap().String()
+	}
+	if host, _, err := net.SplitHostPort(srcAddr); err == nil {
+		return host
+	}
+	return srcAddr
+}
+
+func hashKey(s string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(s))
+	return h.Sum32()
+}
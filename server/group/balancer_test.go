@@ -0,0 +1,115 @@
+package group
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/fatedier/frp/pkg/config/v1"
+)
+
+func pickN(b *balancer, n int, srcAddr string) map[string]int {
+	counts := make(map[string]int)
+	for range n {
+		counts[b.pick(srcAddr).name]++
+	}
+	return counts
+}
+
+func TestBalancerRoundRobin(t *testing.T) {
+	require := require.New(t)
+	b := newBalancer("")
+	require.Nil(b.pick("1.1.1.1:1000"))
+
+	b.add("a", 0)
+	b.add("b", 0)
+	b.add("c", 0)
+	require.Equal(map[string]int{"a": 2, "b": 2, "c": 2}, pickN(b, 6, ""))
+
+	b.remove("b")
+	require.Equal(map[string]int{"a": 2, "c": 2}, pickN(b, 4, ""))
+}
+
+func TestBalancerWeighted(t *testing.T) {
+	require := require.New(t)
+	b := newBalancer(v1.LoadBalanceWeighted)
+	b.add("a", 5)
+	b.add("b", 1)
+	b.add("c", 1)
+
+	var seq string
+	for range 7 {
+		seq += b.pick("").name
+	}
+	// smooth weighted round-robin interleaves the light members
+	require.Equal("aabacaa", seq)
+}
+
+func TestBalancerLeastConn(t *testing.T) {
+	require := require.New(t)
+	b := newBalancer(v1.LoadBalanceLeastConn)
+	b.add("a", 0)
+	b.add("b", 0)
+
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	m := b.pick("")
+	tracked := m.track(c1)
+	other := "a"
+	if m.name == "a" {
+		other = "b"
+	}
+	for range 3 {
+		require.Equal(other, b.pick("").name)
+	}
+
+	tracked.Close()
+	require.EqualValues(0, m.active.Load())
+	require.Len(pickN(b, 2, ""), 2)
+}
+
+func TestBalancerSourceHash(t *testing.T) {
+	require := require.New(t)
+	b := newBalancer(v1.LoadBalanceSourceHash)
+	b.add("a", 0)
+	b.add("b", 0)
+	b.add("c", 0)
+
+	// the port of the source address does not matter
+	first := b.pick("10.0.0.1:1000").name
+	for _, addr := range []string{"10.0.0.1:2000", "10.0.0.1", "[::ffff:10.0.0.1]:3000"} {
+		require.Equal(first, b.pick(addr).name)
+	}
+
+	// removing another member keeps the clients of the remaining ones
+	before := make(map[string]string)
+	for i := range 256 {
+		addr := net.JoinHostPort(net.IPv4(192, 168, 0, byte(i)).String(), "80")
+		before[addr] = b.pick(addr).name
+	}
+	removed := "b"
+	if first == removed {
+		removed = "c"
+	}
+	b.remove(removed)
+	for addr, name := range before {
+		if name != removed {
+			require.Equal(name, b.pick(addr).name, addr)
+		}
+	}
+}
+
+func TestBalancerWeightLimit(t *testing.T) {
+	require := require.New(t)
+	b := newBalancer(v1.LoadBalanceSourceHash)
+	b.add("a", 10000000)
+	require.Len(b.ring, v1.MaxLoadBalanceWeight*hashReplicas)
+}
+
+func TestBalancerSameStrategy(t *testing.T) {
+	require := require.New(t)
+	require.True(newBalancer("").sameStrategy(v1.LoadBalanceRoundRobin))
+	require.True(newBalancer(v1.LoadBalanceRoundRobin).sameStrategy(""))
+	require.False(newBalancer(v1.LoadBalanceRandom).sameStrategy(""))
+}
@@ -4,8 +4,8 @@ import (
 	"fmt"
 	"net"
 	"sync"
-	"sync/atomic"
 
+	v1 "github.com/fatedier/frp/pkg/config/v1"
 	"github.com/fatedier/frp/pkg/util/vhost"
 )
 
@@ -28,10 +28,11 @@ func NewHTTPGroupController(vhostRouter *vhost.Routers) *HTTPGroupController {
 }
 
 func (ctl *HTTPGroupController) Register(
-	proxyName, group, groupKey string,
+	proxyName string,
+	lb v1.LoadBalancerConfig,
 	routeConfig vhost.RouteConfig,
 ) (err error) {
-	indexKey := group
+	indexKey := lb.Group
 	ctl.mu.Lock()
 	g, ok := ctl.groups[indexKey]
 	if !ok {
@@ -40,7 +41,7 @@ func (ctl *HTTPGroupController) Register(
 	}
 	ctl.mu.Unlock()
 
-	return g.Register(proxyName, group, groupKey, routeConfig)
+	return g.Register(proxyName, lb, routeConfig)
 }
 
 func (ctl *HTTPGroupController) UnRegister(proxyName, group string, _ vhost.RouteConfig) {
@@ -64,25 +65,31 @@ type HTTPGroup struct {
 	domain          string
 	location        string
 	routeByHTTPUser string
+	affinityCookie  string
 
 	// CreateConnFuncs indexed by proxy name
 	createFuncs map[string]vhost.CreateConnFunc
-	pxyNames    []string
-	index       uint64
-	ctl         *HTTPGroupController
-	mu          sync.RWMutex
+	// AcquireRequestFns indexed by proxy name
+	acquireFuncs map[string]func(remoteAddr string) (func(), error)
+	// proxy names indexed by affinity token
+	affinityTokens map[string]string
+	balancer       *balancer
+	ctl            *HTTPGroupController
+	mu             sync.RWMutex
 }
 
 func NewHTTPGroup(ctl *HTTPGroupController) *HTTPGroup {
 	return &HTTPGroup{
-		createFuncs: make(map[string]vhost.CreateConnFunc),
-		pxyNames:    make([]string, 0),
-		ctl:         ctl,
+		createFuncs:    make(map[string]vhost.CreateConnFunc),
+		acquireFuncs:   make(map[string]func(string) (func(), error)),
+		affinityTokens: make(map[string]string),
+		ctl:            ctl,
 	}
 }
 
 func (g *HTTPGroup) Register(
-	proxyName, group, groupKey string,
+	proxyName string,
+	lb v1.LoadBalancerConfig,
 	routeConfig vhost.RouteConfig,
 ) (err error) {
 	g.mu.Lock()
@@ -93,23 +100,30 @@ func (g *HTTPGroup) Register(
 		tmp.CreateConnFn = g.createConn
 		tmp.ChooseEndpointFn = g.chooseEndpoint
 		tmp.CreateConnByEndpointFn = g.createConnByEndpoint
+		// requests are admitted by the member chosen for them
+		tmp.AcquireRequestFn = nil
+		tmp.AcquireRequestByEndpointFn = g.acquireRequestByEndpoint
+		tmp.AffinityCookie = lb.AffinityCookie
 		err = g.ctl.vhostRouter.Add(routeConfig.Domain, routeConfig.Location, routeConfig.RouteByHTTPUser, &tmp)
 		if err != nil {
 			return
 		}
 
-		g.group = group
-		g.groupKey = groupKey
+		g.group = lb.Group
+		g.groupKey = lb.GroupKey
 		g.domain = routeConfig.Domain
 		g.location = routeConfig.Location
 		g.routeByHTTPUser = routeConfig.RouteByHTTPUser
+		g.affinityCookie = lb.AffinityCookie
+		g.balancer = newBalancer(lb.Strategy)
 	} else {
-		if g.group != group || g.domain != routeConfig.Domain ||
-			g.location != routeConfig.Location || g.routeByHTTPUser != routeConfig.RouteByHTTPUser {
+		if g.group != lb.Group || g.domain != routeConfig.Domain ||
+			g.location != routeConfig.Location || g.routeByHTTPUser != routeConfig.RouteByHTTPUser ||
+			g.affinityCookie != lb.AffinityCookie || !g.balancer.sameStrategy(lb.Strategy) {
 			err = ErrGroupParamsInvalid
 			return
 		}
-		if g.groupKey != groupKey {
+		if g.groupKey != lb.GroupKey {
 			err = ErrGroupAuthFailed
 			return
 		}
@@ -119,7 +133,13 @@ func (g *HTTPGroup) Register(
 		return
 	}
 	g.createFuncs[proxyName] = routeConfig.CreateConnFn
-	g.pxyNames = append(g.pxyNames, proxyName)
+	if routeConfig.AcquireRequestFn != nil {
+		g.acquireFuncs[proxyName] = routeConfig.AcquireRequestFn
+	}
+	if g.affinityCookie != "" {
+		g.affinityTokens[vhost.AffinityToken(proxyName)] = proxyName
+	}
+	g.balancer.add(proxyName, lb.Weight)
 	return nil
 }
 
@@ -127,12 +147,9 @@ func (g *HTTPGroup) UnRegister(proxyName string) (isEmpty bool) {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 	delete(g.createFuncs, proxyName)
-	for i, name := range g.pxyNames {
-		if name == proxyName {
-			g.pxyNames = append(g.pxyNames[:i], g.pxyNames[i+1:]...)
-			break
-		}
-	}
+	delete(g.acquireFuncs, proxyName)
+	delete(g.affinityTokens, vhost.AffinityToken(proxyName))
+	g.balancer.remove(proxyName)
 
 	if len(g.createFuncs) == 0 {
 		isEmpty = true
@@ -142,47 +159,46 @@ func (g *HTTPGroup) UnRegister(proxyName string) (isEmpty bool) {
 }
 
 func (g *HTTPGroup) createConn(remoteAddr string) (net.Conn, error) {
-	var f vhost.CreateConnFunc
-	newIndex := atomic.AddUint64(&g.index, 1)
+	name, err := g.chooseEndpoint(remoteAddr, "")
+	if err != nil {
+		return nil, err
+	}
+	return g.createConnByEndpoint(name, remoteAddr)
+}
 
+// chooseEndpoint keeps the client on the proxy with the affinity token while
+// it is in the group, and falls back to the strategy of the group otherwise.
+func (g *HTTPGroup) chooseEndpoint(remoteAddr, affinity string) (string, error) {
 	g.mu.RLock()
+	name, ok := g.affinityTokens[affinity]
 	group := g.group
 	domain := g.domain
 	location := g.location
 	routeByHTTPUser := g.routeByHTTPUser
-	if len(g.pxyNames) > 0 {
-		name := g.pxyNames[int(newIndex)%len(g.pxyNames)]
-		f = g.createFuncs[name]
-	}
 	g.mu.RUnlock()
 
-	if f == nil {
-		return nil, fmt.Errorf("no CreateConnFunc for http group [%s], domain [%s], location [%s], routeByHTTPUser [%s]",
+	if ok && g.balancer.get(name) != nil {
+		return name, nil
+	}
+	m := g.balancer.pick(remoteAddr)
+	if m == nil {
+		return "", fmt.Errorf("no healthy endpoint for http group [%s], domain [%s], location [%s], routeByHTTPUser [%s]",
 			group, domain, location, routeByHTTPUser)
 	}
-
-	return f(remoteAddr)
+	return m.name, nil
 }
 
-func (g *HTTPGroup) chooseEndpoint() (string, error) {
-	newIndex := atomic.AddUint64(&g.index, 1)
-	name := ""
-
+// acquireRequestByEndpoint admits a request against the limits of the member
+// chosen for it.
+func (g *HTTPGroup) acquireRequestByEndpoint(endpoint, remoteAddr string) (func(), error) {
 	g.mu.RLock()
-	group := g.group
-	domain := g.domain
-	location := g.location
-	routeByHTTPUser := g.routeByHTTPUser
-	if len(g.pxyNames) > 0 {
-		name = g.pxyNames[int(newIndex)%len(g.pxyNames)]
-	}
+	f := g.acquireFuncs[endpoint]
 	g.mu.RUnlock()
 
-	if name == "" {
-		return "", fmt.Errorf("no healthy endpoint for http group [%s], domain [%s], location [%s], routeByHTTPUser [%s]",
-			group, domain, location, routeByHTTPUser)
+	if f == nil {
+		return func() {}, nil
 	}
-	return name, nil
+	return f(remoteAddr)
 }
 
 func (g *HTTPGroup) createConnByEndpoint(endpoint, remoteAddr string) (net.Conn, error) {
@@ -191,8 +207,13 @@ func (g *HTTPGroup) createConnByEndpoint(endpoint, remoteAddr string) (net.Conn,
 	f = g.createFuncs[endpoint]
 	g.mu.RUnlock()
 
-	if f == nil {
+	m := g.balancer.get(endpoint)
+	if f == nil || m == nil {
 		return nil, fmt.Errorf("no CreateConnFunc for endpoint [%s] in group [%s]", endpoint, g.group)
 	}
-	return f(remoteAddr)
+	conn, err := f(remoteAddr)
+	if err != nil {
+		return nil, err
+	}
+	return m.track(conn), nil
 }
@@ -0,0 +1,99 @@
+package group
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	v1 "github.com/fatedier/frp/pkg/config/v1"
+	"github.com/fatedier/frp/pkg/util/vhost"
+)
+
+func TestHTTPGroupAffinity(t *testing.T) {
+	require := require.New(t)
+	ctl := NewHTTPGroupController(vhost.NewRouters())
+	routeConfig := vhost.RouteConfig{
+		Domain: "example.com",
+		CreateConnFn: func(string) (net.Conn, error) {
+			c, _ := net.Pipe()
+			return c, nil
+		},
+	}
+	lb := v1.LoadBalancerConfig{Group: "web", AffinityCookie: "frp_affinity"}
+	require.NoError(ctl.Register("a", lb, routeConfig))
+	require.NoError(ctl.Register("b", lb, routeConfig))
+
+	lb.AffinityCookie = ""
+	require.ErrorIs(ctl.Register("c", lb, routeConfig), ErrGroupParamsInvalid)
+
+	g := ctl.groups["web"]
+	for range 4 {
+		endpoint, err := g.chooseEndpoint("1.1.1.1:80", vhost.AffinityToken("b"))
+		require.NoError(err)
+		require.Equal("b", endpoint)
+	}
+	require.NotEqual(vhost.AffinityToken("a"), vhost.AffinityToken("b"))
+
+	// fall back to the strategy once the member is gone
+	ctl.UnRegister("b", "web", routeConfig)
+	endpoint, err := g.chooseEndpoint("1.1.1.1:80", vhost.AffinityToken("b"))
+	require.NoError(err)
+	require.Equal("a", endpoint)
+}
+
+func TestHTTPGroupAcquireRequest(t *testing.T) {
+	require := require.New(t)
+	routers := vhost.NewRouters()
+	ctl := NewHTTPGroupController(routers)
+	rp := vhost.NewHTTPReverseProxy(vhost.HTTPReverseProxyOptions{}, routers)
+
+	admitted := map[string]int{}
+	member := func(name string, limit int) vhost.RouteConfig {
+		return vhost.RouteConfig{
+			Domain: "example.com",
+			CreateConnFn: func(string) (net.Conn, error) {
+				return nil, errors.New("no work conn")
+			},
+			AcquireRequestFn: func(string) (func(), error) {
+				if admitted[name] >= limit {
+					return nil, fmt.Errorf("%s is over its rate: %w", name, vhost.ErrRateLimited)
+				}
+				admitted[name]++
+				return func() {}, nil
+			},
+		}
+	}
+	lb := v1.LoadBalancerConfig{Group: "web", AffinityCookie: "frp_affinity"}
+	require.NoError(ctl.Register("a", lb, member("a", 1)))
+	require.NoError(ctl.Register("b", lb, member("b", 2)))
+
+	serve := func(endpoint string) int {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		req.AddCookie(&http.Cookie{Name: "frp_affinity", Value: vhost.AffinityToken(endpoint)})
+		rec := httptest.NewRecorder()
+		rp.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// each member admits requests against its own limits
+	require.Equal(http.StatusNotFound, serve("a"))
+	require.Equal(http.StatusTooManyRequests, serve("a"))
+	require.Equal(http.StatusNotFound, serve("b"))
+	require.Equal(http.StatusNotFound, serve("b"))
+	require.Equal(http.StatusTooManyRequests, serve("b"))
+	require.Equal(map[string]int{"a": 1, "b": 2}, admitted)
+
+	// the limits of a member are gone with it
+	ctl.UnRegister("b", "web", vhost.RouteConfig{})
+	require.Equal(http.StatusTooManyRequests, serve("b"))
+	require.Equal(map[string]int{"a": 1, "b": 2}, admitted)
+	ctl.UnRegister("a", "web", vhost.RouteConfig{})
+	require.NoError(ctl.Register("c", lb, member("c", 1)))
+	require.Equal(http.StatusNotFound, serve("a"))
+	require.Equal(map[string]int{"a": 1, "b": 2, "c": 1}, admitted)
+}
@@ -19,8 +19,8 @@ import (
 	"strconv"
 	"sync"
 
-	gerr "github.com/fatedier/golib/errors"
-
+	v1 "github.com/fatedier/frp/pkg/config/v1"
+	netpkg "github.com/fatedier/frp/pkg/util/net"
 	"github.com/fatedier/frp/server/ports"
 )
 
@@ -30,31 +30,34 @@ type TCPGroupCtl struct {
 
 	// portManager is used to manage port
 	portManager *ports.Manager
-	mu          sync.Mutex
+
+	proxyProtocolTrustedNets []*net.IPNet
+	mu                       sync.Mutex
 }
 
 // NewTCPGroupCtl return a new TcpGroupCtl
-func NewTCPGroupCtl(portManager *ports.Manager) *TCPGroupCtl {
+func NewTCPGroupCtl(portManager *ports.Manager, proxyProtocolTrustedNets []*net.IPNet) *TCPGroupCtl {
 	return &TCPGroupCtl{
-		groups:      make(map[string]*TCPGroup),
-		portManager: portManager,
+		groups:                   make(map[string]*TCPGroup),
+		portManager:              portManager,
+		proxyProtocolTrustedNets: proxyProtocolTrustedNets,
 	}
 }
 
 // Listen is the wrapper for TCPGroup's Listen
 // If there are no group, we will create one here
-func (tgc *TCPGroupCtl) Listen(proxyName string, group string, groupKey string,
+func (tgc *TCPGroupCtl) Listen(proxyName string, lb v1.LoadBalancerConfig,
 	addr string, port int,
 ) (l net.Listener, realPort int, err error) {
 	tgc.mu.Lock()
-	tcpGroup, ok := tgc.groups[group]
+	tcpGroup, ok := tgc.groups[lb.Group]
 	if !ok {
 		tcpGroup = NewTCPGroup(tgc)
-		tgc.groups[group] = tcpGroup
+		tgc.groups[lb.Group] = tcpGroup
 	}
 	tgc.mu.Unlock()
 
-	return tcpGroup.Listen(proxyName, group, groupKey, addr, port)
+	return tcpGroup.Listen(proxyName, lb, addr, port)
 }
 
 // RemoveGroup remove TCPGroup from controller
@@ -72,7 +75,7 @@ type TCPGroup struct {
 	port     int
 	realPort int
 
-	acceptCh chan net.Conn
+	balancer *balancer
 	tcpLn    net.Listener
 	lns      []*TCPGroupListener
 	ctl      *TCPGroupCtl
@@ -82,16 +85,15 @@ type TCPGroup struct {
 // NewTCPGroup return a new TCPGroup
 func NewTCPGroup(ctl *TCPGroupCtl) *TCPGroup {
 	return &TCPGroup{
-		lns:      make([]*TCPGroupListener, 0),
-		ctl:      ctl,
-		acceptCh: make(chan net.Conn),
+		lns: make([]*TCPGroupListener, 0),
+		ctl: ctl,
 	}
 }
 
 // Listen will return a new TCPGroupListener
 // if TCPGroup already has a listener, just add a new TCPGroupListener to the queues
 // otherwise, listen on the real address
-func (tg *TCPGroup) Listen(proxyName string, group string, groupKey string, addr string, port int) (ln *TCPGroupListener, realPort int, err error) {
+func (tg *TCPGroup) Listen(proxyName string, lb v1.LoadBalancerConfig, addr string, port int) (ln *TCPGroupListener, realPort int, err error) {
 	tg.mu.Lock()
 	defer tg.mu.Unlock()
 	if len(tg.lns) == 0 {
@@ -105,22 +107,21 @@ func (tg *TCPGroup) Listen(proxyName string, group string, groupKey string, addr
 			err = errRet
 			return
 		}
-		ln = newTCPGroupListener(group, tg, tcpLn.Addr())
+		tcpLn = netpkg.NewProxyProtocolListener(tcpLn, tg.ctl.proxyProtocolTrustedNets)
+		ln = newTCPGroupListener(proxyName, lb.Group, tg, tcpLn.Addr())
 
-		tg.group = group
-		tg.groupKey = groupKey
+		tg.group = lb.Group
+		tg.groupKey = lb.GroupKey
 		tg.addr = addr
 		tg.port = port
 		tg.realPort = realPort
+		tg.balancer = newBalancer(lb.Strategy)
 		tg.tcpLn = tcpLn
 		tg.lns = append(tg.lns, ln)
-		if tg.acceptCh == nil {
-			tg.acceptCh = make(chan net.Conn)
-		}
 		go tg.worker()
 	} else {
 		// address and port in the same group must be equal
-		if tg.group != group || tg.addr != addr {
+		if tg.group != lb.Group || tg.addr != addr || !tg.balancer.sameStrategy(lb.Strategy) {
 			err = ErrGroupParamsInvalid
 			return
 		}
@@ -128,14 +129,15 @@ func (tg *TCPGroup) Listen(proxyName string, group string, groupKey string, addr
 			err = ErrGroupDifferentPort
 			return
 		}
-		if tg.groupKey != groupKey {
+		if tg.groupKey != lb.GroupKey {
 			err = ErrGroupAuthFailed
 			return
 		}
-		ln = newTCPGroupListener(group, tg, tg.lns[0].Addr())
+		ln = newTCPGroupListener(proxyName, lb.Group, tg, tg.lns[0].Addr())
 		realPort = tg.realPort
 		tg.lns = append(tg.lns, ln)
 	}
+	tg.balancer.add(proxyName, lb.Weight)
 	return
 }
 
@@ -146,17 +148,43 @@ func (tg *TCPGroup) worker() {
 		if err != nil {
 			return
 		}
-		err = gerr.PanicToError(func() {
-			tg.acceptCh <- c
-		})
-		if err != nil {
+		// the address used by the balancer may come from a PROXY protocol
+		// header, don't block the listener while reading it
+		go tg.dispatch(c)
+	}
+}
+
+// dispatch hands c to the listener chosen by the balancer, choosing again if
+// that listener is closed in the meantime.
+func (tg *TCPGroup) dispatch(c net.Conn) {
+	for {
+		m := tg.balancer.pick(c.RemoteAddr().String())
+		if m == nil {
+			c.Close()
 			return
 		}
+		ln := tg.getListener(m.name)
+		if ln == nil {
+			continue
+		}
+		select {
+		case ln.acceptCh <- m.track(c):
+			return
+		case <-ln.closeCh:
+			m.active.Add(-1)
+		}
 	}
 }
 
-func (tg *TCPGroup) Accept() <-chan net.Conn {
-	return tg.acceptCh
+func (tg *TCPGroup) getListener(proxyName string) *TCPGroupListener {
+	tg.mu.Lock()
+	defer tg.mu.Unlock()
+	for _, ln := range tg.lns {
+		if ln.proxyName == proxyName {
+			return ln
+		}
+	}
+	return nil
 }
 
 // CloseListener remove the TCPGroupListener from the TCPGroup
@@ -169,8 +197,8 @@ func (tg *TCPGroup) CloseListener(ln *TCPGroupListener) {
 			break
 		}
 	}
+	tg.balancer.remove(ln.proxyName)
 	if len(tg.lns) == 0 {
-		close(tg.acceptCh)
 		tg.tcpLn.Close()
 		tg.ctl.portManager.Release(tg.realPort)
 		tg.ctl.RemoveGroup(tg.group)
@@ -179,32 +207,32 @@ func (tg *TCPGroup) CloseListener(ln *TCPGroupListener) {
 
 // TCPGroupListener
 type TCPGroupListener struct {
+	proxyName string
 	groupName string
 	group     *TCPGroup
 
-	addr    net.Addr
-	closeCh chan struct{}
+	addr     net.Addr
+	acceptCh chan net.Conn
+	closeCh  chan struct{}
 }
 
-func newTCPGroupListener(name string, group *TCPGroup, addr net.Addr) *TCPGroupListener {
+func newTCPGroupListener(proxyName, name string, group *TCPGroup, addr net.Addr) *TCPGroupListener {
 	return &TCPGroupListener{
+		proxyName: proxyName,
 		groupName: name,
 		group:     group,
 		addr:      addr,
+		acceptCh:  make(chan net.Conn),
 		closeCh:   make(chan struct{}),
 	}
 }
 
-// Accept will accept connections from TCPGroup
+// Accept will accept connections chosen for this listener from TCPGroup
 func (ln *TCPGroupListener) Accept() (c net.Conn, err error) {
-	var ok bool
 	select {
 	case <-ln.closeCh:
 		return nil, ErrListenerClosed
-	case c, ok = <-ln.group.Accept():
-		if !ok {
-			return nil, ErrListenerClosed
-		}
+	case c = <-ln.acceptCh:
 		return c, nil
 	}
 }
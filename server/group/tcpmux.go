@@ -20,8 +20,6 @@ import (
 	"net"
 	"sync"
 
-	gerr "github.com/fatedier/golib/errors"
-
 	v1 "github.com/fatedier/frp/pkg/config/v1"
 	"github.com/fatedier/frp/pkg/util/tcpmux"
 	"github.com/fatedier/frp/pkg/util/vhost"
@@ -48,20 +46,21 @@ func NewTCPMuxGroupCtl(tcpMuxHTTPConnectMuxer *tcpmux.HTTPConnectTCPMuxer) *TCPM
 // If there are no group, we will create one here
 func (tmgc *TCPMuxGroupCtl) Listen(
 	ctx context.Context,
-	multiplexer, group, groupKey string,
+	proxyName, multiplexer string,
+	lb v1.LoadBalancerConfig,
 	routeConfig vhost.RouteConfig,
 ) (l net.Listener, err error) {
 	tmgc.mu.Lock()
-	tcpMuxGroup, ok := tmgc.groups[group]
+	tcpMuxGroup, ok := tmgc.groups[lb.Group]
 	if !ok {
 		tcpMuxGroup = NewTCPMuxGroup(tmgc)
-		tmgc.groups[group] = tcpMuxGroup
+		tmgc.groups[lb.Group] = tcpMuxGroup
 	}
 	tmgc.mu.Unlock()
 
 	switch v1.TCPMultiplexerType(multiplexer) {
 	case v1.TCPMultiplexerHTTPConnect:
-		return tcpMuxGroup.HTTPConnectListen(ctx, group, groupKey, routeConfig)
+		return tcpMuxGroup.HTTPConnectListen(ctx, proxyName, lb, routeConfig)
 	default:
 		err = fmt.Errorf("unknown multiplexer [%s]", multiplexer)
 		return
@@ -84,7 +83,7 @@ type TCPMuxGroup struct {
 	username        string
 	password        string
 
-	acceptCh chan net.Conn
+	balancer *balancer
 	tcpMuxLn net.Listener
 	lns      []*TCPMuxGroupListener
 	ctl      *TCPMuxGroupCtl
@@ -94,9 +93,8 @@ type TCPMuxGroup struct {
 // NewTCPMuxGroup return a new TCPMuxGroup
 func NewTCPMuxGroup(ctl *TCPMuxGroupCtl) *TCPMuxGroup {
 	return &TCPMuxGroup{
-		lns:      make([]*TCPMuxGroupListener, 0),
-		ctl:      ctl,
-		acceptCh: make(chan net.Conn),
+		lns: make([]*TCPMuxGroupListener, 0),
+		ctl: ctl,
 	}
 }
 
@@ -105,7 +103,8 @@ func NewTCPMuxGroup(ctl *TCPMuxGroupCtl) *TCPMuxGroup {
 // otherwise, listen on the real address
 func (tmg *TCPMuxGroup) HTTPConnectListen(
 	ctx context.Context,
-	group, groupKey string,
+	proxyName string,
+	lb v1.LoadBalancerConfig,
 	routeConfig vhost.RouteConfig,
 ) (ln *TCPMuxGroupListener, err error) {
 	tmg.mu.Lock()
@@ -116,34 +115,34 @@ func (tmg *TCPMuxGroup) HTTPConnectListen(
 		if errRet != nil {
 			return nil, errRet
 		}
-		ln = newTCPMuxGroupListener(group, tmg, tcpMuxLn.Addr())
+		ln = newTCPMuxGroupListener(proxyName, lb.Group, tmg, tcpMuxLn.Addr())
 
-		tmg.group = group
-		tmg.groupKey = groupKey
+		tmg.group = lb.Group
+		tmg.groupKey = lb.GroupKey
 		tmg.domain = routeConfig.Domain
 		tmg.routeByHTTPUser = routeConfig.RouteByHTTPUser
 		tmg.username = routeConfig.Username
 		tmg.password = routeConfig.Password
+		tmg.balancer = newBalancer(lb.Strategy)
 		tmg.tcpMuxLn = tcpMuxLn
 		tmg.lns = append(tmg.lns, ln)
-		if tmg.acceptCh == nil {
-			tmg.acceptCh = make(chan net.Conn)
-		}
 		go tmg.worker()
 	} else {
 		// route config in the same group must be equal
-		if tmg.group != group || tmg.domain != routeConfig.Domain ||
+		if tmg.group != lb.Group || tmg.domain != routeConfig.Domain ||
 			tmg.routeByHTTPUser != routeConfig.RouteByHTTPUser ||
 			tmg.username != routeConfig.Username ||
-			tmg.password != routeConfig.Password {
+			tmg.password != routeConfig.Password ||
+			!tmg.balancer.sameStrategy(lb.Strategy) {
 			return nil, ErrGroupParamsInvalid
 		}
-		if tmg.groupKey != groupKey {
+		if tmg.groupKey != lb.GroupKey {
 			return nil, ErrGroupAuthFailed
 		}
-		ln = newTCPMuxGroupListener(group, tmg, tmg.lns[0].Addr())
+		ln = newTCPMuxGroupListener(proxyName, lb.Group, tmg, tmg.lns[0].Addr())
 		tmg.lns = append(tmg.lns, ln)
 	}
+	tmg.balancer.add(proxyName, lb.Weight)
 	return
 }
 
@@ -154,17 +153,41 @@ func (tmg *TCPMuxGroup) worker() {
 		if err != nil {
 			return
 		}
-		err = gerr.PanicToError(func() {
-			tmg.acceptCh <- c
-		})
-		if err != nil {
+		tmg.dispatch(c)
+	}
+}
+
+// dispatch hands c to the listener chosen by the balancer, choosing again if
+// that listener is closed in the meantime.
+func (tmg *TCPMuxGroup) dispatch(c net.Conn) {
+	for {
+		m := tmg.balancer.pick(c.RemoteAddr().String())
+		if m == nil {
+			c.Close()
 			return
 		}
+		ln := tmg.getListener(m.name)
+		if ln == nil {
+			continue
+		}
+		select {
+		case ln.acceptCh <- m.track(c):
+			return
+		case <-ln.closeCh:
+			m.active.Add(-1)
+		}
 	}
 }
 
-func (tmg *TCPMuxGroup) Accept() <-chan net.Conn {
-	return tmg.acceptCh
+func (tmg *TCPMuxGroup) getListener(proxyName string) *TCPMuxGroupListener {
+	tmg.mu.Lock()
+	defer tmg.mu.Unlock()
+	for _, ln := range tmg.lns {
+		if ln.proxyName == proxyName {
+			return ln
+		}
+	}
+	return nil
 }
 
 // CloseListener remove the TCPMuxGroupListener from the TCPMuxGroup
@@ -177,8 +200,8 @@ func (tmg *TCPMuxGroup) CloseListener(ln *TCPMuxGroupListener) {
 			break
 		}
 	}
+	tmg.balancer.remove(ln.proxyName)
 	if len(tmg.lns) == 0 {
-		close(tmg.acceptCh)
 		tmg.tcpMuxLn.Close()
 		tmg.ctl.RemoveGroup(tmg.group)
 	}
@@ -186,32 +209,32 @@ func (tmg *TCPMuxGroup) CloseListener(ln *TCPMuxGroupListener) {
 
 // TCPMuxGroupListener
 type TCPMuxGroupListener struct {
+	proxyName string
 	groupName string
 	group     *TCPMuxGroup
 
-	addr    net.Addr
-	closeCh chan struct{}
+	addr     net.Addr
+	acceptCh chan net.Conn
+	closeCh  chan struct{}
 }
 
-func newTCPMuxGroupListener(name string, group *TCPMuxGroup, addr net.Addr) *TCPMuxGroupListener {
+func newTCPMuxGroupListener(proxyName, name string, group *TCPMuxGroup, addr net.Addr) *TCPMuxGroupListener {
 	return &TCPMuxGroupListener{
+		proxyName: proxyName,
 		groupName: name,
 		group:     group,
 		addr:      addr,
+		acceptCh:  make(chan net.Conn),
 		closeCh:   make(chan struct{}),
 	}
 }
 
-// Accept will accept connections from TCPMuxGroup
+// Accept will accept connections chosen for this listener from TCPMuxGroup
 func (ln *TCPMuxGroupListener) Accept() (c net.Conn, err error) {
-	var ok bool
 	select {
 	case <-ln.closeCh:
 		return nil, ErrListenerClosed
-	case c, ok = <-ln.group.Accept():
-		if !ok {
-			return nil, ErrListenerClosed
-		}
+	case c = <-ln.acceptCh:
 		return c, nil
 	}
 }
@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	v1 "github.com/fatedier/frp/pkg/config/v1"
+)
+
+// Reasons a user connection is rejected by the connLimiter.
+const (
+	rejectMaxConnections = "max_connections"
+	rejectRate           = "rate"
+	rejectRatePerIP      = "rate_per_ip"
+)
+
+// the rate limiters of source IPs idle for this long are dropped
+const ipLimiterIdleTimeout = time.Minute
+
+// connLimiter limits the user connections of a proxy.
+type connLimiter struct {
+	maxConns int64
+	conns    atomic.Int64
+
+	rate *rate.Limiter
+
+	ratePerIP  int
+	ipLimiters map[string]*ipLimiter
+	lastSweep  time.Time
+	mu         sync.Mutex
+}
+
+type ipLimiter struct {
+	*rate.Limiter
+	lastSeen time.Time
+}
+
+// newConnLimiter returns nil if the proxy has no connection limits.
+func newConnLimiter(cfg *v1.ProxyTransport) *connLimiter {
+	if cfg.MaxConnections <= 0 && cfg.NewConnectionRate <= 0 && cfg.NewConnectionRatePerIP <= 0 {
+		return nil
+	}
+	l := &connLimiter{
+		maxConns:   int64(cfg.MaxConnections),
+		ratePerIP:  cfg.NewConnectionRatePerIP,
+		ipLimiters: make(map[string]*ipLimiter),
+		lastSweep:  time.Now(),
+	}
+	if cfg.NewConnectionRate > 0 {
+		l.rate = rate.NewLimiter(rate.Limit(cfg.NewConnectionRate), cfg.NewConnectionRate)
+	}
+	return l
+}
+
+// acquire takes a connection from addr. If it is allowed, release should be
+// called when it is closed, otherwise reason tells which limit rejected it.
+func (l *connLimiter) acquire(addr string) (release func(), reason string) {
+	if l == nil {
+		return func() {}, ""
+	}
+	if l.ratePerIP > 0 && !l.allowIP(addr) {
+		return nil, rejectRatePerIP
+	}
+	if l.rate != nil && !l.rate.Allow() {
+		return nil, rejectRate
+	}
+	if l.maxConns > 0 {
+		if l.conns.Add(1) > l.maxConns {
+			l.conns.Add(-1)
+			return nil, rejectMaxConnections
+		}
+		var once sync.Once
+		return func() {
+			once.Do(func() { l.conns.Add(-1) })
+		}, ""
+	}
+	return func() {}, ""
+}
+
+func (l *connLimiter) allowIP(addr string) bool {
+	ip := addr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		ip = host
+	}
+
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) > ipLimiterIdleTimeout {
+		for k, v := range l.ipLimiters {
+			if now.Sub(v.lastSeen) > ipLimiterIdleTimeout {
+				delete(l.ipLimiters, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	il, ok := l.ipLimiters[ip]
+	if !ok {
+		il = &ipLimiter{Limiter: rate.NewLimiter(rate.Limit(l.ratePerIP), l.ratePerIP)}
+		l.ipLimiters[ip] = il
+	}
+	il.lastSeen = now
+	return il.AllowN(now, 1)
+}
@@ -15,6 +15,7 @@
 package proxy
 
 import (
+	"fmt"
 	"io"
 	"net"
 	"reflect"
@@ -23,7 +24,6 @@ import (
 	libio "github.com/fatedier/golib/io"
 
 	v1 "github.com/fatedier/frp/pkg/config/v1"
-	"github.com/fatedier/frp/pkg/util/limit"
 	netpkg "github.com/fatedier/frp/pkg/util/net"
 	"github.com/fatedier/frp/pkg/util/util"
 	"github.com/fatedier/frp/pkg/util/vhost"
@@ -62,6 +62,9 @@ func (pxy *HTTPProxy) Run() (remoteAddr string, err error) {
 		Username:        pxy.cfg.HTTPUser,
 		Password:        pxy.cfg.HTTPPassword,
 		CreateConnFn:    pxy.GetRealConn,
+		AllowSourceFn:   pxy.allowSource,
+		// the limits apply to requests, not to the pooled work conns
+		AcquireRequestFn: pxy.acquireRequest,
 	}
 
 	locations := pxy.cfg.Locations
@@ -89,7 +92,7 @@ func (pxy *HTTPProxy) Run() (remoteAddr string, err error) {
 
 			// handle group
 			if pxy.cfg.LoadBalancer.Group != "" {
-				err = pxy.rc.HTTPGroupCtl.Register(pxy.name, pxy.cfg.LoadBalancer.Group, pxy.cfg.LoadBalancer.GroupKey, routeConfig)
+				err = pxy.rc.HTTPGroupCtl.Register(pxy.name, pxy.cfg.LoadBalancer, routeConfig)
 				if err != nil {
 					return
 				}
@@ -122,7 +125,7 @@ func (pxy *HTTPProxy) Run() (remoteAddr string, err error) {
 
 			// handle group
 			if pxy.cfg.LoadBalancer.Group != "" {
-				err = pxy.rc.HTTPGroupCtl.Register(pxy.name, pxy.cfg.LoadBalancer.Group, pxy.cfg.LoadBalancer.GroupKey, routeConfig)
+				err = pxy.rc.HTTPGroupCtl.Register(pxy.name, pxy.cfg.LoadBalancer, routeConfig)
 				if err != nil {
 					return
 				}
@@ -151,6 +154,11 @@ func (pxy *HTTPProxy) Run() (remoteAddr string, err error) {
 
 func (pxy *HTTPProxy) GetRealConn(remoteAddr string) (workConn net.Conn, err error) {
 	xl := pxy.xl
+	// members of a group may have their own rules
+	if err = pxy.checkUserConn(remoteAddr); err != nil {
+		xl.Infof("the user conn was rejected: %v", err)
+		return nil, err
+	}
 	rAddr, errRet := net.ResolveTCPAddr("tcp", remoteAddr)
 	if errRet != nil {
 		xl.Warnf("resolve TCP addr [%s] error: %v", remoteAddr, errRet)
@@ -175,18 +183,26 @@ func (pxy *HTTPProxy) GetRealConn(remoteAddr string) (workConn net.Conn, err err
 		rwc = libio.WithCompression(rwc)
 	}
 
-	if pxy.GetLimiter() != nil {
-		rwc = libio.WrapReadWriteCloser(limit.NewReader(rwc, pxy.GetLimiter()), limit.NewWriter(rwc, pxy.GetLimiter()), func() error {
-			return rwc.Close()
-		})
-	}
+	rwc = pxy.countTraffic(pxy.limitConn(rwc))
 
 	workConn = netpkg.WrapReadWriteCloserToConn(rwc, tmpConn)
-	workConn = netpkg.WrapStatsConn(workConn, pxy.updateStatsAfterClosedConn)
+	workConn = netpkg.WrapStatsConn(workConn, func(totalRead, totalWrite int64) {
+		pxy.updateStatsAfterClosedConn(totalRead, totalWrite)
+	})
 	metrics.Server.OpenConnection(pxy.GetName(), pxy.GetConfigurer().GetBaseConfig().Type)
 	return
 }
 
+// acquireRequest admits an HTTP request from addr. Requests share keep-alive
+// work conns, so the traffic quotas are checked for each of them.
+func (pxy *HTTPProxy) acquireRequest(addr string) (release func(), err error) {
+	if pxy.quotaExhausted() {
+		metrics.Server.RejectConnection(pxy.GetName(), pxy.cfg.Type, "quota")
+		return nil, fmt.Errorf("traffic quota is exhausted")
+	}
+	return pxy.acquireConnLimit(addr)
+}
+
 func (pxy *HTTPProxy) updateStatsAfterClosedConn(totalRead, totalWrite int64) {
 	name := pxy.GetName()
 	proxyType := pxy.GetConfigurer().GetBaseConfig().Type
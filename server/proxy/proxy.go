@@ -16,6 +16,7 @@ package proxy
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -33,9 +34,12 @@ import (
 	plugin "github.com/fatedier/frp/pkg/plugin/server"
 	"github.com/fatedier/frp/pkg/util/limit"
 	netpkg "github.com/fatedier/frp/pkg/util/net"
+	"github.com/fatedier/frp/pkg/util/vhost"
 	"github.com/fatedier/frp/pkg/util/xlog"
+	"github.com/fatedier/frp/server/bandwidth"
 	"github.com/fatedier/frp/server/controller"
 	"github.com/fatedier/frp/server/metrics"
+	"github.com/fatedier/frp/server/quota"
 )
 
 var proxyFactoryRegistry = map[reflect.Type]func(*BaseProxy) Proxy{}
@@ -55,8 +59,18 @@ type Proxy interface {
 	GetUsedPortsNum() int
 	GetResourceController() *controller.ResourceController
 	GetUserInfo() plugin.UserInfo
-	GetLimiter() *rate.Limiter
 	GetLoginMsg() *msg.Login
+	// GetFeatures returns the features negotiated with frpc.
+	GetFeatures() msg.Features
+	// GetUDPFraming returns the framing of UDP packets supported by the frpc
+	// of udp and sudp proxies.
+	GetUDPFraming() string
+	// GetDatagramSession returns the QUIC datagram session of the frpc of udp
+	// and sudp proxies, or nil if it can't send the UDP packets as datagrams.
+	GetDatagramSession() *netpkg.DatagramSession
+	// CloseWorkConns closes the work connections in use after the timeout,
+	// or immediately if it is 0.
+	CloseWorkConns(timeout time.Duration)
 	Close()
 }
 
@@ -68,10 +82,32 @@ type BaseProxy struct {
 	poolCount     int
 	getWorkConnFn GetWorkConnFn
 	serverCfg     *v1.ServerConfig
-	limiter       *rate.Limiter
+	upLimiter     *rate.Limiter
+	downLimiter   *rate.Limiter
 	userInfo      plugin.UserInfo
 	loginMsg      *msg.Login
 	configurer    v1.ProxyConfigurer
+	// a user address must pass all the filters
+	sourceFilters []*netpkg.IPFilter
+	connLimiter   *connLimiter
+	// traffic quotas of the proxy and its user
+	quotas       []*quota.Counter
+	quotaCancels []func()
+	// bandwidth limits shared with the other proxies of the client and user
+	sharedLimiters  []*bandwidth.Limiter
+	limiterReleases []func()
+	// features negotiated with frpc
+	features msg.Features
+	// codec of the messages written to frpc
+	msgCodec string
+	// framing of UDP packets supported by frpc
+	udpFraming string
+	// QUIC datagrams of frpc, nil if UDP packets are sent on work connections
+	datagrams *netpkg.DatagramSession
+
+	// work connections in use
+	workConns   map[net.Conn]struct{}
+	workConnsMu sync.Mutex
 
 	mu  sync.RWMutex
 	xl  *xlog.Logger
@@ -102,26 +138,194 @@ func (pxy *BaseProxy) GetLoginMsg() *msg.Login {
 	return pxy.loginMsg
 }
 
-func (pxy *BaseProxy) GetLimiter() *rate.Limiter {
-	return pxy.limiter
+func (pxy *BaseProxy) GetFeatures() msg.Features {
+	return pxy.features
+}
+
+func (pxy *BaseProxy) GetUDPFraming() string {
+	return pxy.udpFraming
+}
+
+func (pxy *BaseProxy) GetDatagramSession() *netpkg.DatagramSession {
+	return pxy.datagrams
+}
+
+// workConnUDPFraming returns the framing of UDP packets on the work connection
+// for dst. It is negotiated with the visitor for sudp proxies.
+func (pxy *BaseProxy) workConnUDPFraming(dst net.Addr) string {
+	if pxy.configurer.GetBaseConfig().Type == string(v1.ProxyTypeUDP) {
+		return pxy.udpFraming
+	}
+	return netpkg.GetUDPFraming(dst)
 }
 
 func (pxy *BaseProxy) GetConfigurer() v1.ProxyConfigurer {
 	return pxy.configurer
 }
 
+// allowSource reports whether a user from addr may connect to the proxy.
+func (pxy *BaseProxy) allowSource(addr string) bool {
+	for _, f := range pxy.sourceFilters {
+		if !f.Allowed(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// acquireUserConn checks the source IP rules and connection limits of the
+// proxy for a user connection from addr. If it is accepted, release should be
+// called when it is closed, otherwise the rejection is counted in metrics.
+func (pxy *BaseProxy) acquireUserConn(addr string) (release func(), err error) {
+	if err := pxy.checkUserConn(addr); err != nil {
+		return nil, err
+	}
+	return pxy.acquireConnLimit(addr)
+}
+
+// checkUserConn checks the source IP rules and traffic quotas of the proxy for
+// a user connection from addr.
+func (pxy *BaseProxy) checkUserConn(addr string) error {
+	proxyType := pxy.configurer.GetBaseConfig().Type
+	if !pxy.allowSource(addr) {
+		metrics.Server.RejectConnection(pxy.GetName(), proxyType, "source_ip")
+		return fmt.Errorf("source address [%s] is not allowed", addr)
+	}
+	if pxy.quotaExhausted() {
+		metrics.Server.RejectConnection(pxy.GetName(), proxyType, "quota")
+		return fmt.Errorf("traffic quota is exhausted")
+	}
+	return nil
+}
+
+// acquireConnLimit takes a connection from addr out of the connection limits
+// of the proxy. Rejections by a rate limit wrap vhost.ErrRateLimited.
+func (pxy *BaseProxy) acquireConnLimit(addr string) (release func(), err error) {
+	release, reason := pxy.connLimiter.acquire(addr)
+	if release == nil {
+		metrics.Server.RejectConnection(pxy.GetName(), pxy.configurer.GetBaseConfig().Type, reason)
+		if reason == rejectRate || reason == rejectRatePerIP {
+			return nil, fmt.Errorf("connection from [%s] exceeds the %s limit: %w", addr, reason, vhost.ErrRateLimited)
+		}
+		return nil, fmt.Errorf("connection from [%s] exceeds the %s limit", addr, reason)
+	}
+	return release, nil
+}
+
+func (pxy *BaseProxy) quotaExhausted() bool {
+	for _, c := range pxy.quotas {
+		if c.Exhausted() {
+			return true
+		}
+	}
+	return false
+}
+
+// addTraffic counts n bytes against the traffic quotas.
+func (pxy *BaseProxy) addTraffic(n int64) {
+	for _, c := range pxy.quotas {
+		c.Add(n)
+	}
+}
+
 func (pxy *BaseProxy) Close() {
 	xl := xlog.FromContextSafe(pxy.ctx)
 	xl.Infof("proxy closing")
 	for _, l := range pxy.listeners {
 		l.Close()
 	}
+	for _, cancel := range pxy.quotaCancels {
+		cancel()
+	}
+	for _, release := range pxy.limiterReleases {
+		release()
+	}
+}
+
+// limitConn applies the bandwidth limit of the proxy and the limits shared
+// with the other proxies of its client and user to rwc, the work connection
+// side. Reading from rwc is upload and writing to it is download.
+func (pxy *BaseProxy) limitConn(rwc io.ReadWriteCloser) io.ReadWriteCloser {
+	var (
+		r       io.Reader = rwc
+		w       io.Writer = rwc
+		limited bool
+	)
+	if pxy.upLimiter != nil {
+		r = limit.NewReader(r, pxy.upLimiter)
+		limited = true
+	}
+	if pxy.downLimiter != nil {
+		w = limit.NewWriter(w, pxy.downLimiter)
+		limited = true
+	}
+	for _, l := range pxy.sharedLimiters {
+		if l.Upload != nil {
+			r = limit.NewReader(r, l.Upload)
+			limited = true
+		}
+		if l.Download != nil {
+			w = limit.NewWriter(w, l.Download)
+			limited = true
+		}
+	}
+	if !limited {
+		return rwc
+	}
+	return libio.WrapReadWriteCloser(r, w, rwc.Close)
+}
+
+// countTraffic counts the traffic of rwc against the traffic quotas as it
+// flows, so a quota used up by a long connection closes it in time.
+func (pxy *BaseProxy) countTraffic(rwc io.ReadWriteCloser) io.ReadWriteCloser {
+	if len(pxy.quotas) == 0 {
+		return rwc
+	}
+	return libio.WrapReadWriteCloser(
+		&trafficReader{Reader: rwc, add: pxy.addTraffic},
+		&trafficWriter{Writer: rwc, add: pxy.addTraffic},
+		rwc.Close,
+	)
+}
+
+type trafficReader struct {
+	io.Reader
+	add func(int64)
+}
+
+func (r *trafficReader) Read(p []byte) (n int, err error) {
+	n, err = r.Reader.Read(p)
+	r.add(int64(n))
+	return
+}
+
+type trafficWriter struct {
+	io.Writer
+	add func(int64)
+}
+
+func (w *trafficWriter) Write(p []byte) (n int, err error) {
+	n, err = w.Writer.Write(p)
+	w.add(int64(n))
+	return
 }
 
 // GetWorkConnFromPool try to get a new work connections from pool
 // for quickly response, we immediately send the StartWorkConn message to frpc after take out one from pool
 func (pxy *BaseProxy) GetWorkConnFromPool(src, dst net.Addr) (workConn net.Conn, err error) {
+	workConn, _, err = pxy.getWorkConnFromPool(src, dst)
+	return
+}
+
+// getWorkConnFromPool also returns the flow of QUIC datagrams carrying the UDP
+// packets of the work connection, it is closed with the work connection.
+func (pxy *BaseProxy) getWorkConnFromPool(src, dst net.Addr) (workConn net.Conn, flow *netpkg.DatagramFlow, err error) {
 	xl := xlog.FromContextSafe(pxy.ctx)
+	flow = pxy.newUDPDatagramFlow(dst)
+	var flowID uint32
+	if flow != nil {
+		flowID = flow.ID()
+	}
 	// try all connections from the pool
 	for i := 0; i < pxy.poolCount+1; i++ {
 		if workConn, err = pxy.getWorkConnFn(); err != nil {
@@ -149,7 +353,8 @@ func (pxy *BaseProxy) GetWorkConnFromPool(src, dst net.Addr) (workConn net.Conn,
 			dstAddr, dstPortStr, _ = net.SplitHostPort(dst.String())
 			dstPort, _ = strconv.Atoi(dstPortStr)
 		}
-		err := msg.WriteMsg(workConn, &msg.StartWorkConn{
+		target := netpkg.GetTarget(dst)
+		err := msg.WriteMsgWithCodec(workConn, &msg.StartWorkConn{
 			ProxyName: pxy.GetName(),
 			SrcAddr:   srcAddr,
 			SrcPort:   uint16(srcPort),
@@ -157,8 +362,16 @@ func (pxy *BaseProxy) GetWorkConnFromPool(src, dst net.Addr) (workConn net.Conn,
 			DstPort:   uint16(dstPort),
 			Error:     "",
 
-			TargetAddr: netpkg.GetTarget(dst),
-		})
+			TargetAddr:        target.Addr,
+			TargetNetwork:     target.Network,
+			TargetDialTimeout: target.DialTimeout.Milliseconds(),
+			TargetDialReply:   target.DialReply,
+
+			VisitorUser: netpkg.GetVisitorUser(dst),
+			UDPFraming:  pxy.workConnUDPFraming(dst),
+
+			UDPDatagramID: flowID,
+		}, pxy.msgCodec)
 		if err != nil {
 			xl.Warnf("failed to send message to work connection from pool: %v, times: %d", err, i)
 			workConn.Close()
@@ -169,11 +382,96 @@ func (pxy *BaseProxy) GetWorkConnFromPool(src, dst net.Addr) (workConn net.Conn,
 
 	if err != nil {
 		xl.Errorf("try to get work connection failed in the end")
-		return
+		if flow != nil {
+			flow.Close()
+		}
+		return nil, nil, err
+	}
+	if flow != nil {
+		visitorFlow := netpkg.GetUDPDatagram(dst)
+		if visitorFlow != nil {
+			pxy.relayDatagrams(visitorFlow, flow)
+		}
+		workConn = netpkg.WrapCloseNotifyConn(workConn, func() {
+			flow.Close()
+			if visitorFlow != nil {
+				visitorFlow.Close()
+			}
+		})
 	}
+	workConn = pxy.trackWorkConn(workConn)
 	return
 }
 
+// newUDPDatagramFlow returns the flow of QUIC datagrams for the UDP packets of
+// the work connection to dst, or nil if they are sent on the work connection.
+func (pxy *BaseProxy) newUDPDatagramFlow(dst net.Addr) *netpkg.DatagramFlow {
+	switch pxy.configurer.GetBaseConfig().Type {
+	case string(v1.ProxyTypeUDP):
+		return pxy.datagrams.NewFlow()
+	case string(v1.ProxyTypeSUDP):
+		// the datagrams of the sudp visitor are relayed as they are
+		if netpkg.GetUDPDatagram(dst) != nil {
+			return pxy.datagrams.NewFlow()
+		}
+	}
+	return nil
+}
+
+// relayDatagrams relays the datagrams between the flows of a sudp visitor and
+// of the work connection until they are closed.
+func (pxy *BaseProxy) relayDatagrams(visitorFlow, flow *netpkg.DatagramFlow) {
+	name := pxy.GetName()
+	proxyType := pxy.configurer.GetBaseConfig().Type
+	relay := func(from, to *netpkg.DatagramFlow, addTrafficFn func(string, string, int64)) {
+		for b := range from.Receive() {
+			if pxy.quotaExhausted() {
+				continue
+			}
+			if err := to.Send(b); err != nil && !errors.Is(err, netpkg.ErrDatagramTooLarge) {
+				return
+			}
+			addTrafficFn(name, proxyType, int64(len(b)))
+			pxy.addTraffic(int64(len(b)))
+		}
+	}
+	go relay(visitorFlow, flow, metrics.Server.AddTrafficIn)
+	go relay(flow, visitorFlow, metrics.Server.AddTrafficOut)
+}
+
+func (pxy *BaseProxy) trackWorkConn(workConn net.Conn) net.Conn {
+	var tracked net.Conn
+	tracked = netpkg.WrapCloseNotifyConn(workConn, func() {
+		pxy.workConnsMu.Lock()
+		delete(pxy.workConns, tracked)
+		pxy.workConnsMu.Unlock()
+	})
+	pxy.workConnsMu.Lock()
+	pxy.workConns[tracked] = struct{}{}
+	pxy.workConnsMu.Unlock()
+	return tracked
+}
+
+func (pxy *BaseProxy) CloseWorkConns(timeout time.Duration) {
+	closeFn := func() {
+		pxy.workConnsMu.Lock()
+		conns := make([]net.Conn, 0, len(pxy.workConns))
+		for c := range pxy.workConns {
+			conns = append(conns, c)
+		}
+		pxy.workConnsMu.Unlock()
+		for _, c := range conns {
+			c.Close()
+		}
+	}
+	if timeout <= 0 {
+		closeFn()
+		return
+	}
+	xlog.FromContextSafe(pxy.ctx).Infof("proxy draining, work connections will be closed in %v", timeout)
+	time.AfterFunc(timeout, closeFn)
+}
+
 // startCommonTCPListenersHandler start a goroutine handler for each listener.
 func (pxy *BaseProxy) startCommonTCPListenersHandler() {
 	xl := xlog.FromContextSafe(pxy.ctx)
@@ -203,8 +501,11 @@ func (pxy *BaseProxy) startCommonTCPListenersHandler() {
 					xl.Warnf("listener is closed: %s", err)
 					return
 				}
-				xl.Infof("get a user connection [%s]", c.RemoteAddr().String())
-				go pxy.handleUserTCPConnection(c)
+				go func() {
+					// RemoteAddr may wait for a PROXY protocol header
+					xl.Infof("get a user connection [%s]", c.RemoteAddr().String())
+					pxy.handleUserTCPConnection(c)
+				}()
 			}
 		}(listener)
 	}
@@ -214,6 +515,16 @@ func (pxy *BaseProxy) startCommonTCPListenersHandler() {
 func (pxy *BaseProxy) handleUserTCPConnection(userConn net.Conn) {
 	xl := xlog.FromContextSafe(pxy.Context())
 	defer userConn.Close()
+	if flow := netpkg.GetUDPDatagram(userConn); flow != nil {
+		defer flow.Close()
+	}
+
+	release, err := pxy.acquireUserConn(userConn.RemoteAddr().String())
+	if err != nil {
+		xl.Infof("the user conn was rejected: %v", err)
+		return
+	}
+	defer release()
 
 	serverCfg := pxy.serverCfg
 	cfg := pxy.configurer.GetBaseConfig()
@@ -225,7 +536,7 @@ func (pxy *BaseProxy) handleUserTCPConnection(userConn net.Conn) {
 		ProxyType:  cfg.Type,
 		RemoteAddr: userConn.RemoteAddr().String(),
 	}
-	_, err := rc.PluginManager.NewUserConn(content)
+	_, err = rc.PluginManager.NewUserConn(content)
 	if err != nil {
 		xl.Warnf("the user conn [%s] was rejected, err:%v", content.RemoteAddr, err)
 		return
@@ -254,11 +565,7 @@ func (pxy *BaseProxy) handleUserTCPConnection(userConn net.Conn) {
 		defer recycleFn()
 	}
 
-	if pxy.GetLimiter() != nil {
-		local = libio.WrapReadWriteCloser(limit.NewReader(local, pxy.GetLimiter()), limit.NewWriter(local, pxy.GetLimiter()), func() error {
-			return local.Close()
-		})
-	}
+	local = pxy.countTraffic(pxy.limitConn(local))
 
 	xl.Debugf("join connections, workConn(l[%s] r[%s]) userConn(l[%s] r[%s])", workConn.LocalAddr().String(),
 		workConn.RemoteAddr().String(), userConn.LocalAddr().String(), userConn.RemoteAddr().String())
@@ -281,31 +588,86 @@ type Options struct {
 	GetWorkConnFn      GetWorkConnFn
 	Configurer         v1.ProxyConfigurer
 	ServerCfg          *v1.ServerConfig
+	Features           msg.Features
+	UDPFraming         string
+	Datagrams          *netpkg.DatagramSession
 }
 
 func NewProxy(ctx context.Context, options *Options) (pxy Proxy, err error) {
 	configurer := options.Configurer
 	xl := xlog.FromContextSafe(ctx).Spawn().AppendPrefix(configurer.GetBaseConfig().Name)
 
-	var limiter *rate.Limiter
-	limitBytes := configurer.GetBaseConfig().Transport.BandwidthLimit.Bytes()
-	if limitBytes > 0 && configurer.GetBaseConfig().Transport.BandwidthLimitMode == types.BandwidthLimitModeServer {
-		limiter = rate.NewLimiter(rate.Limit(float64(limitBytes)), int(limitBytes))
+	var upLimiter, downLimiter *rate.Limiter
+	if transport := configurer.GetBaseConfig().Transport; transport.BandwidthLimitMode == types.BandwidthLimitModeServer {
+		up, down := transport.BandwidthLimits()
+		upLimiter = limit.NewLimiter(up, transport.BandwidthLimitBurst.Bytes())
+		downLimiter = limit.NewLimiter(down, transport.BandwidthLimitBurst.Bytes())
+	}
+
+	sourceFilters, err := newSourceFilters(configurer.GetBaseConfig(), options.ServerCfg)
+	if err != nil {
+		return nil, err
 	}
 
 	basePxy := BaseProxy{
 		name:          configurer.GetBaseConfig().Name,
 		rc:            options.ResourceController,
 		listeners:     make([]net.Listener, 0),
+		workConns:     make(map[net.Conn]struct{}),
 		poolCount:     options.PoolCount,
 		getWorkConnFn: options.GetWorkConnFn,
 		serverCfg:     options.ServerCfg,
-		limiter:       limiter,
+		upLimiter:     upLimiter,
+		downLimiter:   downLimiter,
 		xl:            xl,
 		ctx:           xlog.NewContext(ctx, xl),
 		userInfo:      options.UserInfo,
 		loginMsg:      options.LoginMsg,
 		configurer:    configurer,
+		sourceFilters: sourceFilters,
+		connLimiter:   newConnLimiter(&configurer.GetBaseConfig().Transport),
+		features:      options.Features,
+		msgCodec:      options.Features.MsgCodec(),
+		udpFraming:    options.UDPFraming,
+	}
+	if rc := options.ResourceController; rc != nil && rc.TrafficQuotaManager != nil {
+		m := rc.TrafficQuotaManager
+		for _, c := range []*quota.Counter{
+			m.ProxyCounter(basePxy.name, configurer.GetBaseConfig().Transport.TrafficQuota),
+			m.UserCounter(options.UserInfo.User),
+		} {
+			if c == nil {
+				continue
+			}
+			basePxy.quotas = append(basePxy.quotas, c)
+			basePxy.quotaCancels = append(basePxy.quotaCancels, c.Subscribe(func() {
+				basePxy.CloseWorkConns(0)
+			}))
+		}
+	}
+
+	if rc := options.ResourceController; rc != nil && rc.BandwidthLimitManager != nil {
+		m := rc.BandwidthLimitManager
+		var runID string
+		if options.LoginMsg != nil {
+			runID = options.LoginMsg.RunID
+		}
+		clientLimiter, releaseClient := m.Client(runID)
+		userLimiter, releaseUser := m.User(options.UserInfo.User)
+		for _, l := range []*bandwidth.Limiter{clientLimiter, userLimiter} {
+			if l != nil {
+				basePxy.sharedLimiters = append(basePxy.sharedLimiters, l)
+			}
+		}
+		basePxy.limiterReleases = []func(){releaseClient, releaseUser}
+	}
+
+	// encryption, compression and bandwidth limits only apply to work
+	// connections, so the packets of such proxies stay on them
+	transport := configurer.GetBaseConfig().Transport
+	if !transport.UseEncryption && !transport.UseCompression &&
+		upLimiter == nil && downLimiter == nil && len(basePxy.sharedLimiters) == 0 {
+		basePxy.datagrams = options.Datagrams
 	}
 
 	factory := proxyFactoryRegistry[reflect.TypeOf(configurer)]
@@ -319,6 +681,35 @@ func NewProxy(ctx context.Context, options *Options) (pxy Proxy, err error) {
 	return pxy, nil
 }
 
+// newSourceFilters returns the source IP filters of a proxy. The server lists
+// are used if the proxy sets none, or in addition to the proxy lists if they
+// are forced.
+func newSourceFilters(cfg *v1.ProxyBaseConfig, serverCfg *v1.ServerConfig) ([]*netpkg.IPFilter, error) {
+	defaults := serverCfg.ProxySourceIPs
+	allow, deny := cfg.AllowSourceIPs, cfg.DenySourceIPs
+	if len(allow) == 0 && len(deny) == 0 && !defaults.Force {
+		allow, deny = defaults.Allow, defaults.Deny
+	}
+
+	var filters []*netpkg.IPFilter
+	f, err := netpkg.NewIPFilter(allow, deny)
+	if err != nil {
+		return nil, err
+	}
+	if f != nil {
+		filters = append(filters, f)
+	}
+	if defaults.Force {
+		if f, err = netpkg.NewIPFilter(defaults.Allow, defaults.Deny); err != nil {
+			return nil, err
+		}
+		if f != nil {
+			filters = append(filters, f)
+		}
+	}
+	return filters, nil
+}
+
 type Manager struct {
 	// proxies indexed by proxy name
 	pxys map[string]Proxy
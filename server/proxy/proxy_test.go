@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/fatedier/frp/pkg/config/types"
+	v1 "github.com/fatedier/frp/pkg/config/v1"
+	"github.com/fatedier/frp/server/quota"
+)
+
+func TestCloseWorkConns(t *testing.T) {
+	require := require.New(t)
+	pxy := &BaseProxy{
+		workConns: make(map[net.Conn]struct{}),
+		ctx:       context.Background(),
+	}
+
+	c1, peer1 := net.Pipe()
+	defer peer1.Close()
+	c2, peer2 := net.Pipe()
+	defer peer2.Close()
+	closed := pxy.trackWorkConn(c1)
+	inUse := pxy.trackWorkConn(c2)
+	require.Len(pxy.workConns, 2)
+	closed.Close()
+	require.Len(pxy.workConns, 1)
+
+	// draining keeps the connection in use until the timeout
+	pxy.CloseWorkConns(100 * time.Millisecond)
+	go func() {
+		_, _ = inUse.Write([]byte("a"))
+	}()
+	buf := make([]byte, 1)
+	_, err := peer2.Read(buf)
+	require.NoError(err)
+
+	require.Eventually(func() bool {
+		pxy.workConnsMu.Lock()
+		defer pxy.workConnsMu.Unlock()
+		return len(pxy.workConns) == 0
+	}, time.Second, 10*time.Millisecond)
+	_, err = peer2.Read(buf)
+	require.Error(err)
+}
+
+func TestCountTraffic(t *testing.T) {
+	require := require.New(t)
+	m, err := quota.NewManager(v1.TrafficQuotaServerConfig{})
+	require.NoError(err)
+	daily, err := types.NewByteQuantity("1KB")
+	require.NoError(err)
+	c := m.ProxyCounter("p", v1.TrafficQuota{Daily: daily, CloseConnections: true})
+
+	pxy := &BaseProxy{
+		workConns: make(map[net.Conn]struct{}),
+		ctx:       context.Background(),
+		quotas:    []*quota.Counter{c},
+	}
+	c.Subscribe(func() { pxy.CloseWorkConns(0) })
+
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	go func() {
+		_, _ = io.Copy(io.Discard, peer)
+	}()
+	rwc := pxy.countTraffic(pxy.trackWorkConn(conn))
+
+	// the traffic is counted before the connection is closed
+	_, err = rwc.Write(make([]byte, 1000))
+	require.NoError(err)
+	require.False(c.Exhausted())
+	_, err = rwc.Write(make([]byte, 100))
+	require.NoError(err)
+	require.True(c.Exhausted())
+	_, err = rwc.Write([]byte("a"))
+	require.Error(err)
+}
+
+func TestSourceFilters(t *testing.T) {
+	require := require.New(t)
+	serverCfg := &v1.ServerConfig{
+		ProxySourceIPs: v1.ProxySourceIPsConfig{Deny: []string{"10.0.0.0/8"}},
+	}
+	allowed := func(cfg *v1.ProxyBaseConfig, addr string) bool {
+		filters, err := newSourceFilters(cfg, serverCfg)
+		require.NoError(err)
+		pxy := &BaseProxy{sourceFilters: filters}
+		return pxy.allowSource(addr)
+	}
+
+	// server defaults apply to proxies without rules
+	require.False(allowed(&v1.ProxyBaseConfig{}, "10.0.0.1:1000"))
+	require.True(allowed(&v1.ProxyBaseConfig{}, "192.168.0.1:1000"))
+
+	cfg := &v1.ProxyBaseConfig{AllowSourceIPs: []string{"10.0.0.0/16"}}
+	require.True(allowed(cfg, "10.0.0.1:1000"))
+	require.False(allowed(cfg, "192.168.0.1:1000"))
+
+	// forced server rules apply in addition to the proxy rules
+	serverCfg.ProxySourceIPs.Force = true
+	require.False(allowed(cfg, "10.0.0.1:1000"))
+	require.False(allowed(&v1.ProxyBaseConfig{}, "10.0.0.1:1000"))
+}
+
+func TestConnLimiter(t *testing.T) {
+	require := require.New(t)
+	require.Nil(newConnLimiter(&v1.ProxyTransport{}))
+
+	l := newConnLimiter(&v1.ProxyTransport{MaxConnections: 2})
+	r1, _ := l.acquire("1.1.1.1:1000")
+	require.NotNil(r1)
+	r2, _ := l.acquire("1.1.1.1:1001")
+	require.NotNil(r2)
+	r3, reason := l.acquire("2.2.2.2:1000")
+	require.Nil(r3)
+	require.Equal(rejectMaxConnections, reason)
+	r1()
+	r1()
+	r3, _ = l.acquire("2.2.2.2:1000")
+	require.NotNil(r3)
+
+	l = newConnLimiter(&v1.ProxyTransport{NewConnectionRatePerIP: 2})
+	for range 2 {
+		r, _ := l.acquire("1.1.1.1:1000")
+		require.NotNil(r)
+	}
+	r, reason := l.acquire("1.1.1.1:1002")
+	require.Nil(r)
+	require.Equal(rejectRatePerIP, reason)
+	r, _ = l.acquire("2.2.2.2:1000")
+	require.NotNil(r)
+
+	l = newConnLimiter(&v1.ProxyTransport{NewConnectionRate: 1})
+	r, _ = l.acquire("1.1.1.1:1000")
+	require.NotNil(r)
+	r, reason = l.acquire("2.2.2.2:1000")
+	require.Nil(r)
+	require.Equal(rejectRate, reason)
+}
@@ -21,6 +21,7 @@ import (
 	"strconv"
 
 	v1 "github.com/fatedier/frp/pkg/config/v1"
+	netpkg "github.com/fatedier/frp/pkg/util/net"
 )
 
 func init() {
@@ -49,7 +50,7 @@ func NewTCPProxy(baseProxy *BaseProxy) Proxy {
 func (pxy *TCPProxy) Run() (remoteAddr string, err error) {
 	xl := pxy.xl
 	if pxy.cfg.LoadBalancer.Group != "" {
-		l, realBindPort, errRet := pxy.rc.TCPGroupCtl.Listen(pxy.name, pxy.cfg.LoadBalancer.Group, pxy.cfg.LoadBalancer.GroupKey,
+		l, realBindPort, errRet := pxy.rc.TCPGroupCtl.Listen(pxy.name, pxy.cfg.LoadBalancer,
 			pxy.serverCfg.ProxyBindAddr, pxy.cfg.RemotePort)
 		if errRet != nil {
 			err = errRet
@@ -78,6 +79,7 @@ func (pxy *TCPProxy) Run() (remoteAddr string, err error) {
 			err = errRet
 			return
 		}
+		listener = netpkg.NewProxyProtocolListener(listener, pxy.rc.ProxyProtocolTrustedNets)
 		pxy.listeners = append(pxy.listeners, listener)
 		xl.Infof("tcp proxy listen port [%d]", pxy.cfg.RemotePort)
 	}
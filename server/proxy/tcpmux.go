@@ -57,8 +57,8 @@ func (pxy *TCPMuxProxy) httpConnectListen(
 		Password:        httpPwd,
 	}
 	if pxy.cfg.LoadBalancer.Group != "" {
-		l, err = pxy.rc.TCPMuxGroupCtl.Listen(pxy.ctx, pxy.cfg.Multiplexer,
-			pxy.cfg.LoadBalancer.Group, pxy.cfg.LoadBalancer.GroupKey, *routeConfig)
+		l, err = pxy.rc.TCPMuxGroupCtl.Listen(pxy.ctx, pxy.name, pxy.cfg.Multiplexer,
+			pxy.cfg.LoadBalancer, *routeConfig)
 	} else {
 		l, err = pxy.rc.TCPMuxHTTPConnectMuxer.Listen(pxy.ctx, routeConfig)
 	}
@@ -29,7 +29,6 @@ import (
 	v1 "github.com/fatedier/frp/pkg/config/v1"
 	"github.com/fatedier/frp/pkg/msg"
 	"github.com/fatedier/frp/pkg/proto/udp"
-	"github.com/fatedier/frp/pkg/util/limit"
 	netpkg "github.com/fatedier/frp/pkg/util/net"
 	"github.com/fatedier/frp/server/metrics"
 )
@@ -108,7 +107,7 @@ func (pxy *UDPProxy) Run() (remoteAddr string, err error) {
 	pxy.checkCloseCh = make(chan int)
 
 	// read message from workConn, if it returns any error, notify proxy to start a new workConn
-	workConnReaderFn := func(conn net.Conn) {
+	workConnReaderFn := func(conn *udp.MsgConn) {
 		for {
 			var (
 				rawMsg msg.Message
@@ -116,7 +115,7 @@ func (pxy *UDPProxy) Run() (remoteAddr string, err error) {
 			)
 			xl.Tracef("loop waiting message from udp workConn")
 			// client will send heartbeat in workConn for keeping alive
-			if rawMsg, errRet = msg.ReadMsgTimeout(conn, 60*time.Second); errRet != nil {
+			if rawMsg, errRet = conn.ReadMsgTimeout(60 * time.Second); errRet != nil {
 				xl.Warnf("read from workConn for udp error: %v", errRet)
 				_ = conn.Close()
 				// notify proxy to start a new work connection
@@ -133,13 +132,15 @@ func (pxy *UDPProxy) Run() (remoteAddr string, err error) {
 				continue
 			case *msg.UDPPacket:
 				if errRet := errors.PanicToError(func() {
-					xl.Tracef("get udp message from workConn: %s", m.Content)
+					size := int64(udp.PayloadSize(m))
+					xl.Tracef("get udp message from workConn, %d bytes", size)
 					pxy.readCh <- m
 					metrics.Server.AddTrafficOut(
 						pxy.GetName(),
 						pxy.GetConfigurer().GetBaseConfig().Type,
-						int64(len(m.Content)),
+						size,
 					)
+					pxy.addTraffic(size)
 				}); errRet != nil {
 					conn.Close()
 					xl.Infof("reader goroutine for udp work connection closed")
@@ -150,7 +151,7 @@ func (pxy *UDPProxy) Run() (remoteAddr string, err error) {
 	}
 
 	// send message to workConn
-	workConnSenderFn := func(conn net.Conn, ctx context.Context) {
+	workConnSenderFn := func(conn *udp.MsgConn, ctx context.Context) {
 		var errRet error
 		for {
 			select {
@@ -159,17 +160,27 @@ func (pxy *UDPProxy) Run() (remoteAddr string, err error) {
 					xl.Infof("sender goroutine for udp work connection closed")
 					return
 				}
-				if errRet = msg.WriteMsg(conn, udpMsg); errRet != nil {
+				if udpMsg.RemoteAddr != nil && !pxy.allowSource(udpMsg.RemoteAddr.String()) {
+					xl.Tracef("drop udp message from [%s] rejected by the source IP rules", udpMsg.RemoteAddr)
+					continue
+				}
+				if pxy.quotaExhausted() {
+					xl.Tracef("drop udp message, traffic quota is exhausted")
+					continue
+				}
+				if errRet = conn.WriteMsg(udpMsg); errRet != nil {
 					xl.Infof("sender goroutine for udp work connection closed: %v", errRet)
 					conn.Close()
 					return
 				}
-				xl.Tracef("send message to udp workConn: %s", udpMsg.Content)
+				size := int64(udp.PayloadSize(udpMsg))
+				xl.Tracef("send message to udp workConn, %d bytes", size)
 				metrics.Server.AddTrafficIn(
 					pxy.GetName(),
 					pxy.GetConfigurer().GetBaseConfig().Type,
-					int64(len(udpMsg.Content)),
+					size,
 				)
+				pxy.addTraffic(size)
 				continue
 			case <-ctx.Done():
 				xl.Infof("sender goroutine for udp work connection closed")
@@ -182,7 +193,7 @@ func (pxy *UDPProxy) Run() (remoteAddr string, err error) {
 		// Sleep a while for waiting control send the NewProxyResp to client.
 		time.Sleep(500 * time.Millisecond)
 		for {
-			workConn, err := pxy.GetWorkConnFromPool(nil, nil)
+			workConn, flow, err := pxy.getWorkConnFromPool(nil, nil)
 			if err != nil {
 				time.Sleep(1 * time.Second)
 				// check if proxy is closed
@@ -213,16 +224,16 @@ func (pxy *UDPProxy) Run() (remoteAddr string, err error) {
 				rwc = libio.WithCompression(rwc)
 			}
 
-			if pxy.GetLimiter() != nil {
-				rwc = libio.WrapReadWriteCloser(limit.NewReader(rwc, pxy.GetLimiter()), limit.NewWriter(rwc, pxy.GetLimiter()), func() error {
-					return rwc.Close()
-				})
-			}
+			rwc = pxy.limitConn(rwc)
 
 			pxy.workConn = netpkg.WrapReadWriteCloserToConn(rwc, workConn)
+			msgConn := udp.NewMsgConn(pxy.workConn, pxy.udpFraming)
+			if flow != nil {
+				msgConn.UseDatagrams(flow)
+			}
 			ctx, cancel := context.WithCancel(context.Background())
-			go workConnReaderFn(pxy.workConn)
-			go workConnSenderFn(pxy.workConn, ctx)
+			go workConnReaderFn(msgConn)
+			go workConnSenderFn(msgConn, ctx)
 			_, ok := <-pxy.checkCloseCh
 			cancel()
 			if !ok {
@@ -0,0 +1,242 @@
+package quota
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	v1 "github.com/fatedier/frp/pkg/config/v1"
+	"github.com/fatedier/frp/pkg/util/log"
+)
+
+const saveInterval = 30 * time.Second
+
+// usage is the traffic counted in the current day and month.
+type usage struct {
+	Day        string `json:"day"`
+	DayBytes   int64  `json:"day_bytes"`
+	Month      string `json:"month"`
+	MonthBytes int64  `json:"month_bytes"`
+}
+
+func (u *usage) roll(now time.Time) {
+	if day := now.Format("2006-01-02"); u.Day != day {
+		u.Day = day
+		u.DayBytes = 0
+	}
+	if month := now.Format("2006-01"); u.Month != month {
+		u.Month = month
+		u.MonthBytes = 0
+	}
+}
+
+// Manager tracks the traffic of proxies and users against their quotas.
+type Manager struct {
+	users     map[string]v1.TrafficQuota
+	stateFile string
+
+	// usages indexed by counter key, including the ones of proxies not
+	// registered now
+	usages map[string]*usage
+	// counters indexed by key, shared by the proxies counting against the
+	// same quota so they are all notified when it is exhausted
+	counters map[string]*Counter
+	dirty    bool
+	now      func() time.Time
+	mu       sync.Mutex
+}
+
+func NewManager(cfg v1.TrafficQuotaServerConfig) (*Manager, error) {
+	m := &Manager{
+		users:     make(map[string]v1.TrafficQuota),
+		stateFile: cfg.StateFile,
+		usages:    make(map[string]*usage),
+		counters:  make(map[string]*Counter),
+		now:       time.Now,
+	}
+	for _, u := range cfg.Users {
+		m.users[u.User] = u.TrafficQuota
+	}
+	if m.stateFile != "" {
+		b, err := os.ReadFile(m.stateFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if len(b) > 0 {
+			if err := json.Unmarshal(b, &m.usages); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return m, nil
+}
+
+// ProxyCounter returns the counter of a proxy, or nil if it has no quota.
+func (m *Manager) ProxyCounter(name string, quota v1.TrafficQuota) *Counter {
+	if !quota.IsSet() {
+		return nil
+	}
+	return m.counter("proxy/"+name, quota)
+}
+
+// UserCounter returns the counter shared by the proxies of a user, or nil if
+// the user has no quota.
+func (m *Manager) UserCounter(user string) *Counter {
+	quota, ok := m.users[user]
+	if !ok || !quota.IsSet() {
+		return nil
+	}
+	return m.counter("user/"+user, quota)
+}
+
+func (m *Manager) counter(key string, quota v1.TrafficQuota) *Counter {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c, ok := m.counters[key]; ok {
+		// the quota of a proxy may change when it is registered again
+		c.quota = quota
+		return c
+	}
+	u, ok := m.usages[key]
+	if !ok {
+		u = &usage{}
+		m.usages[key] = u
+	}
+	c := &Counter{
+		key:         key,
+		m:           m,
+		quota:       quota,
+		usage:       u,
+		subscribers: make(map[int]func()),
+	}
+	m.counters[key] = c
+	return c
+}
+
+// Run saves the usages to the state file periodically until ctx is done.
+func (m *Manager) Run(ctx context.Context) {
+	if m.stateFile == "" {
+		return
+	}
+	ticker := time.NewTicker(saveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := m.Save(); err != nil {
+				log.Warnf("save traffic quota state error: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Save writes the usages to the state file if they have changed.
+func (m *Manager) Save() error {
+	if m.stateFile == "" {
+		return nil
+	}
+	m.mu.Lock()
+	if !m.dirty {
+		m.mu.Unlock()
+		return nil
+	}
+	b, err := json.Marshal(m.usages)
+	m.dirty = false
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// replace the file at once so a crash doesn't leave it truncated
+	tmp, err := os.CreateTemp(filepath.Dir(m.stateFile), filepath.Base(m.stateFile)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), m.stateFile)
+}
+
+// Counter counts the traffic of a proxy or user. A nil Counter has no quota.
+type Counter struct {
+	key   string
+	m     *Manager
+	quota v1.TrafficQuota
+	usage *usage
+
+	subscribers map[int]func()
+	nextID      int
+}
+
+// Exhausted reports whether the daily or monthly quota is used up.
+func (c *Counter) Exhausted() bool {
+	if c == nil {
+		return false
+	}
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+	c.usage.roll(c.m.now())
+	return c.exhausted()
+}
+
+func (c *Counter) exhausted() bool {
+	return (c.quota.Daily.Bytes() > 0 && c.usage.DayBytes >= c.quota.Daily.Bytes()) ||
+		(c.quota.Monthly.Bytes() > 0 && c.usage.MonthBytes >= c.quota.Monthly.Bytes())
+}
+
+// Add counts n bytes. If a quota is used up and CloseConnections is set, the
+// subscribers are notified.
+func (c *Counter) Add(n int64) {
+	if c == nil || n <= 0 {
+		return
+	}
+	c.m.mu.Lock()
+	c.usage.roll(c.m.now())
+	c.usage.DayBytes += n
+	c.usage.MonthBytes += n
+	c.m.dirty = true
+	var notify []func()
+	if c.quota.CloseConnections && c.exhausted() {
+		for _, fn := range c.subscribers {
+			notify = append(notify, fn)
+		}
+	}
+	c.m.mu.Unlock()
+
+	if len(notify) > 0 {
+		log.Infof("traffic quota of [%s] is exhausted, close its connections", c.key)
+	}
+	for _, fn := range notify {
+		fn()
+	}
+}
+
+// Subscribe registers fn to be called when the quota is exhausted, cancel
+// removes it.
+func (c *Counter) Subscribe(fn func()) (cancel func()) {
+	if c == nil {
+		return func() {}
+	}
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+	id := c.nextID
+	c.nextID++
+	c.subscribers[id] = fn
+	return func() {
+		c.m.mu.Lock()
+		defer c.m.mu.Unlock()
+		delete(c.subscribers, id)
+	}
+}
@@ -0,0 +1,90 @@
+package quota
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/fatedier/frp/pkg/config/types"
+	v1 "github.com/fatedier/frp/pkg/config/v1"
+)
+
+const kb = 1024
+
+func mustByteQuantity(t *testing.T, s string) types.ByteQuantity {
+	q, err := types.NewByteQuantity(s)
+	require.NoError(t, err)
+	return q
+}
+
+func TestCounter(t *testing.T) {
+	require := require.New(t)
+	stateFile := filepath.Join(t.TempDir(), "quota.json")
+	cfg := v1.TrafficQuotaServerConfig{
+		StateFile: stateFile,
+		Users: []v1.UserTrafficQuota{{
+			User:         "alice",
+			TrafficQuota: v1.TrafficQuota{Monthly: mustByteQuantity(t, "100KB")},
+		}},
+	}
+	now := time.Date(2024, 1, 31, 12, 0, 0, 0, time.Local)
+
+	m, err := NewManager(cfg)
+	require.NoError(err)
+	m.now = func() time.Time { return now }
+	require.Nil(m.UserCounter("bob"))
+	require.Nil(m.ProxyCounter("ssh", v1.TrafficQuota{}))
+
+	c := m.ProxyCounter("ssh", v1.TrafficQuota{Daily: mustByteQuantity(t, "10KB"), CloseConnections: true})
+	closed := 0
+	cancel := c.Subscribe(func() { closed++ })
+	c.Add(5 * kb)
+	require.False(c.Exhausted())
+	require.Equal(0, closed)
+	c.Add(5 * kb)
+	require.True(c.Exhausted())
+	require.Equal(1, closed)
+	cancel()
+	c.Add(1 * kb)
+	require.Equal(1, closed)
+
+	u := m.UserCounter("alice")
+	u.Add(60 * kb)
+	require.False(u.Exhausted())
+
+	// usages are restored from the state file
+	require.NoError(m.Save())
+	m, err = NewManager(cfg)
+	require.NoError(err)
+	m.now = func() time.Time { return now }
+	c = m.ProxyCounter("ssh", v1.TrafficQuota{Daily: mustByteQuantity(t, "10KB")})
+	require.True(c.Exhausted())
+	u = m.UserCounter("alice")
+	u.Add(40 * kb)
+	require.True(u.Exhausted())
+
+	// the daily quota is reset the next day, the monthly one the next month
+	now = now.Add(24 * time.Hour)
+	require.False(c.Exhausted())
+	require.False(u.Exhausted())
+}
+
+func TestUserCounterShared(t *testing.T) {
+	require := require.New(t)
+	m, err := NewManager(v1.TrafficQuotaServerConfig{
+		Users: []v1.UserTrafficQuota{{
+			User:         "alice",
+			TrafficQuota: v1.TrafficQuota{Daily: mustByteQuantity(t, "10KB"), CloseConnections: true},
+		}},
+	})
+	require.NoError(err)
+
+	// the idle proxy is notified when another proxy of the user uses up the quota
+	busy, idle := m.UserCounter("alice"), m.UserCounter("alice")
+	closed := 0
+	idle.Subscribe(func() { closed++ })
+	busy.Add(10 * kb)
+	require.Equal(1, closed)
+}
@@ -45,11 +45,13 @@ import (
 	"github.com/fatedier/frp/pkg/util/version"
 	"github.com/fatedier/frp/pkg/util/vhost"
 	"github.com/fatedier/frp/pkg/util/xlog"
+	"github.com/fatedier/frp/server/bandwidth"
 	"github.com/fatedier/frp/server/controller"
 	"github.com/fatedier/frp/server/group"
 	"github.com/fatedier/frp/server/metrics"
 	"github.com/fatedier/frp/server/ports"
 	"github.com/fatedier/frp/server/proxy"
+	"github.com/fatedier/frp/server/quota"
 	"github.com/fatedier/frp/server/visitor"
 )
 
@@ -129,6 +131,14 @@ func NewService(cfg *v1.ServerConfig) (*Service, error) {
 	if err != nil {
 		return nil, err
 	}
+	proxyProtocolTrustedNets, err := netpkg.ParseCIDRs(cfg.ProxyProtocolTrustedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	trafficQuotaManager, err := quota.NewManager(cfg.TrafficQuota)
+	if err != nil {
+		return nil, fmt.Errorf("load traffic quota state error: %v", err)
+	}
 
 	svr := &Service{
 		ctlManager:    NewControlManager(),
@@ -138,6 +148,10 @@ func NewService(cfg *v1.ServerConfig) (*Service, error) {
 			VisitorManager: visitor.NewManager(),
 			TCPPortManager: ports.NewManager("tcp", cfg.ProxyBindAddr, cfg.AllowPorts),
 			UDPPortManager: ports.NewManager("udp", cfg.ProxyBindAddr, cfg.AllowPorts),
+
+			ProxyProtocolTrustedNets: proxyProtocolTrustedNets,
+			TrafficQuotaManager:      trafficQuotaManager,
+			BandwidthLimitManager:    bandwidth.NewManager(cfg.BandwidthLimit),
 		},
 		httpVhostRouter: vhost.NewRouters(),
 		authVerifier:    auth.NewAuthVerifier(cfg.Auth),
@@ -154,6 +168,7 @@ func NewService(cfg *v1.ServerConfig) (*Service, error) {
 		if err != nil {
 			return nil, fmt.Errorf("create server listener error, %v", err)
 		}
+		l = netpkg.NewProxyProtocolListener(l, svr.rc.ProxyProtocolTrustedNets)
 
 		svr.rc.TCPMuxHTTPConnectMuxer, err = tcpmux.NewHTTPConnectTCPMuxer(l, cfg.TCPMuxPassthrough, vhostReadWriteTimeout)
 		if err != nil {
@@ -170,7 +185,7 @@ func NewService(cfg *v1.ServerConfig) (*Service, error) {
 	svr.rc.PluginManager = svr.pluginManager
 
 	// Init group controller
-	svr.rc.TCPGroupCtl = group.NewTCPGroupCtl(svr.rc.TCPPortManager)
+	svr.rc.TCPGroupCtl = group.NewTCPGroupCtl(svr.rc.TCPPortManager, svr.rc.ProxyProtocolTrustedNets)
 
 	// Init HTTP group controller
 	svr.rc.HTTPGroupCtl = group.NewHTTPGroupController(svr.httpVhostRouter)
@@ -216,7 +231,7 @@ func NewService(cfg *v1.ServerConfig) (*Service, error) {
 	// Listen for accepting connections from client using kcp protocol.
 	if cfg.KCPBindPort > 0 {
 		address := net.JoinHostPort(cfg.BindAddr, strconv.Itoa(cfg.KCPBindPort))
-		svr.kcpListener, err = netpkg.ListenKcp(address)
+		svr.kcpListener, err = netpkg.ListenKcp(address, &cfg.Transport.KCP)
 		if err != nil {
 			return nil, fmt.Errorf("listen on kcp udp address %s error: %v", address, err)
 		}
@@ -231,6 +246,7 @@ func NewService(cfg *v1.ServerConfig) (*Service, error) {
 			MaxIdleTimeout:     time.Duration(cfg.Transport.QUIC.MaxIdleTimeout) * time.Second,
 			MaxIncomingStreams: int64(cfg.Transport.QUIC.MaxIncomingStreams),
 			KeepAlivePeriod:    time.Duration(cfg.Transport.QUIC.KeepalivePeriod) * time.Second,
+			EnableDatagrams:    true,
 		})
 		if err != nil {
 			return nil, fmt.Errorf("listen on quic udp address %s error: %v", address, err)
@@ -259,6 +275,8 @@ func NewService(cfg *v1.ServerConfig) (*Service, error) {
 
 	// Create http vhost muxer.
 	if cfg.VhostHTTPPort > 0 {
+		// keep the affinity cookies of http groups valid across restarts
+		vhost.SetAffinitySecret(cfg.Auth.Token)
 		rp := vhost.NewHTTPReverseProxy(vhost.HTTPReverseProxyOptions{
 			ResponseHeaderTimeoutS: cfg.VhostHTTPTimeout,
 		}, svr.httpVhostRouter)
@@ -278,6 +296,7 @@ func NewService(cfg *v1.ServerConfig) (*Service, error) {
 			if err != nil {
 				return nil, fmt.Errorf("create vhost http listener error, %v", err)
 			}
+			l = netpkg.NewProxyProtocolListener(l, svr.rc.ProxyProtocolTrustedNets)
 		}
 		go func() {
 			_ = server.Serve(l)
@@ -296,6 +315,7 @@ func NewService(cfg *v1.ServerConfig) (*Service, error) {
 			if err != nil {
 				return nil, fmt.Errorf("create server listener error, %v", err)
 			}
+			l = netpkg.NewProxyProtocolListener(l, svr.rc.ProxyProtocolTrustedNets)
 			log.Infof("https service listen on %s", address)
 		}
 
@@ -342,6 +362,7 @@ func (svr *Service) Run(ctx context.Context) {
 	if svr.listener != nil {
 		go svr.HandleListener(svr.listener, false)
 	}
+	go svr.rc.TrafficQuotaManager.Run(svr.ctx)
 
 	<-svr.ctx.Done()
 	// service context may not be canceled by svr.Close(), we should call it here to release resources
@@ -372,6 +393,9 @@ func (svr *Service) Close() error {
 		svr.listener = nil
 	}
 	svr.ctlManager.Close()
+	if err := svr.rc.TrafficQuotaManager.Save(); err != nil {
+		log.Warnf("save traffic quota state error: %v", err)
+	}
 	if svr.cancel != nil {
 		svr.cancel()
 	}
@@ -420,7 +444,14 @@ func (svr *Service) handleConnection(ctx context.Context, conn net.Conn, interna
 			conn.Close()
 		}
 	case *msg.NewVisitorConn:
-		if err = svr.RegisterVisitorConn(netpkg.WrapConnTarget(conn, m.TargetAddr), m); err != nil {
+		target := netpkg.Target{
+			Addr:        m.TargetAddr,
+			Network:     m.TargetNetwork,
+			DialTimeout: time.Duration(m.TargetDialTimeout) * time.Millisecond,
+			DialReply:   m.TargetDialReply && svr.proxyHasFeature(m.ProxyName, msg.FeatureTargetDialReply),
+		}
+		udpFraming, udpDatagramID, err := svr.RegisterVisitorConn(netpkg.WrapConnTarget(conn, target), m)
+		if err != nil {
 			xl.Warnf("register visitor conn error: %v", err)
 			_ = msg.WriteMsg(conn, &msg.NewVisitorConnResp{
 				ProxyName: m.ProxyName,
@@ -429,8 +460,12 @@ func (svr *Service) handleConnection(ctx context.Context, conn net.Conn, interna
 			conn.Close()
 		} else {
 			_ = msg.WriteMsg(conn, &msg.NewVisitorConnResp{
-				ProxyName: m.ProxyName,
-				Error:     "",
+				ProxyName:     m.ProxyName,
+				Error:         "",
+				UDPFraming:    udpFraming,
+				UDPDatagramID: udpDatagramID,
+
+				TargetDialReply: target.DialReply,
 			})
 		}
 	default:
@@ -510,6 +545,7 @@ func (svr *Service) HandleQUICListener(l *quic.Listener) {
 		}
 		// Start a new goroutine to handle connection.
 		go func(ctx context.Context, frpConn quic.Connection) {
+			datagrams := netpkg.NewDatagramSession(frpConn)
 			for {
 				stream, err := frpConn.AcceptStream(context.Background())
 				if err != nil {
@@ -517,7 +553,7 @@ func (svr *Service) HandleQUICListener(l *quic.Listener) {
 					_ = frpConn.CloseWithError(0, "")
 					return
 				}
-				go svr.handleConnection(ctx, netpkg.QuicStreamToNetConn(stream, frpConn), false)
+				go svr.handleConnection(ctx, netpkg.QuicStreamToNetConn(stream, frpConn, datagrams), false)
 			}
 		}(context.Background(), c)
 	}
@@ -607,17 +643,51 @@ func (svr *Service) RegisterWorkConn(workConn net.Conn, newMsg *msg.NewWorkConn)
 	return ctl.RegisterWorkConn(workConn)
 }
 
-func (svr *Service) RegisterVisitorConn(visitorConn net.Conn, newMsg *msg.NewVisitorConn) error {
+// proxyHasFeature returns whether feature is negotiated with the frpc of the
+// proxy.
+func (svr *Service) proxyHasFeature(name string, feature string) bool {
+	pxy, ok := svr.pxyManager.GetByName(name)
+	return ok && pxy.GetFeatures().Has(feature)
+}
+
+// RegisterVisitorConn hands the visitor connection to the proxy it visits and
+// returns the framing of UDP packets used on it and the flow of QUIC datagrams
+// carrying them, if any.
+func (svr *Service) RegisterVisitorConn(visitorConn net.Conn, newMsg *msg.NewVisitorConn) (
+	udpFraming string, udpDatagramID uint32, err error,
+) {
 	visitorUser := ""
 	// TODO(deprecation): Compatible with old versions, can be without runID, user is empty. In later versions, it will be mandatory to include runID.
 	// If runID is required, it is not compatible with versions prior to v0.50.0.
 	if newMsg.RunID != "" {
 		ctl, exist := svr.ctlManager.GetByID(newMsg.RunID)
 		if !exist {
-			return fmt.Errorf("no client control found for run id [%s]", newMsg.RunID)
+			return "", 0, fmt.Errorf("no client control found for run id [%s]", newMsg.RunID)
 		}
 		visitorUser = ctl.loginMsg.User
 	}
-	return svr.rc.VisitorManager.NewConn(newMsg.ProxyName, visitorConn, newMsg.Timestamp, newMsg.SignKey,
-		newMsg.UseEncryption, newMsg.UseCompression, visitorUser)
+
+	var udpDatagram *netpkg.DatagramFlow
+	if pxy, ok := svr.pxyManager.GetByName(newMsg.ProxyName); ok {
+		// the framing is only used if both the visitor and the frpc of the
+		// proxy support it
+		if newMsg.UDPFraming != "" && pxy.GetUDPFraming() == newMsg.UDPFraming {
+			udpFraming = newMsg.UDPFraming
+		}
+		// the datagrams are relayed as they are, so they can't be encrypted or
+		// compressed by the visitor
+		if newMsg.UDPDatagram && !newMsg.UseEncryption && !newMsg.UseCompression && pxy.GetDatagramSession() != nil {
+			udpDatagram = netpkg.GetDatagramSession(visitorConn).NewFlow()
+		}
+	}
+	err = svr.rc.VisitorManager.NewConn(newMsg.ProxyName, visitorConn, newMsg.Timestamp, newMsg.SignKey,
+		newMsg.UseEncryption, newMsg.UseCompression, visitorUser, udpFraming, udpDatagram)
+	if udpDatagram != nil {
+		if err != nil {
+			udpDatagram.Close()
+			return udpFraming, 0, err
+		}
+		udpDatagramID = udpDatagram.ID()
+	}
+	return udpFraming, udpDatagramID, err
 }
@@ -64,7 +64,8 @@ func (vm *Manager) Listen(name string, sk string, allowUsers []string) (*netpkg.
 }
 
 func (vm *Manager) NewConn(name string, conn net.Conn, timestamp int64, signKey string,
-	useEncryption bool, useCompression bool, visitorUser string,
+	useEncryption bool, useCompression bool, visitorUser string, udpFraming string,
+	udpDatagram *netpkg.DatagramFlow,
 ) (err error) {
 	vm.mu.RLock()
 	defer vm.mu.RUnlock()
@@ -90,7 +91,13 @@ func (vm *Manager) NewConn(name string, conn net.Conn, timestamp int64, signKey
 		if useCompression {
 			rwc = libio.WithCompression(rwc)
 		}
-		err = l.l.PutConn(netpkg.WrapConnTarget(netpkg.WrapReadWriteCloserToConn(rwc, conn), netpkg.GetTarget(conn)))
+		err = l.l.PutConn(&netpkg.ConnExtra{
+			Conn:        netpkg.WrapReadWriteCloserToConn(rwc, conn),
+			Target:      netpkg.GetTarget(conn),
+			VisitorUser: visitorUser,
+			UDPFraming:  udpFraming,
+			UDPDatagram: udpDatagram,
+		})
 	} else {
 		err = fmt.Errorf("custom listener for [%s] doesn't exist", name)
 		return